@@ -0,0 +1,210 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"drift/internal/config"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// CookieName is the name of the session cookie
+const CookieName = "drift_session"
+
+// ErrInvalidCredentials is returned when a login fails. It does not say
+// whether the user exists.
+var ErrInvalidCredentials = errors.New("invalid credentials")
+
+// dummyHash is compared against when a user does not exist, so that a
+// failed login takes as long whether or not the name is known
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("drift"), bcrypt.DefaultCost)
+
+// Session is a logged in user
+type Session struct {
+	ID      string
+	User    string
+	Role    string
+	Expires time.Time
+}
+
+// Admin reports whether the session may change the configuration, replay
+// and clear requests
+func (s *Session) Admin() bool {
+	return s != nil && s.Role == config.RoleAdmin
+}
+
+// Authenticator checks the access token and static users of the auth
+// settings and keeps the sessions they open in memory, so restarting DRIFT
+// logs everybody out
+type Authenticator struct {
+	token string
+	users map[string]config.User
+	ttl   time.Duration
+
+	mu       sync.Mutex
+	sessions map[string]*Session
+}
+
+// New creates an authenticator, generating an access token when the
+// settings do not set one
+func New(settings config.AuthConfig) (*Authenticator, error) {
+	a := &Authenticator{
+		token:    settings.Token,
+		users:    make(map[string]config.User),
+		ttl:      time.Duration(settings.SessionTTL),
+		sessions: make(map[string]*Session),
+	}
+	if a.token == "" {
+		token, err := randomID()
+		if err != nil {
+			return nil, err
+		}
+		a.token = token
+	}
+	for _, user := range settings.Users {
+		a.users[user.Name] = user
+	}
+	return a, nil
+}
+
+// Token returns the access token, which logs in as an admin
+func (a *Authenticator) Token() string {
+	return a.token
+}
+
+// TTL returns how long sessions last
+func (a *Authenticator) TTL() time.Duration {
+	return a.ttl
+}
+
+// validToken compares a token with the access token in constant time
+func (a *Authenticator) validToken(token string) bool {
+	return token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(a.token)) == 1
+}
+
+// LoginToken opens an admin session for the access token
+func (a *Authenticator) LoginToken(token string) (*Session, error) {
+	if !a.validToken(token) {
+		return nil, ErrInvalidCredentials
+	}
+	return a.open("token", config.RoleAdmin)
+}
+
+// LoginPassword opens a session for a static user
+func (a *Authenticator) LoginPassword(name, password string) (*Session, error) {
+	user, ok := a.users[name]
+	hash := []byte(user.PasswordHash)
+	if !ok {
+		hash = dummyHash
+	}
+	if err := bcrypt.CompareHashAndPassword(hash, []byte(password)); err != nil || !ok {
+		return nil, ErrInvalidCredentials
+	}
+	return a.open(user.Name, user.Role)
+}
+
+func (a *Authenticator) open(user, role string) (*Session, error) {
+	id, err := randomID()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	session := &Session{ID: id, User: user, Role: role, Expires: now.Add(a.ttl)}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for id, s := range a.sessions {
+		if now.After(s.Expires) {
+			delete(a.sessions, id)
+		}
+	}
+	a.sessions[session.ID] = session
+	return session, nil
+}
+
+// Logout closes a session
+func (a *Authenticator) Logout(id string) {
+	a.mu.Lock()
+	delete(a.sessions, id)
+	a.mu.Unlock()
+}
+
+// Authenticate returns the session of a request, from its session cookie or
+// an Authorization: Bearer header carrying the access token, or nil
+func (a *Authenticator) Authenticate(r *http.Request) *Session {
+	if scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " "); ok && strings.EqualFold(scheme, "bearer") {
+		if a.validToken(strings.TrimSpace(token)) {
+			return &Session{User: "token", Role: config.RoleAdmin}
+		}
+		return nil
+	}
+
+	cookie, err := r.Cookie(CookieName)
+	if err != nil {
+		return nil
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	session, ok := a.sessions[cookie.Value]
+	if !ok {
+		return nil
+	}
+	if time.Now().After(session.Expires) {
+		delete(a.sessions, cookie.Value)
+		return nil
+	}
+	return session
+}
+
+// SetCookie sends the session cookie. It is not readable from scripts and
+// not sent with cross-site subrequests.
+func (a *Authenticator) SetCookie(w http.ResponseWriter, session *Session) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     CookieName,
+		Value:    session.ID,
+		Path:     "/",
+		MaxAge:   int(a.ttl.Seconds()),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// ClearCookie removes the session cookie
+func ClearCookie(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     CookieName,
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+type sessionKey struct{}
+
+// WithSession attaches a session to a request context
+func WithSession(ctx context.Context, session *Session) context.Context {
+	return context.WithValue(ctx, sessionKey{}, session)
+}
+
+// FromContext returns the session attached to a request context
+func FromContext(ctx context.Context) *Session {
+	session, _ := ctx.Value(sessionKey{}).(*Session)
+	return session
+}
+
+func randomID() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
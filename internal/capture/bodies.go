@@ -0,0 +1,145 @@
+package capture
+
+import (
+	"errors"
+	"os"
+	"sync"
+)
+
+// ErrBodyNotFound is returned when a spilled body was never stored or has
+// since been evicted
+var ErrBodyNotFound = errors.New("body not found")
+
+// StoredBody describes a body spilled to disk
+type StoredBody struct {
+	Path            string
+	Size            int64
+	ContentType     string
+	ContentEncoding string
+	// Filename is the name a multipart file part was uploaded with
+	Filename string
+}
+
+// BodyStore keeps bodies too large to capture in memory in temporary files.
+// The oldest files are removed once the total size exceeds the limit.
+type BodyStore struct {
+	mu     sync.Mutex
+	dir    string
+	limit  int64
+	total  int64
+	bodies map[string]StoredBody
+	order  []string
+}
+
+// NewBodyStore creates a store holding at most limit bytes. The directory is
+// created on first use.
+func NewBodyStore(limit int64) *BodyStore {
+	return &BodyStore{
+		limit:  limit,
+		bodies: make(map[string]StoredBody),
+	}
+}
+
+// Create returns a new empty file for a body being spilled. The caller adds
+// it with Add once written, or removes it.
+func (s *BodyStore) Create() (*os.File, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.dir == "" {
+		dir, err := os.MkdirTemp("", "drift-bodies-")
+		if err != nil {
+			return nil, err
+		}
+		s.dir = dir
+	}
+	return os.CreateTemp(s.dir, "body-*")
+}
+
+// Add registers a spilled body under key and evicts the oldest bodies when
+// the store grows past its limit
+func (s *BodyStore) Add(key string, body StoredBody) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if old, ok := s.bodies[key]; ok {
+		s.total -= old.Size
+		os.Remove(old.Path)
+	} else {
+		s.order = append(s.order, key)
+	}
+	s.bodies[key] = body
+	s.total += body.Size
+
+	for s.limit > 0 && s.total > s.limit && len(s.order) > 1 {
+		oldest := s.order[0]
+		s.order = s.order[1:]
+		if evicted, ok := s.bodies[oldest]; ok {
+			s.total -= evicted.Size
+			os.Remove(evicted.Path)
+			delete(s.bodies, oldest)
+		}
+	}
+}
+
+// Save stores a file part uploaded as filename under key
+func (s *BodyStore) Save(key string, data []byte, contentType, filename string) error {
+	file, err := s.Create()
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(data)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(file.Name())
+		return err
+	}
+	s.Add(key, StoredBody{
+		Path:        file.Name(),
+		Size:        int64(len(data)),
+		ContentType: contentType,
+		Filename:    filename,
+	})
+	return nil
+}
+
+// Open returns the body stored under key, opened for reading
+func (s *BodyStore) Open(key string) (*os.File, StoredBody, error) {
+	s.mu.Lock()
+	body, ok := s.bodies[key]
+	s.mu.Unlock()
+	if !ok {
+		return nil, body, ErrBodyNotFound
+	}
+
+	file, err := os.Open(body.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		err = ErrBodyNotFound
+	}
+	return file, body, err
+}
+
+// SetLimit changes the maximum total size of the store
+func (s *BodyStore) SetLimit(limit int64) {
+	s.mu.Lock()
+	s.limit = limit
+	s.mu.Unlock()
+}
+
+// Close removes every stored body
+func (s *BodyStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.bodies = make(map[string]StoredBody)
+	s.order = nil
+	s.total = 0
+	if s.dir == "" {
+		return nil
+	}
+	dir := s.dir
+	s.dir = ""
+	return os.RemoveAll(dir)
+}
@@ -0,0 +1,177 @@
+package capture
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	writeWait    = 10 * time.Second
+	pingInterval = 30 * time.Second
+)
+
+// Client is a WebSocket connection with its own buffered send queue
+type Client struct {
+	conn *websocket.Conn
+	send chan []byte
+	once sync.Once
+	quit chan struct{}
+	done chan struct{}
+}
+
+// HubStats counts WebSocket clients
+type HubStats struct {
+	Clients int    `json:"clients"`
+	Evicted uint64 `json:"evicted"`
+}
+
+// Hub fans messages out to WebSocket clients. Each client has a buffered
+// send queue drained by its own writer, so a slow client never delays the
+// others; a client whose queue fills up is evicted.
+type Hub struct {
+	mu        sync.Mutex
+	clients   map[*Client]struct{}
+	queueSize int
+	evicted   atomic.Uint64
+}
+
+// NewHub creates a hub whose clients buffer up to queueSize messages
+func NewHub(queueSize int) *Hub {
+	if queueSize < 1 {
+		queueSize = 1
+	}
+	return &Hub{
+		clients:   make(map[*Client]struct{}),
+		queueSize: queueSize,
+	}
+}
+
+// Register adds a connection to the hub and starts its writer
+func (h *Hub) Register(conn *websocket.Conn) *Client {
+	client := &Client{
+		conn: conn,
+		send: make(chan []byte, h.queueSize),
+		quit: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+
+	h.mu.Lock()
+	h.clients[client] = struct{}{}
+	h.mu.Unlock()
+
+	go client.writePump()
+	return client
+}
+
+// Unregister removes a client and closes its connection
+func (h *Hub) Unregister(client *Client) {
+	h.mu.Lock()
+	delete(h.clients, client)
+	h.mu.Unlock()
+	client.close()
+}
+
+// Broadcast queues a message for every client without blocking. Clients
+// whose queue is full are evicted.
+func (h *Hub) Broadcast(message []byte) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for client := range h.clients {
+		select {
+		case client.send <- message:
+		default:
+			delete(h.clients, client)
+			h.evicted.Add(1)
+			go client.evict()
+		}
+	}
+}
+
+// CloseAll sends a close frame to every client and disconnects it
+func (h *Hub) CloseAll(reason string) {
+	message := websocket.FormatCloseMessage(websocket.CloseGoingAway, reason)
+
+	h.mu.Lock()
+	clients := h.clients
+	h.clients = make(map[*Client]struct{})
+	h.mu.Unlock()
+
+	for client := range clients {
+		// Let the writer flush what is already queued first
+		close(client.send)
+		select {
+		case <-client.done:
+		case <-time.After(writeWait):
+		}
+		client.conn.WriteControl(websocket.CloseMessage, message, time.Now().Add(time.Second))
+		client.conn.Close()
+	}
+}
+
+// Stats returns the current client counters
+func (h *Hub) Stats() HubStats {
+	h.mu.Lock()
+	clients := len(h.clients)
+	h.mu.Unlock()
+	return HubStats{Clients: clients, Evicted: h.evicted.Load()}
+}
+
+// evict disconnects a client that could not keep up
+func (c *Client) evict() {
+	message := websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "client too slow")
+	c.conn.WriteControl(websocket.CloseMessage, message, time.Now().Add(time.Second))
+	c.close()
+}
+
+func (c *Client) close() {
+	c.once.Do(func() {
+		close(c.quit)
+		c.conn.Close()
+	})
+}
+
+// writePump writes queued messages and keepalive pings to the connection
+func (c *Client) writePump() {
+	defer close(c.done)
+	ticker := time.NewTicker(pingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.quit:
+			return
+		case message, ok := <-c.send:
+			if !ok {
+				return
+			}
+			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := c.conn.WriteMessage(websocket.TextMessage, message); err != nil {
+				c.close()
+				return
+			}
+		case <-ticker.C:
+			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				c.close()
+				return
+			}
+		}
+	}
+}
+
+// BroadcastSink returns a sink that sends each entry to the hub's clients as JSON
+func BroadcastSink[T any](hub *Hub) Sink[T] {
+	return SinkFunc[T](func(entry T) {
+		message, err := json.Marshal(entry)
+		if err != nil {
+			fmt.Printf("Error marshaling log: %v\n", err)
+			return
+		}
+		hub.Broadcast(message)
+	})
+}
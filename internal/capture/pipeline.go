@@ -0,0 +1,277 @@
+package capture
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+)
+
+// Overflow policies applied when the capture queue is full
+const (
+	DropOldest = "drop_oldest"
+	DropNewest = "drop_newest"
+	Spill      = "spill"
+)
+
+// Sink receives every captured entry, in order
+type Sink[T any] interface {
+	Write(entry T)
+}
+
+// SinkFunc adapts a function to the Sink interface
+type SinkFunc[T any] func(entry T)
+
+// Write implements Sink
+func (f SinkFunc[T]) Write(entry T) { f(entry) }
+
+// Stats counts what happened to captured entries
+type Stats struct {
+	Captured   uint64 `json:"captured"`
+	Delivered  uint64 `json:"delivered"`
+	Dropped    uint64 `json:"dropped"`
+	Spilled    uint64 `json:"spilled"`
+	Queued     int    `json:"queued"`
+	SpillQueue int    `json:"spill_queued"`
+	Policy     string `json:"policy"`
+}
+
+// Pipeline decouples capture from the request path. Publish never blocks:
+// entries are queued in memory and delivered to the sinks by a single
+// goroutine. When the queue is full the overflow policy decides whether the
+// oldest or the newest entry is dropped, or whether entries spill to disk.
+type Pipeline[T any] struct {
+	mu       sync.Mutex
+	queue    []T
+	capacity int
+	policy   string
+	closed   bool
+	// overflow holds the entries to spill until the delivery goroutine
+	// writes them to disk, and spilling counts those and the entries on
+	// disk, which are all delivered after the queue
+	overflow []T
+	spilling int
+	// spill is only used by the delivery goroutine, so that Publish never
+	// waits for the disk
+	spill *spillFile[T]
+
+	sinks  []Sink[T]
+	notify chan struct{}
+	done   chan struct{}
+
+	captured  atomic.Uint64
+	delivered atomic.Uint64
+	dropped   atomic.Uint64
+	spilled   atomic.Uint64
+}
+
+// NewPipeline creates a pipeline holding up to capacity entries in memory
+// and starts delivering to sinks
+func NewPipeline[T any](capacity int, policy string, sinks ...Sink[T]) *Pipeline[T] {
+	if capacity < 1 {
+		capacity = 1
+	}
+	p := &Pipeline[T]{
+		capacity: capacity,
+		policy:   policy,
+		sinks:    sinks,
+		notify:   make(chan struct{}, 1),
+		done:     make(chan struct{}),
+	}
+	go p.run()
+	return p
+}
+
+// Publish queues an entry without blocking
+func (p *Pipeline[T]) Publish(entry T) {
+	if !p.enqueue(entry) {
+		return
+	}
+	select {
+	case p.notify <- struct{}{}:
+	default:
+	}
+}
+
+// enqueue queues an entry or applies the overflow policy, reporting whether
+// the pipeline still accepts entries
+func (p *Pipeline[T]) enqueue(entry T) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		p.dropped.Add(1)
+		return false
+	}
+	p.captured.Add(1)
+
+	switch {
+	case p.spilling > 0:
+		// Entries keep spilling until the spilled ones are delivered, even
+		// after the policy changed, so that they are delivered in order
+		p.overflow = append(p.overflow, entry)
+		p.spilling++
+	case len(p.queue) < p.capacity:
+		p.queue = append(p.queue, entry)
+	case p.policy == Spill:
+		p.overflow = append(p.overflow, entry)
+		p.spilling++
+	case p.policy == DropNewest:
+		p.dropped.Add(1)
+	default:
+		// Drop the oldest entry to make room
+		var zero T
+		p.queue[0] = zero
+		p.queue = append(p.queue[1:], entry)
+		p.dropped.Add(1)
+	}
+	return true
+}
+
+// writeOverflow moves the entries waiting to be spilled to the spill file.
+// Entries that cannot be written are dropped.
+func (p *Pipeline[T]) writeOverflow() {
+	p.mu.Lock()
+	batch := p.overflow
+	p.overflow = nil
+	p.mu.Unlock()
+	if len(batch) == 0 {
+		return
+	}
+
+	failed := 0
+	for _, entry := range batch {
+		if err := p.spillEntry(entry); err != nil {
+			failed++
+		}
+	}
+	p.spilled.Add(uint64(len(batch) - failed))
+	if failed > 0 {
+		p.dropped.Add(uint64(failed))
+		p.mu.Lock()
+		p.spilling -= failed
+		p.mu.Unlock()
+	}
+}
+
+// spillEntry writes an entry to the spill file
+func (p *Pipeline[T]) spillEntry(entry T) error {
+	if p.spill == nil {
+		spill, err := newSpillFile[T]()
+		if err != nil {
+			return err
+		}
+		p.spill = spill
+	}
+	return p.spill.write(entry)
+}
+
+// SetPolicy changes the overflow policy of a running pipeline
+func (p *Pipeline[T]) SetPolicy(policy string) {
+	p.mu.Lock()
+	p.policy = policy
+	p.mu.Unlock()
+}
+
+// Stats returns the current counters
+func (p *Pipeline[T]) Stats() Stats {
+	p.mu.Lock()
+	stats := Stats{
+		Queued: len(p.queue),
+		Policy: p.policy,
+	}
+	stats.SpillQueue = p.spilling
+	p.mu.Unlock()
+
+	stats.Captured = p.captured.Load()
+	stats.Delivered = p.delivered.Load()
+	stats.Dropped = p.dropped.Load()
+	stats.Spilled = p.spilled.Load()
+	return stats
+}
+
+// next returns the next entry to deliver, waiting for one if needed. It
+// returns false once the pipeline is closed and empty. Spilled entries are
+// written and read back here, without holding p.mu.
+func (p *Pipeline[T]) next() (T, bool) {
+	for {
+		p.writeOverflow()
+
+		p.mu.Lock()
+		if len(p.queue) > 0 {
+			entry := p.queue[0]
+			var zero T
+			p.queue[0] = zero
+			p.queue = p.queue[1:]
+			p.mu.Unlock()
+			return entry, true
+		}
+		closed := p.closed
+		p.mu.Unlock()
+
+		if p.spill != nil && p.spill.pending > 0 {
+			entry, err := p.spill.read()
+			p.mu.Lock()
+			p.spilling--
+			p.mu.Unlock()
+			if err != nil {
+				p.dropped.Add(1)
+				continue
+			}
+			return entry, true
+		}
+
+		p.mu.Lock()
+		waiting := len(p.overflow) > 0
+		p.mu.Unlock()
+		switch {
+		case waiting:
+			// Published while the spill file was being read
+		case closed:
+			var zero T
+			return zero, false
+		default:
+			<-p.notify
+		}
+	}
+}
+
+func (p *Pipeline[T]) run() {
+	defer close(p.done)
+	for {
+		entry, ok := p.next()
+		if !ok {
+			return
+		}
+		for _, sink := range p.sinks {
+			sink.Write(entry)
+		}
+		p.delivered.Add(1)
+	}
+}
+
+// Close stops accepting entries and waits until the queued and spilled
+// entries have been delivered or ctx expires
+func (p *Pipeline[T]) Close(ctx context.Context) error {
+	p.mu.Lock()
+	p.closed = true
+	p.mu.Unlock()
+
+	select {
+	case p.notify <- struct{}{}:
+	default:
+	}
+
+	var err error
+	select {
+	case <-p.done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	// The spill file belongs to the delivery goroutine until it returns
+	if p.spill != nil && err == nil {
+		p.spill.remove()
+		p.spill = nil
+	}
+
+	return err
+}
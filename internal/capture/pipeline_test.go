@@ -0,0 +1,74 @@
+package capture
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestPipelinePolicyChangeWhileSpilling(t *testing.T) {
+	arrived := make(chan int)
+	release := make(chan struct{})
+	var delivered []int
+	p := NewPipeline[int](1, Spill, SinkFunc[int](func(entry int) {
+		arrived <- entry
+		<-release
+		delivered = append(delivered, entry)
+	}))
+
+	waitFor := func(want int) {
+		t.Helper()
+		select {
+		case got := <-arrived:
+			if got != want {
+				t.Fatalf("sink received %d, want %d", got, want)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("sink did not receive %d", want)
+		}
+	}
+
+	// 1 is held by the sink, 2 fills the queue and 3 spills
+	p.Publish(1)
+	waitFor(1)
+	p.Publish(2)
+	p.Publish(3)
+
+	// Delivering 2 empties the queue while 3 is still spilled
+	release <- struct{}{}
+	waitFor(2)
+	if stats := p.Stats(); stats.Queued != 0 || stats.SpillQueue != 1 {
+		t.Fatalf("queued %d, spilled %d, want 0 and 1", stats.Queued, stats.SpillQueue)
+	}
+
+	p.SetPolicy(DropOldest)
+	p.Publish(4)
+	p.Publish(5)
+
+	go func() {
+		for range arrived {
+			release <- struct{}{}
+		}
+	}()
+	release <- struct{}{}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := p.Close(ctx); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	close(arrived)
+
+	want := []int{1, 2, 3, 4, 5}
+	if len(delivered) != len(want) {
+		t.Fatalf("delivered %v, want %v", delivered, want)
+	}
+	for i := range want {
+		if delivered[i] != want[i] {
+			t.Fatalf("delivered %v, want %v", delivered, want)
+		}
+	}
+	if stats := p.Stats(); stats.Dropped != 0 {
+		t.Errorf("dropped %d entries, want 0", stats.Dropped)
+	}
+}
@@ -0,0 +1,76 @@
+package capture
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+)
+
+// spillFile is an on-disk FIFO of JSON lines used when the memory queue is
+// full. It is reset whenever it has been read completely.
+type spillFile[T any] struct {
+	path    string
+	writer  *os.File
+	reader  *os.File
+	buffer  *bufio.Reader
+	pending int
+}
+
+func newSpillFile[T any]() (*spillFile[T], error) {
+	writer, err := os.CreateTemp("", "drift-spill-*.jsonl")
+	if err != nil {
+		return nil, err
+	}
+	reader, err := os.Open(writer.Name())
+	if err != nil {
+		writer.Close()
+		os.Remove(writer.Name())
+		return nil, err
+	}
+	return &spillFile[T]{
+		path:   writer.Name(),
+		writer: writer,
+		reader: reader,
+		buffer: bufio.NewReader(reader),
+	}, nil
+}
+
+func (s *spillFile[T]) write(entry T) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	if _, err := s.writer.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	s.pending++
+	return nil
+}
+
+func (s *spillFile[T]) read() (T, error) {
+	var entry T
+	line, err := s.buffer.ReadBytes('\n')
+	s.pending--
+	if s.pending == 0 {
+		s.reset()
+	}
+	if err != nil {
+		return entry, err
+	}
+	err = json.Unmarshal(line, &entry)
+	return entry, err
+}
+
+// reset truncates the file once every spilled entry has been read
+func (s *spillFile[T]) reset() {
+	s.writer.Truncate(0)
+	s.writer.Seek(0, 0)
+	s.reader.Seek(0, 0)
+	s.buffer.Reset(s.reader)
+}
+
+func (s *spillFile[T]) remove() {
+	s.writer.Close()
+	s.reader.Close()
+	os.Remove(s.path)
+}
@@ -5,6 +5,8 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"strings"
+	"time"
 
 	"drift/internal/config"
 	"drift/internal/models"
@@ -20,6 +22,16 @@ func Execute(staticFiles embed.FS, version string) {
 	// Define subcommand for "serve"
 	serveCmd := flag.NewFlagSet("serve", flag.ExitOnError)
 	portFlag := serveCmd.String("p", "", "Port to run the server on")
+	adminPortFlag := serveCmd.String("admin-port", "", "Loopback port of the inspector and admin API")
+	configFlag := serveCmd.String("config", "", "Path to the configuration file")
+	profileFlag := serveCmd.String("profile", "", "Configuration profile to use")
+	drainFlag := serveCmd.Duration("drain-timeout", 0, "How long shutdown waits for in-flight requests")
+	relayFlag := serveCmd.String("relay", "", "Expose DRIFT through a relay started with drift relay (e.g. wss://relay.example.com)")
+	relayTokenFlag := serveCmd.String("relay-token", "", "Token of the relay (or DRIFT_RELAY_TOKEN)")
+	relayNameFlag := serveCmd.String("relay-name", "", "Subdomain or path to ask the relay for")
+	releaseOnExitFlag := serveCmd.Bool("release-on-exit", false, "Release the zrok share DRIFT reserved when it stops, instead of keeping it")
+	var protoFlag stringList
+	serveCmd.Var(&protoFlag, "proto-descriptors", "FileDescriptorSet file used to decode protobuf and gRPC (repeatable)")
 
 	// Parse top-level flags
 	flag.Parse()
@@ -48,13 +60,17 @@ func Execute(staticFiles embed.FS, version string) {
 	case "serve":
 		// Parse flags for the "serve" command
 		serveCmd.Parse(args[1:])
-		StartServer(*portFlag, staticFiles)
+		StartServer(serveFlags{port: *portFlag, adminPort: *adminPortFlag, drainTimeout: *drainFlag, protoDescriptors: protoFlag,
+			relay: *relayFlag, relayToken: *relayTokenFlag, relayName: *relayNameFlag, releaseOnExit: *releaseOnExitFlag},
+			config.LoadOptions{Path: *configFlag, Profile: *profileFlag}, staticFiles)
 	case "update":
-		Update(version)
+		Update(version, args[1:])
 	case "release":
-		args := flag.Args()
-		all := len(args) > 1 && args[1] == "all"
-		Release(all)
+		Release(args[1:])
+	case "config":
+		Config(args[1:])
+	case "relay":
+		Relay(args[1:])
 
 	default:
 		fmt.Printf("Unknown command: %s\n", args[0])
@@ -70,32 +86,129 @@ func ShowHelp() {
 	fmt.Println("\nCommands:")
 	fmt.Println("  serve [flags]  Start DRIFT server")
 	fmt.Println("    -p PORT      Port to run the server on (overrides default and environment variable)")
-	fmt.Println("  update         Update DRIFT to the latest version")
-	fmt.Println("  release        Release a reserved zrok token")
+	fmt.Println("    --admin-port PORT  Loopback port of the inspector and admin API (default 4041)")
+	fmt.Println("    --config F   Path to the configuration file")
+	fmt.Println("    --profile P  Configuration profile to use")
+	fmt.Println("    --drain-timeout D  How long shutdown waits for in-flight requests (e.g. 30s)")
+	fmt.Println("    --proto-descriptors F  FileDescriptorSet used to decode protobuf and gRPC (repeatable)")
+	fmt.Println("    --relay URL  Expose DRIFT through a relay started with drift relay")
+	fmt.Println("    --relay-token T  Token of the relay")
+	fmt.Println("    --relay-name N  Subdomain or path to ask the relay for")
+	fmt.Println("    --release-on-exit  Release the zrok share DRIFT reserved when it stops")
+	fmt.Println("  update [flags]  Update DRIFT to the latest version")
+	fmt.Println("    --check      Report whether an update is available as JSON (exit status 2 when there is one)")
+	fmt.Println("    --to VERSION  Install this version instead of the latest, e.g. v0.1.4")
+	fmt.Println("    --rollback   Restore the binary replaced by the last update")
+	fmt.Println("  release [all|TOKEN...] [flags]  Release reserved zrok tokens, interactively without arguments")
+	fmt.Println("  release list [--json]  List reserved zrok tokens and whether DRIFT created them")
+	fmt.Println("    --unused     Only tokens that are not active")
+	fmt.Println("    --not-owned-by-drift  Only tokens DRIFT did not create")
+	fmt.Println("    --match S    Only tokens whose token, URL, backend or project contains S")
+	fmt.Println("    --dry-run    Show the tokens that would be released")
+	fmt.Println("  config validate  Check the configuration file for errors")
+	fmt.Println("  relay [flags]  Run a self-hosted relay for drift serve --relay")
+	fmt.Println("    --listen ADDR  Address to listen on (default :8080)")
+	fmt.Println("    --token T    Token that clients must present")
+	fmt.Println("    --domain D   Serve tunnels at <name>.D instead of /t/<name>/ only")
+	fmt.Println("    --tls-cert F, --tls-key F  Serve HTTPS and WSS")
 	fmt.Println("  help           Show help information")
 	fmt.Println("\nGlobal Flags:")
 	fmt.Println("  -v             Show version information")
 	fmt.Println("  -h             Show help information")
 	fmt.Println("\nEnvironment Variables:")
 	fmt.Println("  DRIFT_PORT     Set the server port")
+	fmt.Println("  DRIFT_HOST     Set the address the server binds to")
+	fmt.Println("  DRIFT_ADMIN_PORT  Set the inspector port")
+	fmt.Println("  DRIFT_BACKEND  Set the backend to proxy to")
+	fmt.Println("  DRIFT_CONFIG   Set the configuration file")
+	fmt.Println("  DRIFT_PROFILE  Set the configuration profile")
+	fmt.Println("  DRIFT_RELAY_TOKEN  Set the relay token of drift serve and drift relay")
+	fmt.Println("  DRIFT_UPDATE_API  Set the GitHub API drift update reads releases from")
+}
+
+// serveFlags holds the "serve" flags that override the configuration
+type serveFlags struct {
+	port             string
+	adminPort        string
+	drainTimeout     time.Duration
+	protoDescriptors []string
+	relay            string
+	relayToken       string
+	relayName        string
+	releaseOnExit    bool
+}
+
+// stringList is a flag that may be repeated or given as a comma-separated list
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			*l = append(*l, item)
+		}
+	}
+	return nil
 }
 
 // StartServer starts DRIFT server
-func StartServer(portFlag string, staticFiles embed.FS) {
-	// Load configuration
-	cfg := config.Load()
+func StartServer(flags serveFlags, opts config.LoadOptions, staticFiles embed.FS) {
+	// Load configuration, applying the flags on top of the file and environment
+	load := func() (*config.Config, error) {
+		cfg, err := config.Load(opts)
+		if err != nil {
+			return nil, err
+		}
+
+		// Override port with flag if provided
+		if flags.port != "" {
+			cfg.Port = flags.port
+		}
+		if flags.adminPort != "" {
+			cfg.AdminPort = flags.adminPort
+		}
+		if flags.drainTimeout > 0 {
+			cfg.DrainTimeout = config.Duration(flags.drainTimeout)
+		}
+		if len(flags.protoDescriptors) > 0 {
+			cfg.ProtoDescriptors = flags.protoDescriptors
+		}
+		if flags.relay != "" {
+			cfg.Tunnel.Enabled = true
+			cfg.Tunnel.Provider = config.TunnelRelay
+			cfg.Tunnel.Relay.URL = flags.relay
+		}
+		if flags.relayToken != "" {
+			cfg.Tunnel.Relay.Token = flags.relayToken
+		}
+		if flags.relayName != "" {
+			cfg.Tunnel.Relay.Name = flags.relayName
+		}
+		if flags.releaseOnExit {
+			cfg.Tunnel.ReleaseOnExit = true
+		}
+
+		if err := cfg.Validate(); err != nil {
+			return nil, err
+		}
+		return cfg, nil
+	}
 
-	// Override port with flag if provided
-	if portFlag != "" {
-		cfg.Port = portFlag
+	cfg, err := load()
+	if err != nil {
+		printValidationError(err)
+		os.Exit(1)
 	}
 
 	// Create application state
-	state := models.NewAppState()
+	state := models.NewAppState(cfg)
 
 	// Start the server
 	fmt.Println("Starting DRIFT...")
-	err := server.Start(state, staticFiles, cfg.Port)
+	err = server.Start(state, staticFiles, cfg, load)
 	if err != nil {
 		fmt.Printf("Failed to start server: %v\n", err)
 		os.Exit(1)
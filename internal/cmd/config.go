@@ -0,0 +1,60 @@
+package cmd
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"drift/internal/config"
+)
+
+// Config handles the "config" command and its subcommands
+func Config(args []string) {
+	if len(args) == 0 || args[0] != "validate" {
+		fmt.Println("Usage: drift config validate [--config FILE] [--profile NAME]")
+		os.Exit(2)
+	}
+
+	validateCmd := flag.NewFlagSet("config validate", flag.ExitOnError)
+	configFlag := validateCmd.String("config", "", "Path to the configuration file")
+	profileFlag := validateCmd.String("profile", "", "Configuration profile to validate")
+	validateCmd.Parse(args[1:])
+
+	cfg, err := config.Load(config.LoadOptions{Path: *configFlag, Profile: *profileFlag})
+	if err != nil {
+		fmt.Printf("❌ Failed to load configuration: %v\n", err)
+		os.Exit(1)
+	}
+
+	if len(cfg.Sources) == 0 {
+		fmt.Println("ℹ️  No configuration file found, using defaults.")
+	}
+	for _, source := range cfg.Sources {
+		fmt.Printf("📄 Loaded %s\n", source)
+	}
+	if cfg.Profile != "" {
+		fmt.Printf("🏷️  Profile: %s\n", cfg.Profile)
+	}
+
+	if err := cfg.Validate(); err != nil {
+		printValidationError(err)
+		os.Exit(1)
+	}
+
+	fmt.Println("✅ Configuration is valid.")
+}
+
+// printValidationError prints each invalid field on its own line
+func printValidationError(err error) {
+	var validationErr config.ValidationError
+	if !errors.As(err, &validationErr) {
+		fmt.Printf("❌ Failed to load configuration: %v\n", err)
+		return
+	}
+
+	fmt.Printf("❌ Invalid configuration (%d error(s)):\n", len(validationErr))
+	for _, fieldErr := range validationErr {
+		fmt.Printf("   - %s\n", fieldErr)
+	}
+}
@@ -0,0 +1,88 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"drift/internal/relay"
+)
+
+// Relay handles the "relay" command, which runs a relay server that DRIFT
+// instances connect to with drift serve --relay
+func Relay(args []string) {
+	relayCmd := flag.NewFlagSet("relay", flag.ExitOnError)
+	listenFlag := relayCmd.String("listen", ":8080", "Address to accept tunnels and public traffic on")
+	tokenFlag := relayCmd.String("token", "", "Token that clients must present (or DRIFT_RELAY_TOKEN)")
+	domainFlag := relayCmd.String("domain", "", "Serve tunnels at <name>.DOMAIN instead of /t/<name>/ only")
+	certFlag := relayCmd.String("tls-cert", "", "TLS certificate file")
+	keyFlag := relayCmd.String("tls-key", "", "TLS private key file")
+	relayCmd.Parse(args)
+
+	token := *tokenFlag
+	if token == "" {
+		token = os.Getenv("DRIFT_RELAY_TOKEN")
+	}
+	if token == "" {
+		fmt.Println("❌ A token is required: pass --token or set DRIFT_RELAY_TOKEN.")
+		os.Exit(2)
+	}
+	if (*certFlag == "") != (*keyFlag == "") {
+		fmt.Println("❌ --tls-cert and --tls-key must be given together.")
+		os.Exit(2)
+	}
+
+	server := &http.Server{
+		Addr:              *listenFlag,
+		Handler:           relay.NewServer(token, *domainFlag),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	errs := make(chan error, 1)
+	go func() {
+		if *certFlag != "" {
+			errs <- server.ListenAndServeTLS(*certFlag, *keyFlag)
+		} else {
+			errs <- server.ListenAndServe()
+		}
+	}()
+
+	scheme := "ws"
+	if *certFlag != "" {
+		scheme = "wss"
+	}
+	fmt.Printf("✅ DRIFT relay listening on %s\n", *listenFlag)
+	fmt.Printf("ℹ️  Connect with: drift serve --relay %s://<this host>%s\n", scheme, portSuffix(*listenFlag))
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	select {
+	case err := <-errs:
+		if !errors.Is(err, http.ErrServerClosed) {
+			fmt.Printf("❌ Relay stopped: %v\n", err)
+			os.Exit(1)
+		}
+	case <-stop:
+		fmt.Println("\n🔄 Shutting down relay...")
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(ctx)
+	}
+}
+
+// portSuffix returns ":port" of a listen address, empty for the default
+// ports of ws and wss
+func portSuffix(addr string) string {
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil || port == "80" || port == "443" {
+		return ""
+	}
+	return ":" + port
+}
@@ -2,43 +2,248 @@ package cmd
 
 import (
 	"bufio"
+	"encoding/json"
+	"flag"
 	"fmt"
 	"os"
 	"strconv"
 	"strings"
+	"text/tabwriter"
+	"time"
 
 	"drift/internal/tunnel"
 )
 
-// Release handles releasing reserved zrok tokens interactively or all at once.
-func Release(all bool) {
-	tokens, err := tunnel.GetAllReservedZrokTokens()
+// releaseEntry is a reserved zrok share, with the project DRIFT reserved it
+// for when DRIFT created it
+type releaseEntry struct {
+	tunnel.ZrokShare
+	// Active is set when a running DRIFT shares it or zrok saw traffic on it
+	Active   bool       `json:"active"`
+	Drift    bool       `json:"drift"`
+	Project  string     `json:"project,omitempty"`
+	Backend  string     `json:"backend,omitempty"`
+	LastUsed *time.Time `json:"last_used,omitempty"`
+}
+
+// releaseFilter selects the shares a release command applies to
+type releaseFilter struct {
+	unused   bool
+	notDrift bool
+	match    string
+}
+
+// noTokens is the message printed when no share is selected
+func (f releaseFilter) noTokens() string {
+	if f == (releaseFilter{}) {
+		return "ℹ️  No reserved zrok tokens found."
+	}
+	return "ℹ️  No reserved zrok tokens match the filters."
+}
+
+func (f releaseFilter) keep(e releaseEntry) bool {
+	if f.unused && e.Active {
+		return false
+	}
+	if f.notDrift && e.Drift {
+		return false
+	}
+	if f.match == "" {
+		return true
+	}
+	match := strings.ToLower(f.match)
+	for _, field := range []string{e.Token, e.URL, e.Target, e.Project, e.Backend} {
+		if strings.Contains(strings.ToLower(field), match) {
+			return true
+		}
+	}
+	return false
+}
+
+// Release handles the "release" command, which lists and releases reserved
+// zrok tokens: interactively, all at once, or the tokens given
+func Release(args []string) {
+	releaseCmd := flag.NewFlagSet("release", flag.ExitOnError)
+	jsonFlag := releaseCmd.Bool("json", false, "Print the list as JSON")
+	unusedFlag := releaseCmd.Bool("unused", false, "Only tokens that are not active")
+	notDriftFlag := releaseCmd.Bool("not-owned-by-drift", false, "Only tokens DRIFT did not create")
+	matchFlag := releaseCmd.String("match", "", "Only tokens whose token, URL, backend or project contains this text")
+	dryRunFlag := releaseCmd.Bool("dry-run", false, "Show the tokens that would be released without releasing them")
+	args = parseInterleaved(releaseCmd, args)
+	filter := releaseFilter{unused: *unusedFlag, notDrift: *notDriftFlag, match: *matchFlag}
+
+	shares, err := tunnel.ListReservedZrokShares()
 	if err != nil {
 		fmt.Printf("❌ Failed to get reserved zrok tokens: %v\n", err)
+		os.Exit(1)
+	}
+
+	// Tokens DRIFT reserved for a project are kept in its state file
+	store := tunnel.NewStore(tunnel.StatePath())
+	entries, stale := releaseEntries(store, shares, filter)
+
+	var tokens []string
+	switch {
+	case len(args) > 0 && args[0] == "list":
+		printReleaseList(entries, *jsonFlag, filter)
 		return
+	case len(args) > 0 && args[0] == "all":
+		for _, e := range entries {
+			tokens = append(tokens, e.Token)
+		}
+	case len(args) > 0:
+		tokens = selectTokens(entries, args)
+	default:
+		tokens = promptTokens(entries)
 	}
 
 	if len(tokens) == 0 {
-		fmt.Println("ℹ️  No reserved zrok tokens found.")
+		if len(entries) == 0 {
+			fmt.Println(filter.noTokens())
+		}
 		return
 	}
 
-	if all {
-		fmt.Println("🔄 Releasing all reserved tokens...")
+	if *dryRunFlag {
 		for _, token := range tokens {
-			if err := tunnel.ReleaseZrokToken(token); err != nil {
-				fmt.Printf("❌ Failed to release %s: %v\n", token, err)
-			} else {
-				fmt.Printf("✅ Released %s\n", token)
+			fmt.Printf("ℹ️  Would release %s\n", token)
+		}
+		return
+	}
+
+	if len(stale) > 0 {
+		if _, err := store.Remove(stale...); err != nil {
+			fmt.Printf("⚠️  Failed to update %s: %v\n", store.Path(), err)
+		}
+	}
+
+	fmt.Printf("🔄 Releasing %d token(s)...\n", len(tokens))
+	failed := false
+	for _, token := range tokens {
+		failed = !releaseToken(store, token) || failed
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// parseInterleaved parses flags placed before, between or after the
+// positional arguments, which it returns
+func parseInterleaved(fs *flag.FlagSet, args []string) []string {
+	var positional []string
+	for {
+		fs.Parse(args)
+		args = fs.Args()
+		if len(args) == 0 {
+			return positional
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+// releaseEntries joins the shares zrok lists with the reservations of the
+// state file, keeping those selected by filter. The tokens of reservations
+// zrok no longer lists are returned as stale.
+func releaseEntries(store *tunnel.Store, shares []tunnel.ZrokShare, filter releaseFilter) (entries []releaseEntry, stale []string) {
+	reservations, err := store.List()
+	if err != nil {
+		fmt.Printf("⚠️  Failed to read %s: %v\n", store.Path(), err)
+	}
+	owned := make(map[string]tunnel.Reservation, len(reservations))
+	for _, r := range reservations {
+		owned[r.Token] = r
+	}
+
+	for _, share := range shares {
+		e := releaseEntry{ZrokShare: share, Active: share.Traffic}
+		if r, ok := owned[share.Token]; ok {
+			delete(owned, share.Token)
+			e.Drift = true
+			e.Project = r.Project
+			e.Backend = r.Backend
+			e.LastUsed = &r.LastUsed
+			e.Active = e.Active || r.InUse()
+			if e.URL == "" {
+				e.URL = r.URL
 			}
 		}
+		if filter.keep(e) {
+			entries = append(entries, e)
+		}
+	}
+
+	// An empty list may come from output that could not be parsed
+	if len(shares) > 0 {
+		for token := range owned {
+			stale = append(stale, token)
+		}
+	}
+	return entries, stale
+}
+
+// printReleaseList prints the shares as a table or as JSON
+func printReleaseList(entries []releaseEntry, asJSON bool, filter releaseFilter) {
+	if asJSON {
+		if entries == nil {
+			entries = []releaseEntry{}
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(entries)
 		return
 	}
+	if len(entries) == 0 {
+		fmt.Println(filter.noTokens())
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "TOKEN\tURL\tBACKEND\tACTIVE\tDRIFT")
+	for _, e := range entries {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", e.Token, orDash(e.URL), orDash(entryBackend(e)), yesNo(e.Active), yesNo(e.Drift))
+	}
+	w.Flush()
+}
+
+// selectTokens returns the tokens given on the command line that are
+// reserved and selected by the filters
+func selectTokens(entries []releaseEntry, args []string) []string {
+	var tokens []string
+	for _, token := range args {
+		found := false
+		for _, e := range entries {
+			found = found || e.Token == token
+		}
+		if !found {
+			fmt.Printf("⚠️  Skipping %s: not a reserved token matching the filters\n", token)
+			continue
+		}
+		tokens = append(tokens, token)
+	}
+	return tokens
+}
+
+// promptTokens asks which of the shares to release
+func promptTokens(entries []releaseEntry) []string {
+	if len(entries) == 0 {
+		return nil
+	}
 
 	// --- Interactive multi-select mode ---
 	fmt.Println("Select one or more zrok tokens to release (e.g. 1 2 3):")
-	for i, token := range tokens {
-		fmt.Printf("[%d] %s\n", i+1, token)
+	for i, e := range entries {
+		fmt.Printf("[%d] %s", i+1, e.Token)
+		if e.URL != "" {
+			fmt.Printf("  %s", e.URL)
+		}
+		if e.Drift {
+			fmt.Printf("  (DRIFT: %s → %s)", e.Project, e.Backend)
+		}
+		if e.Active {
+			fmt.Print("  [active]")
+		}
+		fmt.Println()
 	}
 
 	reader := bufio.NewReader(os.Stdin)
@@ -48,32 +253,57 @@ func Release(all bool) {
 
 	if input == "0" || input == "" {
 		fmt.Println("🚫 Release canceled.")
-		return
+		return nil
 	}
 
-	selections := strings.Fields(input)
-	var selectedTokens []string
-
-	for _, sel := range selections {
+	var tokens []string
+	for _, sel := range strings.Fields(input) {
 		index, err := strconv.Atoi(sel)
-		if err != nil || index <= 0 || index > len(tokens) {
+		if err != nil || index <= 0 || index > len(entries) {
 			fmt.Printf("⚠️  Skipping invalid selection: %q\n", sel)
 			continue
 		}
-		selectedTokens = append(selectedTokens, tokens[index-1])
+		tokens = append(tokens, entries[index-1].Token)
 	}
 
-	if len(selectedTokens) == 0 {
+	if len(tokens) == 0 {
 		fmt.Println("❌ No valid tokens selected.")
-		return
 	}
+	return tokens
+}
 
-	fmt.Printf("Releasing %d selected token(s)...\n", len(selectedTokens))
-	for _, token := range selectedTokens {
-		if err := tunnel.ReleaseZrokToken(token); err != nil {
-			fmt.Printf("❌ Failed to release %s: %v\n", token, err)
-		} else {
-			fmt.Printf("✅ Released %s\n", token)
-		}
+// releaseToken releases a token and forgets it in the state file
+func releaseToken(store *tunnel.Store, token string) bool {
+	if err := tunnel.ReleaseZrokToken(token); err != nil {
+		fmt.Printf("❌ Failed to release %s: %v\n", token, err)
+		return false
+	}
+	fmt.Printf("✅ Released %s\n", token)
+	if _, err := store.Remove(token); err != nil {
+		fmt.Printf("⚠️  Failed to update %s: %v\n", store.Path(), err)
+	}
+	return true
+}
+
+// entryBackend is the backend DRIFT reserved the share for, or the target
+// zrok forwards it to
+func entryBackend(e releaseEntry) string {
+	if e.Backend != "" {
+		return e.Backend
+	}
+	return e.Target
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+func yesNo(b bool) string {
+	if b {
+		return "yes"
 	}
+	return "no"
 }
@@ -1,47 +1,116 @@
 package cmd
 
 import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
+	"net/http"
+	"net/url"
 	"os"
+	"path"
 	"path/filepath"
 	"runtime"
+	"strings"
 	"time"
 
 	"github.com/blang/semver"
+	"github.com/inconshreveable/go-update"
 	"github.com/rhysd/go-github-selfupdate/selfupdate"
 )
 
 const slug = "10cyrilc/drift"
 
-func Update(currentVersion string) {
-	v := semver.MustParse(currentVersion)
-	fmt.Println("🚀 Checking for updates...")
+// checksumsFile is the checksum file goreleaser publishes with each release
+const checksumsFile = "checksums.txt"
 
-	updater, err := selfupdate.NewUpdater(selfupdate.Config{})
-	if err != nil {
-		fmt.Println("❌ Error creating updater:", err)
+// Exit statuses of drift update --check, which exits with 0 when up to date
+const (
+	checkExitFailed = 1
+	checkExitUpdate = 2
+)
+
+// updateCheck is the report printed by drift update --check
+type updateCheck struct {
+	Current         string `json:"current"`
+	Version         string `json:"version,omitempty"`
+	UpdateAvailable bool   `json:"update_available"`
+	URL             string `json:"url,omitempty"`
+	Error           string `json:"error,omitempty"`
+}
+
+// Update handles the "update" command, which replaces the binary with the
+// latest release, or the release given with --to, once its archive matches
+// the checksums of the release. The replaced binary is kept for --rollback.
+func Update(currentVersion string, args []string) {
+	updateCmd := flag.NewFlagSet("update", flag.ExitOnError)
+	checkFlag := updateCmd.Bool("check", false, "Report whether an update is available as JSON, exiting with status 2 when there is one")
+	toFlag := updateCmd.String("to", "", "Install this version instead of the latest, e.g. v0.1.4")
+	rollbackFlag := updateCmd.Bool("rollback", false, "Restore the binary replaced by the last update")
+	updateCmd.Parse(args)
+
+	if *rollbackFlag {
+		exePath, err := executablePath()
+		if err != nil {
+			fmt.Println("❌ Could not determine executable path:", err)
+			os.Exit(1)
+		}
+		if err := rollback(exePath); err != nil {
+			fmt.Println("❌ Rollback failed:", err)
+			os.Exit(1)
+		}
 		return
 	}
 
-	latest, found, err := updater.DetectLatest(slug)
-	if err != nil {
-		fmt.Println("❌ Error detecting latest version:", err)
+	if *checkFlag {
+		report := checkUpdate(currentVersion, *toFlag)
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(report)
+		switch {
+		case report.Error != "":
+			os.Exit(checkExitFailed)
+		case report.UpdateAvailable:
+			os.Exit(checkExitUpdate)
+		}
 		return
 	}
 
-	if !found || latest.Version.LTE(v) {
+	fmt.Println("🚀 Checking for updates...")
+	v, err := semver.ParseTolerant(currentVersion)
+	if err != nil {
+		fmt.Println("❌ Invalid current version:", err)
+		os.Exit(1)
+	}
+	release, err := detectRelease(*toFlag)
+	if err != nil {
+		fmt.Println("❌ Error detecting version:", err)
+		os.Exit(1)
+	}
+
+	switch {
+	case release.Version.EQ(v):
+		fmt.Printf("🎉 You are already on version %s.\n", v)
+		return
+	case *toFlag == "" && release.Version.LT(v):
 		fmt.Println("🎉 You are already on the latest version.")
 		return
+	case release.Version.LT(v):
+		fmt.Printf("Downgrading: %s → %s\n", v, release.Version)
+	default:
+		fmt.Printf("New version available: %s → %s\n", v, release.Version)
 	}
 
-	fmt.Printf("New version available: %s → %s\n", v, latest.Version)
-
-	exePath, err := os.Executable()
+	exePath, err := executablePath()
 	if err != nil {
 		fmt.Println("❌ Could not determine executable path:", err)
-		return
+		os.Exit(1)
 	}
-	exePath, _ = filepath.EvalSymlinks(exePath)
 
 	// Simple spinner animation using only fmt + time
 	done := make(chan bool)
@@ -52,6 +121,7 @@ func Update(currentVersion string) {
 			select {
 			case <-done:
 				fmt.Printf("\r") // clear spinner line
+				done <- true
 				return
 			default:
 				fmt.Printf("\r⬇️  Downloading update... %c", spinChars[i%len(spinChars)])
@@ -61,15 +131,195 @@ func Update(currentVersion string) {
 		}
 	}()
 
-	err = updater.UpdateTo(latest, exePath)
+	archive, err := downloadRelease(release)
 	done <- true
+	<-done
 	if err != nil {
 		fmt.Println("😟 Error during update:", err)
-		return
+		os.Exit(1)
 	}
+	fmt.Printf("🔒 Checksum verified against %s\n", checksumsFile)
 
-	fmt.Printf("✅ Successfully updated to version %s\n", latest.Version)
+	if err := install(archive, release.AssetURL, exePath); err != nil {
+		fmt.Println("😟 Error during update:", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("✅ Successfully updated to version %s\n", release.Version)
+	fmt.Printf("💾 Previous binary kept at %s, restore it with drift update --rollback\n", backupPath(exePath))
 	if runtime.GOOS == "windows" {
 		fmt.Printf("📁 Updated binary: %s\n", exePath)
 	}
 }
+
+// checkUpdate reports whether the latest release, or the pinned version,
+// differs from the current version
+func checkUpdate(currentVersion, pinned string) updateCheck {
+	report := updateCheck{Current: currentVersion}
+	v, err := semver.ParseTolerant(currentVersion)
+	if err != nil {
+		report.Error = fmt.Sprintf("invalid current version: %v", err)
+		return report
+	}
+	release, err := detectRelease(pinned)
+	if err != nil {
+		report.Error = err.Error()
+		return report
+	}
+	report.Version = release.Version.String()
+	report.URL = release.URL
+	if pinned == "" {
+		report.UpdateAvailable = release.Version.GT(v)
+	} else {
+		report.UpdateAvailable = !release.Version.EQ(v)
+	}
+	return report
+}
+
+// detectRelease finds the latest release, or the release of version when
+// given. DRIFT_UPDATE_API points to another GitHub API, such as a GitHub
+// Enterprise instance or a local mirror.
+func detectRelease(version string) (*selfupdate.Release, error) {
+	updater, err := selfupdate.NewUpdater(selfupdate.Config{EnterpriseBaseURL: os.Getenv("DRIFT_UPDATE_API")})
+	if err != nil {
+		return nil, fmt.Errorf("creating updater: %w", err)
+	}
+
+	if version == "" {
+		release, found, err := updater.DetectLatest(slug)
+		if err != nil {
+			return nil, err
+		}
+		if !found {
+			return nil, fmt.Errorf("no release found for %s/%s", runtime.GOOS, runtime.GOARCH)
+		}
+		return release, nil
+	}
+
+	// Tags are prefixed with v, which may be left out
+	if !strings.HasPrefix(version, "v") {
+		version = "v" + version
+	}
+	release, found, err := updater.DetectVersion(slug, version)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, fmt.Errorf("version %s not found for %s/%s", version, runtime.GOOS, runtime.GOARCH)
+	}
+	return release, nil
+}
+
+// downloadRelease downloads the archive of a release and checks it against
+// the checksum file published next to it
+func downloadRelease(release *selfupdate.Release) ([]byte, error) {
+	assetURL, err := url.Parse(release.AssetURL)
+	if err != nil {
+		return nil, err
+	}
+	name := path.Base(assetURL.Path)
+	sumsURL := *assetURL
+	sumsURL.Path = path.Join(path.Dir(assetURL.Path), checksumsFile)
+
+	sums, err := download(sumsURL.String())
+	if err != nil {
+		return nil, fmt.Errorf("fetching %s: %w", checksumsFile, err)
+	}
+	want, ok := findChecksum(sums, name)
+	if !ok {
+		return nil, fmt.Errorf("%s has no checksum for %s", checksumsFile, name)
+	}
+
+	archive, err := download(release.AssetURL)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(archive)
+	if got := hex.EncodeToString(sum[:]); !strings.EqualFold(got, want) {
+		return nil, fmt.Errorf("checksum mismatch for %s: expected %s, got %s", name, want, got)
+	}
+	return archive, nil
+}
+
+// findChecksum returns the SHA-256 of name in a checksum file, whose lines
+// are "<sha256>  <file>"
+func findChecksum(sums []byte, name string) (string, bool) {
+	scanner := bufio.NewScanner(bytes.NewReader(sums))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && strings.TrimPrefix(fields[1], "*") == name {
+			return fields[0], true
+		}
+	}
+	return "", false
+}
+
+// download fetches a release file
+func download(fileURL string) ([]byte, error) {
+	client := &http.Client{Timeout: 5 * time.Minute}
+	resp, err := client.Get(fileURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("downloading %s: %s", fileURL, resp.Status)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// install extracts the binary from a release archive and swaps it in,
+// keeping the current binary as the backup
+func install(archive []byte, assetURL, exePath string) error {
+	binary, err := selfupdate.UncompressCommand(bytes.NewReader(archive), assetURL, filepath.Base(exePath))
+	if err != nil {
+		return err
+	}
+	return apply(binary, exePath)
+}
+
+// rollback restores the binary at exePath replaced by the last update. The
+// binary it replaces becomes the backup in turn, so a rollback can be undone.
+func rollback(exePath string) error {
+	backup := backupPath(exePath)
+	previous, err := os.ReadFile(backup)
+	if errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("no previous binary at %s", backup)
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := apply(bytes.NewReader(previous), exePath); err != nil {
+		return err
+	}
+	fmt.Printf("✅ Restored the previous binary to %s\n", exePath)
+	fmt.Println("ℹ️  Run drift update --rollback again to undo.")
+	return nil
+}
+
+// apply replaces the binary at exePath, moving it to its backup path
+func apply(binary io.Reader, exePath string) error {
+	err := update.Apply(binary, update.Options{TargetPath: exePath, OldSavePath: backupPath(exePath)})
+	if err != nil {
+		if rerr := update.RollbackError(err); rerr != nil {
+			return fmt.Errorf("%v; restoring %s also failed: %v", err, exePath, rerr)
+		}
+		return err
+	}
+	return nil
+}
+
+// executablePath returns the path of the running binary, symlinks resolved
+func executablePath() (string, error) {
+	exePath, err := os.Executable()
+	if err != nil {
+		return "", err
+	}
+	return filepath.EvalSymlinks(exePath)
+}
+
+// backupPath is where the binary replaced by an update is kept
+func backupPath(exePath string) string {
+	return exePath + ".bak"
+}
@@ -0,0 +1,193 @@
+package cmd
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/rhysd/go-github-selfupdate/selfupdate"
+)
+
+func TestFindChecksum(t *testing.T) {
+	sums := []byte("aaa111  drift_0.2.0_linux_amd64.tar.gz\n" +
+		"bbb222 *drift_0.2.0_windows_amd64.zip\n" +
+		"malformed line\n")
+
+	tests := []struct {
+		name  string
+		want  string
+		found bool
+	}{
+		{"drift_0.2.0_linux_amd64.tar.gz", "aaa111", true},
+		{"drift_0.2.0_windows_amd64.zip", "bbb222", true},
+		{"drift_0.2.0_darwin_arm64.tar.gz", "", false},
+		{"linux_amd64.tar.gz", "", false},
+	}
+	for _, tt := range tests {
+		got, found := findChecksum(sums, tt.name)
+		if got != tt.want || found != tt.found {
+			t.Errorf("findChecksum(%q) = %q, %v, want %q, %v", tt.name, got, found, tt.want, tt.found)
+		}
+	}
+}
+
+// releaseServer serves a GitHub API listing releases of drift and their
+// files. Versions whose checksum is in corrupt get a wrong checksum.
+func releaseServer(t *testing.T, versions []string, corrupt ...string) *httptest.Server {
+	t.Helper()
+	files := map[string][]byte{}
+	var releases []map[string]any
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/v3/repos/"+slug+"/releases" {
+			json.NewEncoder(w).Encode(releases)
+			return
+		}
+		if data, ok := files[r.URL.Path]; ok {
+			w.Write(data)
+			return
+		}
+		http.NotFound(w, r)
+	}))
+	t.Cleanup(srv.Close)
+
+	for i, version := range versions {
+		name := fmt.Sprintf("drift_%s_%s_%s.tar.gz", version, runtime.GOOS, runtime.GOARCH)
+		archive := []byte("archive of " + version)
+		sum := sha256.Sum256(archive)
+		if slices.Contains(corrupt, version) {
+			sum[0] ^= 1
+		}
+		files["/download/v"+version+"/"+name] = archive
+		files["/download/v"+version+"/"+checksumsFile] = fmt.Appendf(nil, "%s  %s\n", hex.EncodeToString(sum[:]), name)
+		releases = append(releases, map[string]any{
+			"id":       i + 1,
+			"tag_name": "v" + version,
+			"html_url": srv.URL + "/releases/v" + version,
+			"assets": []map[string]any{{
+				"id":                   i + 1,
+				"name":                 name,
+				"browser_download_url": srv.URL + "/download/v" + version + "/" + name,
+			}},
+		})
+	}
+	t.Setenv("DRIFT_UPDATE_API", srv.URL+"/")
+	return srv
+}
+
+func TestDownloadRelease(t *testing.T) {
+	srv := releaseServer(t, []string{"0.2.0", "0.1.4"}, "0.1.4")
+	assetURL := func(version string) string {
+		return fmt.Sprintf("%s/download/v%s/drift_%s_%s_%s.tar.gz", srv.URL, version, version, runtime.GOOS, runtime.GOARCH)
+	}
+
+	archive, err := downloadRelease(&selfupdate.Release{AssetURL: assetURL("0.2.0")})
+	if err != nil {
+		t.Fatalf("downloadRelease: %v", err)
+	}
+	if string(archive) != "archive of 0.2.0" {
+		t.Errorf("archive = %q", archive)
+	}
+
+	_, err = downloadRelease(&selfupdate.Release{AssetURL: assetURL("0.1.4")})
+	if err == nil || !strings.Contains(err.Error(), "checksum mismatch") {
+		t.Errorf("corrupt archive: err = %v, want a checksum mismatch", err)
+	}
+
+	// A release without checksums.txt is not installed
+	_, err = downloadRelease(&selfupdate.Release{AssetURL: srv.URL + "/elsewhere/drift_0.2.0.tar.gz"})
+	if err == nil || !strings.Contains(err.Error(), "fetching "+checksumsFile) {
+		t.Errorf("missing %s: err = %v", checksumsFile, err)
+	}
+}
+
+func TestCheckUpdate(t *testing.T) {
+	releaseServer(t, []string{"0.2.0", "0.1.4"})
+
+	tests := []struct {
+		current   string
+		pinned    string
+		version   string
+		available bool
+	}{
+		{"0.1.4", "", "0.2.0", true},
+		{"v0.2.0", "", "0.2.0", false},
+		{"0.3.0", "", "0.2.0", false},
+		// A pinned version differing from the current one is an update,
+		// downgrades included
+		{"0.2.0", "v0.1.4", "0.1.4", true},
+		{"0.2.0", "0.1.4", "0.1.4", true},
+		{"0.1.4", "0.1.4", "0.1.4", false},
+	}
+	for _, tt := range tests {
+		report := checkUpdate(tt.current, tt.pinned)
+		if report.Error != "" {
+			t.Errorf("checkUpdate(%q, %q): %s", tt.current, tt.pinned, report.Error)
+			continue
+		}
+		if report.Version != tt.version || report.UpdateAvailable != tt.available {
+			t.Errorf("checkUpdate(%q, %q) = %s, available %v, want %s, available %v",
+				tt.current, tt.pinned, report.Version, report.UpdateAvailable, tt.version, tt.available)
+		}
+		if report.Current != tt.current || !strings.HasSuffix(report.URL, "/releases/v"+tt.version) {
+			t.Errorf("checkUpdate(%q, %q) reports current %q and URL %q", tt.current, tt.pinned, report.Current, report.URL)
+		}
+	}
+
+	for _, tt := range []struct{ current, pinned, want string }{
+		{"dev", "", "invalid current version"},
+		{"0.2.0", "0.9.9", "version v0.9.9 not found"},
+	} {
+		report := checkUpdate(tt.current, tt.pinned)
+		if !strings.Contains(report.Error, tt.want) || report.UpdateAvailable {
+			t.Errorf("checkUpdate(%q, %q) = %+v, want error %q", tt.current, tt.pinned, report, tt.want)
+		}
+	}
+}
+
+func TestApplyAndRollback(t *testing.T) {
+	exePath := filepath.Join(t.TempDir(), "drift")
+	if err := os.WriteFile(exePath, []byte("v1"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	expect := func(step, current, backup string) {
+		t.Helper()
+		for path, want := range map[string]string{exePath: current, backupPath(exePath): backup} {
+			got, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatalf("%s: %v", step, err)
+			}
+			if string(got) != want {
+				t.Errorf("%s: %s holds %q, want %q", step, filepath.Base(path), got, want)
+			}
+		}
+	}
+
+	if err := rollback(exePath); err == nil {
+		t.Error("rollback succeeded without a previous binary")
+	}
+
+	if err := apply(strings.NewReader("v2"), exePath); err != nil {
+		t.Fatal(err)
+	}
+	expect("update", "v2", "v1")
+
+	if err := rollback(exePath); err != nil {
+		t.Fatal(err)
+	}
+	expect("rollback", "v1", "v2")
+
+	// A second rollback undoes the first
+	if err := rollback(exePath); err != nil {
+		t.Fatal(err)
+	}
+	expect("second rollback", "v2", "v1")
+}
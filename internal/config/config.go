@@ -1,27 +1,443 @@
 package config
 
 import (
+	"fmt"
+	"net"
+	"net/netip"
+	"net/url"
 	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
+	"time"
 )
 
 // Config holds the application configuration
 type Config struct {
-	Port string
+	Host      string          `yaml:"host" json:"host"`
+	Port      string          `yaml:"port" json:"port"`
+	AdminHost string          `yaml:"admin_host" json:"admin_host"`
+	AdminPort string          `yaml:"admin_port" json:"admin_port"`
+	Auth      AuthConfig      `yaml:"auth" json:"auth"`
+	Backend   string          `yaml:"backend" json:"backend"`
+	Routes    []Route         `yaml:"routes" json:"routes"`
+	Tunnel    TunnelConfig    `yaml:"tunnel" json:"tunnel"`
+	Capture   CaptureConfig   `yaml:"capture" json:"capture"`
+	Redaction RedactionConfig `yaml:"redaction" json:"redaction"`
+	Mocks     []MockRule      `yaml:"mocks" json:"mocks"`
+	Faults    []FaultRule     `yaml:"faults" json:"faults"`
+	Access    AccessConfig    `yaml:"access" json:"access"`
+	Retention RetentionConfig `yaml:"retention" json:"retention"`
+	// ProtoDescriptors are FileDescriptorSet files used to decode protobuf
+	// and gRPC messages
+	ProtoDescriptors []string `yaml:"proto_descriptors" json:"proto_descriptors"`
+	// JWKS is a JSON Web Key Set file used to verify the signature of
+	// captured JWTs
+	JWKS string `yaml:"jwks" json:"jwks,omitempty"`
+	// TrustedProxies are the addresses and CIDR ranges of the proxies, such
+	// as the zrok agent, whose forwarding headers carry the client address
+	TrustedProxies []string `yaml:"trusted_proxies" json:"trusted_proxies"`
+	// GeoIP are MaxMind databases used to locate clients
+	GeoIP []string `yaml:"geoip" json:"geoip"`
+	// DrainTimeout bounds how long shutdown waits for in-flight requests
+	DrainTimeout Duration `yaml:"drain_timeout" json:"drain_timeout"`
+
+	// Profile is the name of the profile applied on top of the file, if any
+	Profile string `yaml:"-" json:"profile,omitempty"`
+	// Sources lists the configuration files that were loaded, in order
+	Sources []string `yaml:"-" json:"sources,omitempty"`
+	// Watched lists the files whose creation, change or removal reloads the
+	// configuration: the explicit file, or every file name searched in each
+	// directory, so that a file created after startup is picked up
+	Watched []string `yaml:"-" json:"-"`
+}
+
+// Route sends requests whose path starts with Path to a different backend
+type Route struct {
+	Path        string `yaml:"path" json:"path"`
+	Backend     string `yaml:"backend" json:"backend"`
+	StripPrefix bool   `yaml:"strip_prefix" json:"strip_prefix"`
+}
+
+// Tunnel providers
+const (
+	TunnelZrok        = "zrok"
+	TunnelCloudflared = "cloudflared"
+	TunnelNgrok       = "ngrok"
+	TunnelSSH         = "ssh"
+	TunnelRelay       = "relay"
+	TunnelNone        = "none"
+)
+
+// TunnelConfig holds the public tunnel settings
+type TunnelConfig struct {
+	Enabled bool `yaml:"enabled" json:"enabled"`
+	// Provider is zrok, cloudflared, ngrok, ssh, relay or none
+	Provider string `yaml:"provider" json:"provider"`
+	// Token is a reserved zrok share token
+	Token string `yaml:"token" json:"token"`
+	// ReleaseOnExit releases the zrok shares DRIFT reserved when it stops,
+	// instead of keeping them for the next run. Tokens supplied by the user
+	// are never released.
+	ReleaseOnExit bool `yaml:"release_on_exit" json:"release_on_exit"`
+	// ProbeInterval is how often the public URL is checked end to end, 0
+	// to only restart the tunnel when it exits
+	ProbeInterval Duration    `yaml:"probe_interval" json:"probe_interval"`
+	Zrok          ZrokConfig  `yaml:"zrok" json:"zrok"`
+	Ngrok         NgrokConfig `yaml:"ngrok" json:"ngrok"`
+	SSH           SSHConfig   `yaml:"ssh" json:"ssh"`
+	Relay         RelayConfig `yaml:"relay" json:"relay"`
+}
+
+// Zrok share modes
+const (
+	ZrokPublic  = "public"
+	ZrokPrivate = "private"
+)
+
+// ZrokConfig holds the options of zrok shares. Basic auth, OAuth and unique
+// names of reserved shares are set when DRIFT reserves them.
+type ZrokConfig struct {
+	// Mode is public, or private for shares reached with zrok access
+	Mode string `yaml:"mode" json:"mode"`
+	// FrontendDomain is the domain of public shares on a self-hosted zrok
+	// instance, such as share.example.com. It is derived from the zrok
+	// API endpoint when empty.
+	FrontendDomain string `yaml:"frontend_domain" json:"frontend_domain,omitempty"`
+	// UniqueName names the reserved share, and so its URL
+	UniqueName string `yaml:"unique_name" json:"unique_name,omitempty"`
+	// BasicAuth lists user:password pairs asked for by the zrok frontend
+	BasicAuth []string  `yaml:"basic_auth" json:"-"`
+	OAuth     ZrokOAuth `yaml:"oauth" json:"oauth"`
+	// Headless runs zrok share without its terminal UI
+	Headless bool `yaml:"headless" json:"headless"`
+}
+
+// ZrokOAuth puts an OAuth login in front of a public share
+type ZrokOAuth struct {
+	// Provider is github or google, empty for no login
+	Provider     string   `yaml:"provider" json:"provider,omitempty"`
+	EmailDomains []string `yaml:"email_domains" json:"email_domains,omitempty"`
+}
+
+// NgrokConfig holds the ngrok tunnel settings. The authtoken is read from
+// the ngrok configuration.
+type NgrokConfig struct {
+	// Domain is a static domain of the ngrok account
+	Domain string `yaml:"domain" json:"domain,omitempty"`
+}
+
+// SSHConfig describes a remote port forward (ssh -R) to a host of your own,
+// whose web server exposes the remote port at PublicURL
+type SSHConfig struct {
+	// Host is the destination, as given to ssh: host, user@host or an alias
+	// of the ssh configuration
+	Host         string `yaml:"host" json:"host,omitempty"`
+	RemotePort   int    `yaml:"remote_port" json:"remote_port,omitempty"`
+	PublicURL    string `yaml:"public_url" json:"public_url,omitempty"`
+	IdentityFile string `yaml:"identity_file" json:"identity_file,omitempty"`
+}
+
+// RelayConfig points to a relay of your own, started with drift relay
+type RelayConfig struct {
+	// URL is the address of the relay, such as wss://relay.example.com
+	URL   string `yaml:"url" json:"url,omitempty"`
+	Token string `yaml:"token" json:"-"`
+	// Name asks for a subdomain or path; the relay picks one when empty
+	Name string `yaml:"name" json:"name,omitempty"`
 }
 
-// Load loads the configuration from environment variables
-func Load() *Config {
-	config := &Config{
-		Port: "4040",
+// TunnelProvider returns the provider of the public tunnel, none when it is
+// disabled
+func (c *Config) TunnelProvider() string {
+	if !c.Tunnel.Enabled {
+		return TunnelNone
+	}
+	return c.Tunnel.Provider
+}
+
+// AuthConfig controls the login required by the inspector, /ws and the
+// admin API
+type AuthConfig struct {
+	Enabled bool `yaml:"enabled" json:"enabled"`
+	// Token is the admin access token; a random one is generated at startup
+	// when it is empty
+	Token      string   `yaml:"token" json:"-"`
+	Users      []User   `yaml:"users" json:"users,omitempty"`
+	SessionTTL Duration `yaml:"session_ttl" json:"session_ttl"`
+}
+
+// User roles
+const (
+	RoleViewer = "viewer"
+	RoleAdmin  = "admin"
+)
+
+// User is a static login. Viewers can watch traffic; admins can also
+// reconfigure DRIFT and replay requests.
+type User struct {
+	Name         string `yaml:"name" json:"name"`
+	PasswordHash string `yaml:"password_hash" json:"-"`
+	Role         string `yaml:"role" json:"role"`
+}
+
+// CaptureConfig holds the limits applied when capturing traffic
+type CaptureConfig struct {
+	// MaxRequestBody and MaxResponseBody bound the part of a body kept in
+	// memory; 0 keeps whole bodies
+	MaxRequestBody  ByteSize `yaml:"max_request_body" json:"max_request_body"`
+	MaxResponseBody ByteSize `yaml:"max_response_body" json:"max_response_body"`
+	// SpillBodies writes bodies over the limit to temporary files so they can
+	// be downloaded, keeping at most MaxSpill bytes on disk
+	SpillBodies bool     `yaml:"spill_bodies" json:"spill_bodies"`
+	MaxSpill    ByteSize `yaml:"max_spill" json:"max_spill"`
+	QueueSize   int      `yaml:"queue_size" json:"queue_size"`
+	// Overflow is what happens when the queue is full: drop_oldest, drop_newest or spill
+	Overflow        string `yaml:"overflow" json:"overflow"`
+	ClientQueueSize int    `yaml:"client_queue_size" json:"client_queue_size"`
+}
+
+// RedactionConfig holds the rules used to hide secrets in captures
+type RedactionConfig struct {
+	Headers []string        `yaml:"headers" json:"headers"`
+	Query   []string        `yaml:"query" json:"query"`
+	Body    []BodyRedaction `yaml:"body" json:"body"`
+	Hash    bool            `yaml:"hash" json:"hash"`
+}
+
+// BodyRedaction redacts a JSON path or a regular expression match in bodies
+type BodyRedaction struct {
+	Path    string `yaml:"path" json:"path,omitempty"`
+	Pattern string `yaml:"pattern" json:"pattern,omitempty"`
+}
+
+// MockRule answers matching requests with a canned response
+type MockRule struct {
+	Method  string            `yaml:"method" json:"method,omitempty"`
+	Path    string            `yaml:"path" json:"path"`
+	Status  int               `yaml:"status" json:"status"`
+	Headers map[string]string `yaml:"headers" json:"headers,omitempty"`
+	Body    string            `yaml:"body" json:"body,omitempty"`
+}
+
+// FaultRule injects latency or errors into matching requests
+type FaultRule struct {
+	Method      string   `yaml:"method" json:"method,omitempty"`
+	Path        string   `yaml:"path" json:"path"`
+	Delay       Duration `yaml:"delay" json:"delay,omitempty"`
+	Status      int      `yaml:"status" json:"status,omitempty"`
+	Probability float64  `yaml:"probability" json:"probability,omitempty"`
+}
+
+// AccessConfig restricts who can use the public port, which the tunnel
+// points to. Every check that is configured must pass.
+type AccessConfig struct {
+	// Allow lists the client addresses and CIDR ranges accepted; empty
+	// accepts every client
+	Allow     []string        `yaml:"allow" json:"allow,omitempty"`
+	BasicAuth []BasicAuthUser `yaml:"basic_auth" json:"basic_auth,omitempty"`
+	Secret    SecretHeader    `yaml:"secret" json:"secret"`
+	RateLimit RateLimit       `yaml:"rate_limit" json:"rate_limit"`
+}
+
+// Enabled reports whether any check is configured
+func (a AccessConfig) Enabled() bool {
+	return len(a.Allow) > 0 || len(a.BasicAuth) > 0 || a.Secret.Header != "" || a.RateLimit.Requests > 0
+}
+
+// BasicAuthUser is a login accepted by HTTP basic auth
+type BasicAuthUser struct {
+	Username string `yaml:"username" json:"username"`
+	Password string `yaml:"password" json:"-"`
+}
+
+// SecretHeader is a header that must carry a shared secret
+type SecretHeader struct {
+	Header string `yaml:"header" json:"header,omitempty"`
+	Value  string `yaml:"value" json:"-"`
+}
+
+// RateLimit bounds the requests of each client address. Requests are
+// allowed per period, in bursts of up to Burst requests.
+type RateLimit struct {
+	Requests int      `yaml:"requests" json:"requests,omitempty"`
+	Per      Duration `yaml:"per" json:"per,omitempty"`
+	Burst    int      `yaml:"burst" json:"burst,omitempty"`
+}
+
+// RetentionConfig controls how long captured traffic is kept
+type RetentionConfig struct {
+	MaxEntries int      `yaml:"max_entries" json:"max_entries"`
+	MaxAge     Duration `yaml:"max_age" json:"max_age"`
+}
+
+// Default returns the built-in configuration
+func Default() *Config {
+	return &Config{
+		Port:      "4040",
+		AdminPort: "4041",
+		Auth: AuthConfig{
+			SessionTTL: Duration(12 * time.Hour),
+		},
+		Access: AccessConfig{
+			RateLimit: RateLimit{Per: Duration(time.Minute)},
+		},
+		Tunnel: TunnelConfig{
+			Enabled:       true,
+			Provider:      TunnelZrok,
+			ProbeInterval: Duration(30 * time.Second),
+			Zrok:          ZrokConfig{Mode: ZrokPublic},
+		},
+		Capture: CaptureConfig{
+			MaxRequestBody:  1 << 20,
+			MaxResponseBody: 1 << 20,
+			SpillBodies:     true,
+			MaxSpill:        1 << 30,
+			QueueSize:       100,
+			Overflow:        "drop_oldest",
+			ClientQueueSize: 256,
+		},
+		Redaction: RedactionConfig{
+			Headers: []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie"},
+		},
+		Retention: RetentionConfig{
+			MaxEntries: 1000,
+		},
+		TrustedProxies: []string{"127.0.0.0/8", "::1"},
+		DrainTimeout:   Duration(10 * time.Second),
+	}
+}
+
+// LoadOptions selects the configuration file and profile to load
+type LoadOptions struct {
+	// Path is an explicit configuration file; when empty the default locations are searched
+	Path string
+	// Profile is the named profile to apply on top of the file
+	Profile string
+}
+
+// Load builds the configuration from the defaults, the configuration files
+// and the environment, in increasing order of precedence. Command line flags
+// are applied by the caller on top of the returned configuration.
+func Load(opts LoadOptions) (*Config, error) {
+	config := Default()
+
+	if opts.Path == "" {
+		opts.Path = os.Getenv("DRIFT_CONFIG")
+	}
+	if opts.Profile == "" {
+		opts.Profile = os.Getenv("DRIFT_PROFILE")
+	}
+
+	if err := loadFiles(config, opts); err != nil {
+		return nil, err
 	}
 
 	// Check environment variables
+	if host := os.Getenv("DRIFT_HOST"); host != "" {
+		config.Host = host
+	}
 	if port := os.Getenv("DRIFT_PORT"); port != "" {
 		if _, err := strconv.Atoi(port); err == nil {
 			config.Port = port
 		}
 	}
+	if port := os.Getenv("DRIFT_ADMIN_PORT"); port != "" {
+		if _, err := strconv.Atoi(port); err == nil {
+			config.AdminPort = port
+		}
+	}
+	if backend := os.Getenv("DRIFT_BACKEND"); backend != "" {
+		config.Backend = backend
+	}
+	if token := os.Getenv("DRIFT_RELAY_TOKEN"); token != "" {
+		config.Tunnel.Relay.Token = token
+	}
+
+	return config, nil
+}
+
+// Addr returns the address the server listens on
+func (c *Config) Addr() string {
+	return net.JoinHostPort(c.Host, c.Port)
+}
+
+// AdminAddrs returns the addresses the inspector listens on, the IPv4 and
+// IPv6 loopback addresses unless admin_host is set
+func (c *Config) AdminAddrs() []string {
+	if c.AdminHost != "" {
+		return []string{net.JoinHostPort(c.AdminHost, c.AdminPort)}
+	}
+	return []string{net.JoinHostPort("127.0.0.1", c.AdminPort), net.JoinHostPort("::1", c.AdminPort)}
+}
+
+// AdminIsLoopback reports whether the inspector is only reachable from this
+// machine
+func (c *Config) AdminIsLoopback() bool {
+	if c.AdminHost == "" || strings.EqualFold(c.AdminHost, "localhost") {
+		return true
+	}
+	addr, err := netip.ParseAddr(c.AdminHost)
+	return err == nil && addr.Unmap().IsLoopback()
+}
+
+// ProjectDir returns the directory DRIFT runs for: that of the project
+// configuration file, or the working directory when only the user
+// configuration, or none, was loaded
+func (c *Config) ProjectDir() string {
+	user := userDir()
+	for i := len(c.Sources) - 1; i >= 0; i-- {
+		dir, err := filepath.Abs(filepath.Dir(c.Sources[i]))
+		if err == nil && dir != user {
+			return dir
+		}
+	}
+	wd, _ := os.Getwd()
+	return wd
+}
+
+// ParseBackend turns a backend given as a port, a host:port pair or a URL into a URL
+func ParseBackend(backend string) (*url.URL, error) {
+	backend = strings.TrimSpace(backend)
+	if backend == "" {
+		return nil, fmt.Errorf("backend is empty")
+	}
 
-	return config
+	if _, err := strconv.Atoi(backend); err == nil {
+		backend = "localhost:" + backend
+	}
+	if !strings.Contains(backend, "://") {
+		backend = "http://" + backend
+	}
+
+	u, err := url.Parse(backend)
+	if err != nil {
+		return nil, fmt.Errorf("invalid backend %q: %w", backend, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("invalid backend %q: scheme must be http or https", backend)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("invalid backend %q: missing host", backend)
+	}
+
+	return u, nil
+}
+
+// ParseAddressRange parses an address or a CIDR range, as listed in
+// trusted_proxies and access.allow
+func ParseAddressRange(entry string) (netip.Prefix, error) {
+	if strings.Contains(entry, "/") {
+		prefix, err := netip.ParsePrefix(entry)
+		if err != nil {
+			return netip.Prefix{}, fmt.Errorf("invalid address or CIDR range %q: %w", entry, err)
+		}
+		return prefix.Masked(), nil
+	}
+	addr, err := netip.ParseAddr(entry)
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("invalid address or CIDR range %q: %w", entry, err)
+	}
+	addr = addr.Unmap()
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
 }
@@ -0,0 +1,75 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+)
+
+// restartOnly lists the settings that are bound when the server starts and
+// cannot be swapped while it is running
+var restartOnly = map[string]bool{
+	"host":       true,
+	"port":       true,
+	"admin_host": true,
+	"admin_port": true,
+	"auth":       true,
+	"tunnel":     true,
+}
+
+// Diff returns the top-level settings that differ between a and b
+func Diff(a, b *Config) []string {
+	var changed []string
+
+	va := reflect.ValueOf(a).Elem()
+	vb := reflect.ValueOf(b).Elem()
+	t := va.Type()
+	for i := 0; i < t.NumField(); i++ {
+		name := strings.Split(t.Field(i).Tag.Get("yaml"), ",")[0]
+		if name == "" || name == "-" {
+			continue
+		}
+		if name == "capture" {
+			continue
+		}
+		if !reflect.DeepEqual(va.Field(i).Interface(), vb.Field(i).Interface()) {
+			changed = append(changed, name)
+		}
+	}
+
+	// The queue sizes are fixed when the pipeline starts, the other capture
+	// settings can be swapped
+	captureA, captureB := a.Capture, b.Capture
+	if captureA.QueueSize != captureB.QueueSize {
+		changed = append(changed, "capture.queue_size")
+	}
+	if captureA.ClientQueueSize != captureB.ClientQueueSize {
+		changed = append(changed, "capture.client_queue_size")
+	}
+	captureA.QueueSize, captureB.QueueSize = 0, 0
+	captureA.ClientQueueSize, captureB.ClientQueueSize = 0, 0
+	if captureA != captureB {
+		changed = append(changed, "capture")
+	}
+
+	return changed
+}
+
+// RequiresRestart reports whether a setting returned by Diff can only take
+// effect after DRIFT is restarted
+func RequiresRestart(setting string) bool {
+	return restartOnly[setting] || setting == "capture.queue_size" || setting == "capture.client_queue_size"
+}
+
+// Merge returns a copy of next that keeps the restart-only settings of current
+func Merge(current, next *Config) *Config {
+	merged := *next
+	merged.Host = current.Host
+	merged.Port = current.Port
+	merged.AdminHost = current.AdminHost
+	merged.AdminPort = current.AdminPort
+	merged.Auth = current.Auth
+	merged.Tunnel = current.Tunnel
+	merged.Capture.QueueSize = current.Capture.QueueSize
+	merged.Capture.ClientQueueSize = current.Capture.ClientQueueSize
+	return &merged
+}
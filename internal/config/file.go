@@ -0,0 +1,165 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// fileNames are the configuration file names searched in each directory
+var fileNames = []string{"drift.yaml", "drift.yml", "drift.json"}
+
+// fileSchema is the on-disk layout: the base settings plus named profiles
+type fileSchema struct {
+	Config   `yaml:",inline"`
+	Profiles map[string]Config `yaml:"profiles"`
+}
+
+// SearchPaths returns the directories searched for a configuration file,
+// from lowest to highest precedence
+func SearchPaths() []string {
+	var dirs []string
+
+	if dir := userDir(); dir != "" {
+		dirs = append(dirs, dir)
+	}
+
+	if wd, err := os.Getwd(); err == nil {
+		dirs = append(dirs, wd)
+	}
+
+	return dirs
+}
+
+// userDir returns the directory of the user configuration file, empty when
+// there is no home directory
+func userDir() string {
+	if dir := os.Getenv("XDG_CONFIG_HOME"); dir != "" {
+		return filepath.Join(dir, "drift")
+	}
+	if home, err := os.UserHomeDir(); err == nil {
+		return filepath.Join(home, ".config", "drift")
+	}
+	return ""
+}
+
+// findFile returns the first configuration file present in dir
+func findFile(dir string) string {
+	for _, name := range fileNames {
+		path := filepath.Join(dir, name)
+		if info, err := os.Stat(path); err == nil && !info.IsDir() {
+			return path
+		}
+	}
+	return ""
+}
+
+// loadFiles applies the configuration files and the selected profile to config
+func loadFiles(config *Config, opts LoadOptions) error {
+	var paths []string
+	if opts.Path != "" {
+		paths = []string{opts.Path}
+		config.Watched = paths
+	} else {
+		for _, dir := range SearchPaths() {
+			if path := findFile(dir); path != "" {
+				paths = append(paths, path)
+			}
+			for _, name := range fileNames {
+				config.Watched = append(config.Watched, filepath.Join(dir, name))
+			}
+		}
+	}
+
+	profiles := make(map[string]yaml.Node)
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("failed to read config file: %w", err)
+		}
+
+		fileProfiles, err := decodeFile(data, config)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		for name, node := range fileProfiles {
+			profiles[name] = node
+		}
+		config.Sources = append(config.Sources, path)
+	}
+
+	if opts.Profile == "" {
+		return nil
+	}
+
+	node, ok := profiles[opts.Profile]
+	if !ok {
+		names := make([]string, 0, len(profiles))
+		for name := range profiles {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		if len(names) == 0 {
+			return fmt.Errorf("profile %q not found: no profiles are defined", opts.Profile)
+		}
+		return fmt.Errorf("profile %q not found (available: %s)", opts.Profile, strings.Join(names, ", "))
+	}
+
+	if err := node.Decode(config); err != nil {
+		return fmt.Errorf("profile %q: %w", opts.Profile, cleanYAMLError(err))
+	}
+	config.Profile = opts.Profile
+
+	return nil
+}
+
+// decodeFile decodes a YAML or JSON document into config and returns its profiles
+func decodeFile(data []byte, config *Config) (map[string]yaml.Node, error) {
+	// Decode strictly into a scratch value first so that unknown keys and
+	// type mismatches are reported with their line numbers
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&fileSchema{}); err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, nil
+		}
+		return nil, cleanYAMLError(err)
+	}
+
+	if err := yaml.Unmarshal(data, config); err != nil {
+		return nil, cleanYAMLError(err)
+	}
+
+	var file struct {
+		Profiles map[string]yaml.Node `yaml:"profiles"`
+	}
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, cleanYAMLError(err)
+	}
+
+	return file.Profiles, nil
+}
+
+// unknownFieldRegex matches the decoder's message for unknown keys
+var unknownFieldRegex = regexp.MustCompile(`field (\S+) not found in type \S+`)
+
+// cleanYAMLError strips the decoder prefix and Go type names from YAML errors
+func cleanYAMLError(err error) error {
+	var typeErr *yaml.TypeError
+	if errors.As(err, &typeErr) {
+		messages := make([]string, len(typeErr.Errors))
+		for i, message := range typeErr.Errors {
+			messages[i] = unknownFieldRegex.ReplaceAllString(message, `unknown field "$1"`)
+		}
+		return errors.New(strings.Join(messages, "; "))
+	}
+	return errors.New(strings.TrimPrefix(err.Error(), "yaml: "))
+}
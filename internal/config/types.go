@@ -0,0 +1,80 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Duration is a time.Duration written as a string such as "1m30s"
+type Duration time.Duration
+
+// MarshalText implements encoding.TextMarshaler
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler
+func (d *Duration) UnmarshalText(text []byte) error {
+	s := strings.TrimSpace(string(text))
+	if s == "" {
+		*d = 0
+		return nil
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return fmt.Errorf("invalid duration %q", s)
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// ByteSize is a size in bytes written as a number or with a unit such as "10MB"
+type ByteSize int64
+
+var byteUnits = []struct {
+	suffix string
+	size   int64
+}{
+	{"KIB", 1 << 10},
+	{"MIB", 1 << 20},
+	{"GIB", 1 << 30},
+	{"KB", 1000},
+	{"MB", 1000 * 1000},
+	{"GB", 1000 * 1000 * 1000},
+	{"K", 1 << 10},
+	{"M", 1 << 20},
+	{"G", 1 << 30},
+	{"B", 1},
+}
+
+// MarshalText implements encoding.TextMarshaler
+func (b ByteSize) MarshalText() ([]byte, error) {
+	return []byte(strconv.FormatInt(int64(b), 10)), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler
+func (b *ByteSize) UnmarshalText(text []byte) error {
+	s := strings.ToUpper(strings.TrimSpace(string(text)))
+	if s == "" {
+		*b = 0
+		return nil
+	}
+
+	multiplier := int64(1)
+	for _, unit := range byteUnits {
+		if strings.HasSuffix(s, unit.suffix) {
+			multiplier = unit.size
+			s = strings.TrimSpace(strings.TrimSuffix(s, unit.suffix))
+			break
+		}
+	}
+
+	value, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return fmt.Errorf("invalid size %q", string(text))
+	}
+	*b = ByteSize(value * float64(multiplier))
+	return nil
+}
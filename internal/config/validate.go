@@ -0,0 +1,323 @@
+package config
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"drift/internal/jsonpath"
+)
+
+// FieldError describes a single invalid configuration value
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+func (e FieldError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Field, e.Message)
+}
+
+// ValidationError lists every problem found in a configuration
+type ValidationError []FieldError
+
+func (e ValidationError) Error() string {
+	messages := make([]string, len(e))
+	for i, fieldErr := range e {
+		messages[i] = fieldErr.Error()
+	}
+	return strings.Join(messages, "\n")
+}
+
+// Validate checks the configuration and returns a ValidationError listing
+// every invalid field, or nil when the configuration is usable
+func (c *Config) Validate() error {
+	var errs ValidationError
+	add := func(field, format string, args ...interface{}) {
+		errs = append(errs, FieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if port, err := strconv.Atoi(c.Port); err != nil || port < 1 || port > 65535 {
+		add("port", "must be a number between 1 and 65535, got %q", c.Port)
+	}
+	if port, err := strconv.Atoi(c.AdminPort); err != nil || port < 1 || port > 65535 {
+		add("admin_port", "must be a number between 1 and 65535, got %q", c.AdminPort)
+	} else if c.AdminPort == c.Port {
+		add("admin_port", "must differ from port, which the public tunnel points to")
+	}
+
+	switch c.Tunnel.Provider {
+	case TunnelZrok, TunnelCloudflared, TunnelNgrok, TunnelNone:
+	case TunnelRelay:
+		relay := c.Tunnel.Relay
+		if u, err := url.Parse(relay.URL); err != nil || !validRelayScheme(u.Scheme) || u.Host == "" {
+			add("tunnel.relay.url", "must be a ws, wss, http or https URL, got %q", relay.URL)
+		}
+		if relay.Token == "" {
+			add("tunnel.relay.token", "is required with the relay provider (or set DRIFT_RELAY_TOKEN)")
+		}
+		if relay.Name != "" && !relayName.MatchString(relay.Name) {
+			add("tunnel.relay.name", "must be lowercase letters, digits and dashes, up to 32 characters, got %q", relay.Name)
+		}
+	case TunnelSSH:
+		ssh := c.Tunnel.SSH
+		if ssh.Host == "" {
+			add("tunnel.ssh.host", "is required with the ssh provider")
+		}
+		if ssh.RemotePort < 1 || ssh.RemotePort > 65535 {
+			add("tunnel.ssh.remote_port", "must be a number between 1 and 65535, got %d", ssh.RemotePort)
+		}
+		if u, err := url.Parse(ssh.PublicURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			add("tunnel.ssh.public_url", "must be an http or https URL, got %q", ssh.PublicURL)
+		}
+	default:
+		add("tunnel.provider", "must be one of zrok, cloudflared, ngrok, ssh, relay or none, got %q", c.Tunnel.Provider)
+	}
+	zrok := c.Tunnel.Zrok
+	switch zrok.Mode {
+	case ZrokPublic:
+	case ZrokPrivate:
+		if zrok.OAuth.Provider != "" {
+			add("tunnel.zrok.oauth.provider", "is only supported by public shares")
+		}
+	default:
+		add("tunnel.zrok.mode", "must be %s or %s, got %q", ZrokPublic, ZrokPrivate, zrok.Mode)
+	}
+	if zrok.UniqueName != "" && !zrokUniqueName.MatchString(zrok.UniqueName) {
+		add("tunnel.zrok.unique_name", "must be 4 to 32 lowercase letters and digits, got %q", zrok.UniqueName)
+	}
+	for i, pair := range zrok.BasicAuth {
+		if user, _, ok := strings.Cut(pair, ":"); !ok || user == "" {
+			add(fmt.Sprintf("tunnel.zrok.basic_auth[%d]", i), "must be user:password")
+		}
+	}
+	switch zrok.OAuth.Provider {
+	case "", "github", "google":
+	default:
+		add("tunnel.zrok.oauth.provider", "must be github or google, got %q", zrok.OAuth.Provider)
+	}
+	if zrok.OAuth.Provider != "" && len(zrok.BasicAuth) > 0 {
+		add("tunnel.zrok.oauth.provider", "cannot be combined with basic_auth")
+	}
+	if zrok.OAuth.Provider == "" && len(zrok.OAuth.EmailDomains) > 0 {
+		add("tunnel.zrok.oauth.email_domains", "requires an oauth provider")
+	}
+	if strings.Contains(zrok.FrontendDomain, "/") {
+		add("tunnel.zrok.frontend_domain", "must be a domain such as share.example.com, got %q", zrok.FrontendDomain)
+	}
+	if c.Tunnel.ProbeInterval < 0 {
+		add("tunnel.probe_interval", "must not be negative, got %s", time.Duration(c.Tunnel.ProbeInterval))
+	}
+	if c.Tunnel.Token != "" && c.Tunnel.Provider != TunnelZrok {
+		add("tunnel.token", "reserved tokens are only supported by the zrok provider")
+	}
+
+	if !c.AdminIsLoopback() && !c.Auth.Enabled {
+		add("admin_host", "exposing the inspector beyond loopback requires auth.enabled")
+	}
+	if c.Auth.SessionTTL <= 0 {
+		add("auth.session_ttl", "must be positive, got %s", time.Duration(c.Auth.SessionTTL))
+	}
+	users := make(map[string]bool)
+	for i, user := range c.Auth.Users {
+		field := fmt.Sprintf("auth.users[%d]", i)
+		switch {
+		case user.Name == "":
+			add(field+".name", "is required")
+		case users[user.Name]:
+			add(field+".name", "duplicate user %q", user.Name)
+		}
+		users[user.Name] = true
+		if !strings.HasPrefix(user.PasswordHash, "$2") {
+			add(field+".password_hash", "must be a bcrypt hash")
+		}
+		if user.Role != RoleViewer && user.Role != RoleAdmin {
+			add(field+".role", "must be viewer or admin, got %q", user.Role)
+		}
+	}
+
+	if c.Backend != "" {
+		if _, err := ParseBackend(c.Backend); err != nil {
+			add("backend", "%v", err)
+		}
+	}
+
+	for i, route := range c.Routes {
+		field := fmt.Sprintf("routes[%d]", i)
+		if !strings.HasPrefix(route.Path, "/") {
+			add(field+".path", "must start with \"/\", got %q", route.Path)
+		}
+		if _, err := ParseBackend(route.Backend); err != nil {
+			add(field+".backend", "%v", err)
+		}
+	}
+
+	if c.Capture.MaxRequestBody < 0 {
+		add("capture.max_request_body", "must not be negative")
+	}
+	if c.Capture.MaxResponseBody < 0 {
+		add("capture.max_response_body", "must not be negative")
+	}
+	if c.Capture.MaxSpill < 0 {
+		add("capture.max_spill", "must not be negative")
+	}
+	if c.Capture.QueueSize < 1 {
+		add("capture.queue_size", "must be at least 1, got %d", c.Capture.QueueSize)
+	}
+	switch c.Capture.Overflow {
+	case "drop_oldest", "drop_newest", "spill":
+	default:
+		add("capture.overflow", "must be one of drop_oldest, drop_newest or spill, got %q", c.Capture.Overflow)
+	}
+	if c.Capture.ClientQueueSize < 1 {
+		add("capture.client_queue_size", "must be at least 1, got %d", c.Capture.ClientQueueSize)
+	}
+
+	for i, rule := range c.Redaction.Body {
+		field := fmt.Sprintf("redaction.body[%d]", i)
+		switch {
+		case rule.Path == "" && rule.Pattern == "":
+			add(field, "either path or pattern is required")
+		case rule.Path != "" && rule.Pattern != "":
+			add(field, "path and pattern are mutually exclusive")
+		case rule.Path != "":
+			if _, err := jsonpath.Parse(rule.Path); err != nil {
+				add(field+".path", "invalid JSON path %q: %v", rule.Path, err)
+			}
+		case rule.Pattern != "":
+			if _, err := regexp.Compile(rule.Pattern); err != nil {
+				add(field+".pattern", "invalid regular expression: %v", err)
+			}
+		}
+	}
+
+	for i, rule := range c.Mocks {
+		field := fmt.Sprintf("mocks[%d]", i)
+		validateMatch(field, rule.Method, rule.Path, add)
+		if rule.Status != 0 && http.StatusText(rule.Status) == "" {
+			add(field+".status", "unknown HTTP status %d", rule.Status)
+		}
+	}
+
+	for i, rule := range c.Faults {
+		field := fmt.Sprintf("faults[%d]", i)
+		validateMatch(field, rule.Method, rule.Path, add)
+		if rule.Status != 0 && http.StatusText(rule.Status) == "" {
+			add(field+".status", "unknown HTTP status %d", rule.Status)
+		}
+		if rule.Delay < 0 {
+			add(field+".delay", "must not be negative")
+		}
+		if rule.Delay == 0 && rule.Status == 0 {
+			add(field, "either delay or status is required")
+		}
+		if rule.Probability < 0 || rule.Probability > 1 {
+			add(field+".probability", "must be between 0 and 1, got %g", rule.Probability)
+		}
+	}
+
+	if c.Retention.MaxEntries < 0 {
+		add("retention.max_entries", "must not be negative")
+	}
+	if c.Retention.MaxAge < 0 {
+		add("retention.max_age", "must not be negative")
+	}
+
+	for i, path := range c.ProtoDescriptors {
+		field := fmt.Sprintf("proto_descriptors[%d]", i)
+		if info, err := os.Stat(path); err != nil {
+			add(field, "%v", err)
+		} else if info.IsDir() {
+			add(field, "%q is a directory, expected a FileDescriptorSet file", path)
+		}
+	}
+
+	if c.JWKS != "" {
+		if info, err := os.Stat(c.JWKS); err != nil {
+			add("jwks", "%v", err)
+		} else if info.IsDir() {
+			add("jwks", "%q is a directory, expected a JWKS file", c.JWKS)
+		}
+	}
+
+	for i, entry := range c.TrustedProxies {
+		if _, err := ParseAddressRange(entry); err != nil {
+			add(fmt.Sprintf("trusted_proxies[%d]", i), "must be an IP address or a CIDR range, got %q", entry)
+		}
+	}
+	for i, path := range c.GeoIP {
+		field := fmt.Sprintf("geoip[%d]", i)
+		if info, err := os.Stat(path); err != nil {
+			add(field, "%v", err)
+		} else if info.IsDir() {
+			add(field, "%q is a directory, expected a MaxMind database", path)
+		}
+	}
+
+	for i, entry := range c.Access.Allow {
+		if _, err := ParseAddressRange(entry); err != nil {
+			add(fmt.Sprintf("access.allow[%d]", i), "must be an IP address or a CIDR range, got %q", entry)
+		}
+	}
+	for i, user := range c.Access.BasicAuth {
+		field := fmt.Sprintf("access.basic_auth[%d]", i)
+		if user.Username == "" || strings.Contains(user.Username, ":") {
+			add(field+".username", "is required and must not contain \":\"")
+		}
+		if user.Password == "" {
+			add(field+".password", "is required")
+		}
+	}
+	if secret := c.Access.Secret; (secret.Header == "") != (secret.Value == "") {
+		add("access.secret", "header and value must be set together")
+	}
+	if limit := c.Access.RateLimit; limit.Requests < 0 || limit.Burst < 0 {
+		add("access.rate_limit", "requests and burst must not be negative")
+	} else if limit.Requests > 0 && limit.Per <= 0 {
+		add("access.rate_limit.per", "must be positive, got %s", time.Duration(limit.Per))
+	}
+
+	if c.DrainTimeout < 0 {
+		add("drain_timeout", "must not be negative")
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// zrokUniqueName is the form of unique names accepted by zrok
+var zrokUniqueName = regexp.MustCompile(`^[a-z0-9]{4,32}$`)
+
+// relayName is the form of tunnel names accepted by drift relay, which
+// serves them as subdomains
+var relayName = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]{0,30}[a-z0-9])?$`)
+
+func validRelayScheme(scheme string) bool {
+	switch scheme {
+	case "ws", "wss", "http", "https":
+		return true
+	}
+	return false
+}
+
+// validateMatch checks the method and path pattern shared by mock and fault rules
+func validateMatch(field, method, pattern string, add func(string, string, ...interface{})) {
+	if method != "" && strings.ToUpper(method) != method {
+		add(field+".method", "must be upper case, got %q", method)
+	}
+	if !strings.HasPrefix(pattern, "/") {
+		add(field+".path", "must start with \"/\", got %q", pattern)
+		return
+	}
+	if _, err := path.Match(pattern, "/"); err != nil {
+		add(field+".path", "invalid pattern %q", pattern)
+	}
+}
@@ -0,0 +1,47 @@
+package config
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestValidateRedactionPaths(t *testing.T) {
+	tests := []struct {
+		path  string
+		valid bool
+	}{
+		{"$.password", true},
+		{"$..token", true},
+		{"$['api key'][0]", true},
+		{"$.items[*].secret", true},
+		{"$", false},
+		{"$.", false},
+		{"$[", false},
+		{"$a", false},
+		{"$[x]", false},
+		{"password", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			cfg := Default()
+			cfg.Redaction.Body = []BodyRedaction{{Path: tt.path}}
+			err := cfg.Validate()
+
+			var verr ValidationError
+			found := errors.As(err, &verr) && hasField(verr, "redaction.body[0].path")
+			if found == tt.valid {
+				t.Errorf("Validate(%q) = %v, want valid %v", tt.path, err, tt.valid)
+			}
+		})
+	}
+}
+
+func hasField(errs ValidationError, field string) bool {
+	for _, e := range errs {
+		if e.Field == field {
+			return true
+		}
+	}
+	return false
+}
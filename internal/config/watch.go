@@ -0,0 +1,56 @@
+package config
+
+import (
+	"os"
+	"time"
+)
+
+// Watch polls the given files and calls onChange whenever one of them is
+// modified, created or removed. The files are polled by path, so they need
+// not exist yet, and a file replaced by an editor's rename on save is still
+// seen. It stops when done is closed.
+func Watch(paths []string, interval time.Duration, done <-chan struct{}, onChange func()) {
+	stamp := func() map[string]os.FileInfo {
+		stamps := make(map[string]os.FileInfo, len(paths))
+		for _, path := range paths {
+			if info, err := os.Stat(path); err == nil {
+				stamps[path] = info
+			}
+		}
+		return stamps
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		last := stamp()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				current := stamp()
+				if !sameStamps(last, current) {
+					last = current
+					onChange()
+				}
+			}
+		}
+	}()
+}
+
+// sameStamps reports whether no file changed between two polls. A file
+// replaced by another one counts as changed even with the same time and size.
+func sameStamps(a, b map[string]os.FileInfo) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for path, before := range a {
+		after, ok := b[path]
+		if !ok || !os.SameFile(before, after) || !before.ModTime().Equal(after.ModTime()) || before.Size() != after.Size() {
+			return false
+		}
+	}
+	return true
+}
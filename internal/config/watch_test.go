@@ -0,0 +1,56 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWatchCreatedAndReplacedFiles(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "drift.yaml")
+	changes := make(chan struct{}, 10)
+	done := make(chan struct{})
+	defer close(done)
+	Watch([]string{path}, 10*time.Millisecond, done, func() { changes <- struct{}{} })
+
+	expectChange := func(what string) {
+		t.Helper()
+		select {
+		case <-changes:
+		case <-time.After(2 * time.Second):
+			t.Fatalf("no change reported after %s", what)
+		}
+	}
+
+	// A file missing at startup is picked up once created
+	time.Sleep(30 * time.Millisecond)
+	if err := os.WriteFile(path, []byte("port: \"1\"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	expectChange("creating the file")
+
+	// Editors save by writing a new file and renaming it over the old one
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmp := filepath.Join(dir, ".drift.yaml.swp")
+	if err := os.WriteFile(tmp, []byte("port: \"2\"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(tmp, info.ModTime(), info.ModTime()); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		t.Fatal(err)
+	}
+	expectChange("replacing the file")
+
+	// The replaced file is still watched
+	if err := os.WriteFile(path, []byte("port: \"33\"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	expectChange("writing the replaced file")
+}
@@ -0,0 +1,131 @@
+package handlers
+
+import (
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+
+	"drift/internal/auth"
+	"drift/internal/config"
+)
+
+// localAdmin is the session of every request when auth is disabled, the
+// inspector then only being reachable from this machine
+var localAdmin = &auth.Session{User: "local", Role: config.RoleAdmin}
+
+// AdminOnly guards the admin listener. Without authn, requests must come from
+// a loopback address and name a loopback host, which defeats DNS rebinding.
+// With authn, they must carry a session, and only admins may change state.
+// Either way, requests that change state must not come from another site.
+func AdminOnly(authn *auth.Authenticator, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if authn == nil {
+			if !isLoopbackAddr(r.RemoteAddr) {
+				writeJSON(w, http.StatusForbidden, apiError{Error: "the inspector only accepts local connections"})
+				return
+			}
+			if !isLoopbackHost(r.Host) {
+				writeJSON(w, http.StatusForbidden, apiError{Error: "the inspector must be reached through localhost"})
+				return
+			}
+		}
+		if !safeMethod(r.Method) && !sameOrigin(r) {
+			writeJSON(w, http.StatusForbidden, apiError{Error: "cross-origin request rejected"})
+			return
+		}
+		if authn == nil {
+			next.ServeHTTP(w, r.WithContext(auth.WithSession(r.Context(), localAdmin)))
+			return
+		}
+
+		// The link printed at startup carries the access token; trade it for
+		// a session cookie and drop it from the address bar
+		if token := r.URL.Query().Get("token"); token != "" && safeMethod(r.Method) {
+			if session, err := authn.LoginToken(token); err == nil {
+				authn.SetCookie(w, session)
+				query := r.URL.Query()
+				query.Del("token")
+				target := *r.URL
+				target.RawQuery = query.Encode()
+				http.Redirect(w, r, target.RequestURI(), http.StatusSeeOther)
+				return
+			}
+		}
+
+		session := authn.Authenticate(r)
+		switch {
+		case publicPath(r.URL.Path):
+		case session == nil && strings.HasPrefix(r.URL.Path, "/inspector"):
+			http.Redirect(w, r, "/inspector/login?next="+url.QueryEscape(r.URL.RequestURI()), http.StatusSeeOther)
+			return
+		case session == nil:
+			writeJSON(w, http.StatusUnauthorized, apiError{Error: "login required"})
+			return
+		case !session.Admin() && !safeMethod(r.Method):
+			writeJSON(w, http.StatusForbidden, apiError{Error: "this action requires the admin role"})
+			return
+		}
+		if session != nil {
+			r = r.WithContext(auth.WithSession(r.Context(), session))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// publicPath reports whether a path is reachable without a session: the
+// login page, its assets and the session API
+func publicPath(path string) bool {
+	switch path {
+	case "/inspector/login", "/inspector/login/", "/api/login", "/api/logout", "/api/session":
+		return true
+	}
+	return strings.HasPrefix(path, "/static/")
+}
+
+func isLoopbackAddr(remoteAddr string) bool {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	addr, err := netip.ParseAddr(host)
+	return err == nil && addr.Unmap().IsLoopback()
+}
+
+// isLoopbackHost reports whether a Host header names this machine
+func isLoopbackHost(host string) bool {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.Trim(host, "[]")
+	if strings.EqualFold(host, "localhost") || strings.HasSuffix(strings.ToLower(host), ".localhost") {
+		return true
+	}
+	addr, err := netip.ParseAddr(host)
+	return err == nil && addr.Unmap().IsLoopback()
+}
+
+func safeMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
+// sameOrigin reports whether a browser request comes from the inspector
+// itself. Requests without Sec-Fetch-Site or Origin, from curl and other
+// non-browser clients, are allowed.
+func sameOrigin(r *http.Request) bool {
+	switch r.Header.Get("Sec-Fetch-Site") {
+	case "same-origin", "none":
+		return true
+	case "":
+	default:
+		return false
+	}
+
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(u.Host, r.Host)
+}
@@ -0,0 +1,167 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"drift/internal/config"
+	"drift/internal/models"
+	"drift/internal/tunnel"
+)
+
+var (
+	errNotConfigured  = errors.New("proxy is not configured")
+	errTunnelDisabled = errors.New("public tunnel is disabled")
+)
+
+// apiError is the body of every failed admin API response
+type apiError struct {
+	Error  string              `json:"error"`
+	Fields []config.FieldError `json:"fields,omitempty"`
+}
+
+// apiConfig is the proxy configuration exposed by the admin API
+type apiConfig struct {
+	Configured   bool          `json:"configured"`
+	Backend      string        `json:"backend,omitempty"`
+	TunnelMode   string        `json:"tunnel_mode,omitempty"`
+	ZrokToken    string        `json:"zrok_token,omitempty"`
+	ZrokPort     string        `json:"zrok_port,omitempty"`
+	ZrokOwner    string        `json:"zrok_owner,omitempty"`
+	PublicURL    string        `json:"public_url"`
+	Tunnel       tunnel.Status `json:"tunnel"`
+	ServerStatus string        `json:"server_status"`
+}
+
+// HandleConfigAPI serves GET, PUT and DELETE on /api/config
+func HandleConfigAPI(state *models.AppState, proxyPort string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			writeJSON(w, http.StatusOK, currentConfig(state))
+
+		case http.MethodPut:
+			var req ConfigureRequest
+			decoder := json.NewDecoder(r.Body)
+			decoder.DisallowUnknownFields()
+			if err := decoder.Decode(&req); err != nil {
+				writeJSON(w, http.StatusBadRequest, apiError{Error: fmt.Sprintf("invalid JSON body: %v", err)})
+				return
+			}
+
+			if fields := validateConfigureRequest(&req, proxyPort, state.Settings.Load().TunnelProvider()); len(fields) > 0 {
+				writeJSON(w, http.StatusUnprocessableEntity, apiError{Error: "validation failed", Fields: fields})
+				return
+			}
+
+			if err := Configure(state, proxyPort, req); err != nil {
+				writeJSON(w, http.StatusUnprocessableEntity, apiError{
+					Error:  "validation failed",
+					Fields: []config.FieldError{{Field: "backend", Message: err.Error()}},
+				})
+				return
+			}
+			writeJSON(w, http.StatusOK, currentConfig(state))
+
+		case http.MethodDelete:
+			if !Unconfigure(state) {
+				writeJSON(w, http.StatusNotFound, apiError{Error: errNotConfigured.Error()})
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+
+		default:
+			w.Header().Set("Allow", "GET, PUT, DELETE")
+			writeJSON(w, http.StatusMethodNotAllowed, apiError{Error: "method not allowed"})
+		}
+	}
+}
+
+// HandleTunnelRestart serves POST /api/tunnel/restart
+func HandleTunnelRestart(state *models.AppState, proxyPort string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", "POST")
+			writeJSON(w, http.StatusMethodNotAllowed, apiError{Error: "method not allowed"})
+			return
+		}
+
+		if err := RestartTunnel(state, proxyPort); err != nil {
+			writeJSON(w, http.StatusConflict, apiError{Error: err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusAccepted, currentConfig(state))
+	}
+}
+
+// validateConfigureRequest normalizes req and returns the invalid fields
+func validateConfigureRequest(req *ConfigureRequest, proxyPort, provider string) []config.FieldError {
+	var fields []config.FieldError
+
+	if req.Backend == "" {
+		fields = append(fields, config.FieldError{Field: "backend", Message: "is required"})
+	} else if _, err := config.ParseBackend(req.Backend); err != nil {
+		fields = append(fields, config.FieldError{Field: "backend", Message: err.Error()})
+	}
+
+	if req.TunnelMode == "" {
+		req.TunnelMode = TunnelAuto
+	}
+	switch req.TunnelMode {
+	case TunnelAuto, TunnelNone:
+	case TunnelCustom:
+		if provider != config.TunnelZrok {
+			fields = append(fields, config.FieldError{Field: "tunnel_mode", Message: fmt.Sprintf("reserved tokens are only supported by the zrok provider, not %s", provider)})
+		}
+		if req.ZrokToken == "" {
+			fields = append(fields, config.FieldError{Field: "zrok_token", Message: "is required when tunnel_mode is \"custom\""})
+		}
+		if req.ZrokPort == "" {
+			req.ZrokPort = proxyPort
+		} else if _, err := strconv.Atoi(req.ZrokPort); err != nil {
+			fields = append(fields, config.FieldError{Field: "zrok_port", Message: fmt.Sprintf("must be a number, got %q", req.ZrokPort)})
+		}
+	default:
+		fields = append(fields, config.FieldError{
+			Field:   "tunnel_mode",
+			Message: fmt.Sprintf("must be one of %q, %q or %q, got %q", TunnelAuto, TunnelCustom, TunnelNone, req.TunnelMode),
+		})
+	}
+
+	return fields
+}
+
+// currentConfig snapshots the proxy configuration
+func currentConfig(state *models.AppState) apiConfig {
+	var response apiConfig
+
+	state.ConfigMu.Lock()
+	if state.Config != nil {
+		response.Configured = true
+		response.Backend = state.Config.BackendURL.String()
+		response.TunnelMode = state.Config.TunnelMode
+		response.ZrokToken = state.Config.ZrokToken
+		response.ZrokPort = state.Config.ZrokPort
+		response.ZrokOwner = state.Config.ZrokOwner
+	}
+	state.ConfigMu.Unlock()
+
+	response.Tunnel = state.Tunnel.Status()
+	response.PublicURL = response.Tunnel.URL
+
+	state.StatusMu.Lock()
+	response.ServerStatus = state.ServerStatus
+	state.StatusMu.Unlock()
+
+	return response
+}
+
+// writeJSON writes v as a JSON response with the given status code
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
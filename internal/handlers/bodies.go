@@ -0,0 +1,80 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"drift/internal/capture"
+	"drift/internal/logging"
+	"drift/internal/models"
+)
+
+// HandleBodyDownload serves GET /api/bodies/{id}/{request|response}, the
+// full body of a capture that was too large to keep in memory, and
+// GET /api/bodies/{id}/{request|response}/files/{n}, a file uploaded in a
+// multipart body. Bodies are returned exactly as they were sent, content
+// encoding included.
+func HandleBodyDownload(state *models.AppState) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			writeJSON(w, http.StatusMethodNotAllowed, apiError{Error: "method not allowed"})
+			return
+		}
+
+		// /api/bodies/{id}/{direction} or /api/bodies/{id}/{direction}/files/{n}
+		parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/bodies/"), "/")
+		valid := (len(parts) == 2 || (len(parts) == 4 && parts[2] == "files")) &&
+			parts[0] != "" && (parts[1] == "request" || parts[1] == "response")
+		index := 0
+		if valid && len(parts) == 4 {
+			var err error
+			index, err = strconv.Atoi(parts[3])
+			valid = err == nil && index >= 0
+		}
+		if !valid {
+			writeJSON(w, http.StatusNotFound, apiError{Error: "expected /api/bodies/{id}/{request|response} or /api/bodies/{id}/{request|response}/files/{n}"})
+			return
+		}
+		id, direction := parts[0], parts[1]
+
+		key := logging.BodyKey(id, direction)
+		filename := id + "-" + direction + ".bin"
+		if len(parts) == 4 {
+			key = logging.FileKey(key, index)
+			filename = fmt.Sprintf("%s-%s-%d.bin", id, direction, index)
+		}
+
+		file, body, err := state.Bodies.Open(key)
+		if errors.Is(err, capture.ErrBodyNotFound) {
+			writeJSON(w, http.StatusNotFound, apiError{Error: "body not found; it was not stored on disk or has been evicted"})
+			return
+		}
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, apiError{Error: err.Error()})
+			return
+		}
+		defer file.Close()
+		if body.Filename != "" {
+			filename = path.Base(body.Filename)
+		}
+
+		contentType := body.ContentType
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+		w.Header().Set("Content-Type", contentType)
+		if body.ContentEncoding != "" {
+			w.Header().Set("Content-Encoding", body.ContentEncoding)
+		}
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filename}))
+		http.ServeContent(w, r, "", time.Time{}, file)
+	}
+}
@@ -0,0 +1,84 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"drift/internal/auth"
+)
+
+// loginRequest logs in with either the access token or a user's password
+type loginRequest struct {
+	Token    string `json:"token,omitempty"`
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+}
+
+// sessionInfo describes who is logged in
+type sessionInfo struct {
+	AuthEnabled bool   `json:"auth_enabled"`
+	User        string `json:"user,omitempty"`
+	Role        string `json:"role,omitempty"`
+}
+
+// HandleLogin opens a session and sets its cookie
+func HandleLogin(authn *auth.Authenticator) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeJSON(w, http.StatusMethodNotAllowed, apiError{Error: "method not allowed"})
+			return
+		}
+		if authn == nil {
+			writeJSON(w, http.StatusNotFound, apiError{Error: "authentication is disabled"})
+			return
+		}
+
+		var req loginRequest
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<16)).Decode(&req); err != nil {
+			writeJSON(w, http.StatusBadRequest, apiError{Error: "invalid JSON: " + err.Error()})
+			return
+		}
+		var session *auth.Session
+		var err error
+		if req.Username != "" {
+			session, err = authn.LoginPassword(req.Username, req.Password)
+		} else {
+			session, err = authn.LoginToken(req.Token)
+		}
+		if err != nil {
+			writeJSON(w, http.StatusUnauthorized, apiError{Error: err.Error()})
+			return
+		}
+
+		authn.SetCookie(w, session)
+		writeJSON(w, http.StatusOK, sessionInfo{AuthEnabled: true, User: session.User, Role: session.Role})
+	}
+}
+
+// HandleLogout closes the session of the request
+func HandleLogout(authn *auth.Authenticator) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeJSON(w, http.StatusMethodNotAllowed, apiError{Error: "method not allowed"})
+			return
+		}
+		if authn != nil {
+			if session := auth.FromContext(r.Context()); session != nil {
+				authn.Logout(session.ID)
+			}
+			auth.ClearCookie(w)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// HandleSession reports whether auth is enabled and who is logged in
+func HandleSession(authn *auth.Authenticator) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		info := sessionInfo{AuthEnabled: authn != nil}
+		if session := auth.FromContext(r.Context()); session != nil {
+			info.User, info.Role = session.User, session.Role
+		}
+		writeJSON(w, http.StatusOK, info)
+	}
+}
@@ -1,16 +1,37 @@
 package handlers
 
 import (
+	"context"
 	"embed"
 	"fmt"
 	"net/http"
+	"os"
+	"slices"
 	"strings"
+	"time"
 
+	"drift/internal/auth"
+	"drift/internal/config"
 	"drift/internal/models"
 	"drift/internal/proxy"
 	"drift/internal/tunnel"
 )
 
+// Tunnel modes accepted by Configure
+const (
+	TunnelAuto   = "auto"
+	TunnelCustom = "custom"
+	TunnelNone   = "none"
+)
+
+// ConfigureRequest describes the backend and tunnel to set up
+type ConfigureRequest struct {
+	Backend    string `json:"backend"`
+	TunnelMode string `json:"tunnel_mode"`
+	ZrokToken  string `json:"zrok_token,omitempty"`
+	ZrokPort   string `json:"zrok_port,omitempty"`
+}
+
 // ConfigureProxy handles the proxy configuration request
 func ConfigureProxy(state *models.AppState, proxyPort string) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -25,102 +46,333 @@ func ConfigureProxy(state *models.AppState, proxyPort string) http.HandlerFunc {
 			return
 		}
 
-		config, err := proxy.Setup(port, state)
+		tunnelMode := r.FormValue("zrok_option")
+		if tunnelMode == "" {
+			tunnelMode = TunnelAuto
+		}
+
+		err := Configure(state, proxyPort, ConfigureRequest{
+			Backend:    port,
+			TunnelMode: tunnelMode,
+			ZrokToken:  r.FormValue("zrok_token"),
+			ZrokPort:   r.FormValue("zrok_port"),
+		})
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
 
-		// Handle zrok options
-		zrokOption := r.FormValue("zrok_option")
+		http.Redirect(w, r, "/inspector/dashboard", http.StatusSeeOther)
+	}
+}
 
-		state.ConfigMu.Lock()
-		existingToken := ""
-		existingURL := ""
-		if state.Config != nil && state.Config.ZrokToken != "" && state.Config.ZrokPort == proxyPort {
-			existingToken = state.Config.ZrokToken
-			existingURL = state.Config.ZrokURL
-		}
-		state.ConfigMu.Unlock()
+// Configure sets up the reverse proxy for a backend and starts the tunnel.
+// A previous configuration is stopped first: its backend monitor and tunnel
+// are cancelled through their context.
+func Configure(state *models.AppState, proxyPort string, req ConfigureRequest) error {
+	state.ReconfigureMu.Lock()
+	defer state.ReconfigureMu.Unlock()
 
-		if zrokOption == "custom" {
-			// Use user-provided token
-			token := r.FormValue("zrok_token")
-			tokenPort := r.FormValue("zrok_port")
-			if token != "" && tokenPort != "" {
-				config.ZrokToken = token
-				config.ZrokPort = tokenPort
-
-				// Warn if the port doesn't match
-				if tokenPort != proxyPort {
-					fmt.Printf("Warning: Using token for port %s with current proxy port %s\n", tokenPort, proxyPort)
-				}
+	proxyConfig, err := proxy.Setup(req.Backend, state.Settings.Load(), state)
+	if err != nil {
+		return err
+	}
+	proxyConfig.TunnelMode = req.TunnelMode
+
+	state.ConfigMu.Lock()
+	existingToken := ""
+	existingURL := ""
+	existingOwner := ""
+	if state.Config != nil && state.Config.ZrokToken != "" && state.Config.ZrokPort == proxyPort {
+		existingToken = state.Config.ZrokToken
+		existingURL = state.Config.ZrokURL
+		existingOwner = state.Config.ZrokOwner
+	}
+	state.ConfigMu.Unlock()
+
+	provider := state.Settings.Load().TunnelProvider()
+	if provider == config.TunnelNone {
+		req.TunnelMode = TunnelNone
+		proxyConfig.TunnelMode = TunnelNone
+	}
+
+	switch {
+	case req.TunnelMode == TunnelNone:
+		// Public tunnel disabled
+	case provider != config.TunnelZrok:
+		// Only zrok shares are reserved
+	case req.TunnelMode == TunnelCustom:
+		// Use user-provided token
+		if req.ZrokToken != "" && req.ZrokPort != "" {
+			proxyConfig.ZrokToken = req.ZrokToken
+			proxyConfig.ZrokPort = req.ZrokPort
+			proxyConfig.ZrokOwner = models.TokenUser
+
+			// Warn if the port doesn't match
+			if req.ZrokPort != proxyPort {
+				fmt.Printf("Warning: Using token for port %s with current proxy port %s\n", req.ZrokPort, proxyPort)
 			}
+		}
+	default:
+		// Auto mode: use existing token or create new one
+		if existingToken != "" {
+			// Reuse existing token for this port
+			fmt.Printf("Reusing existing zrok token: %s for port %s\n", existingToken, proxyPort)
+			proxyConfig.ZrokToken = existingToken
+			proxyConfig.ZrokURL = existingURL
+			proxyConfig.ZrokPort = proxyPort
+			proxyConfig.ZrokOwner = existingOwner
+		} else if r, ok := findReservation(state, proxyConfig.BackendURL.String(), proxyPort); ok {
+			// Reuse the token reserved for this project by a previous run
+			fmt.Printf("Reusing zrok token %s reserved for this project, URL: %s\n", r.Token, r.URL)
+			proxyConfig.ZrokToken = r.Token
+			proxyConfig.ZrokURL = r.URL
+			proxyConfig.ZrokPort = proxyPort
+			proxyConfig.ZrokOwner = models.TokenPersisted
 		} else {
-			// Auto mode: use existing token or create new one
-			if existingToken != "" {
-				// Reuse existing token for this port
-				fmt.Printf("Reusing existing zrok token: %s for port %s\n", existingToken, proxyPort)
-				config.ZrokToken = existingToken
-				config.ZrokURL = existingURL
-				config.ZrokPort = proxyPort
+			// Create a new token
+			token, url, err := tunnel.ReserveZrokToken(state.Settings.Load().Tunnel.Zrok, proxyPort)
+			if err != nil {
+				fmt.Printf("Failed to reserve zrok token: %v\n", err)
 			} else {
-				// Create a new token
-				token, url, err := tunnel.ReserveZrokToken(proxyPort)
-				if err != nil {
-					fmt.Printf("Failed to reserve zrok token: %v\n", err)
-				} else {
-					config.ZrokToken = token
-					config.ZrokURL = url
-					config.ZrokPort = proxyPort
-					fmt.Printf("Reserved new zrok token: %s for port %s, URL: %s\n", token, proxyPort, url)
+				proxyConfig.ZrokToken = token
+				proxyConfig.ZrokURL = url
+				proxyConfig.ZrokPort = proxyPort
+				fmt.Printf("Reserved new zrok token: %s for port %s, URL: %s\n", token, proxyPort, url)
+				proxyConfig.ZrokOwner = models.TokenReserved
+				if keepReservation(state, proxyConfig, proxyPort) {
+					proxyConfig.ZrokOwner = models.TokenPersisted
 				}
 			}
 		}
+	}
 
-		state.ConfigMu.Lock()
-		state.Config = config
-		state.ConfigMu.Unlock()
+	ctx, cancel := context.WithCancel(context.Background())
 
-		state.ZrokMu.Lock()
-		state.ZrokURL = "Initializing Zrok tunnel..."
-		state.ZrokMu.Unlock()
+	state.ConfigMu.Lock()
+	stopLifecycles(state)
+	state.Config = proxyConfig
+	state.ProxyCancel = cancel
+	state.ConfigMu.Unlock()
 
-		proxy.MonitorBackend(config.BackendURL, state)
+	// Setup has just reached the backend
+	state.StatusMu.Lock()
+	state.ServerStatus = "Active"
+	state.StatusMu.Unlock()
 
-		// Start zrok in a separate goroutine
-		go tunnel.StartZrok(state, proxyPort)
+	proxy.MonitorBackend(ctx, state)
 
-		http.Redirect(w, r, "/inspector/dashboard", http.StatusSeeOther)
+	if req.TunnelMode == TunnelNone {
+		state.Tunnel.Disable()
+		return nil
+	}
+
+	startTunnel(state, proxyPort)
+	return nil
+}
+
+// findReservation returns the token reserved for this project and backend
+// by a previous run, unless it has been released since
+func findReservation(state *models.AppState, backend, proxyPort string) (tunnel.Reservation, bool) {
+	settings := state.Settings.Load()
+	r, ok, err := state.Reservations.Find(settings.ProjectDir(), backend, proxyPort, settings.Tunnel.Zrok)
+	if err != nil {
+		fmt.Printf("Failed to read reserved zrok tokens: %v\n", err)
+		return r, false
+	}
+	if !ok {
+		return r, false
+	}
+	if r.PID != os.Getpid() && r.InUse() {
+		fmt.Printf("zrok token %s is shared by another DRIFT (pid %d), reserving a new one\n", r.Token, r.PID)
+		return r, false
+	}
+
+	// Only trust the state file when zrok can confirm the token
+	if tokens, err := tunnel.GetAllReservedZrokTokens(); err == nil && !slices.Contains(tokens, r.Token) {
+		fmt.Printf("zrok token %s is no longer reserved, reserving a new one\n", r.Token)
+		state.Reservations.Remove(r.Token)
+		return r, false
+	}
+
+	r.LastUsed = time.Now()
+	r.PID = os.Getpid()
+	state.Reservations.Put(r)
+	return r, true
+}
+
+// keepReservation records a new token in the state file, so that the next
+// run of this project gets the same public URL. It reports whether the
+// token was recorded.
+func keepReservation(state *models.AppState, proxyConfig *models.ProxyConfig, proxyPort string) bool {
+	settings := state.Settings.Load()
+	now := time.Now()
+	err := state.Reservations.Put(tunnel.Reservation{
+		Token:      proxyConfig.ZrokToken,
+		URL:        proxyConfig.ZrokURL,
+		Project:    settings.ProjectDir(),
+		Backend:    proxyConfig.BackendURL.String(),
+		Port:       proxyPort,
+		Mode:       settings.Tunnel.Zrok.Mode,
+		UniqueName: settings.Tunnel.Zrok.UniqueName,
+		ReservedAt: now,
+		LastUsed:   now,
+		PID:        os.Getpid(),
+	})
+	if err != nil {
+		fmt.Printf("Failed to save zrok token to %s, it will be released on exit: %v\n", state.Reservations.Path(), err)
+		return false
+	}
+	return true
+}
+
+// stopLifecycles cancels the backend monitor and tunnel of the current
+// configuration. The caller must hold state.ConfigMu.
+func stopLifecycles(state *models.AppState) {
+	if state.ProxyCancel != nil {
+		state.ProxyCancel()
+		state.ProxyCancel = nil
+	}
+	if state.TunnelCancel != nil {
+		state.TunnelCancel()
+		state.TunnelCancel = nil
+	}
+}
+
+// startTunnel starts the tunnel of the configured provider with its own
+// lifecycle, so that it can be restarted without touching the proxy
+func startTunnel(state *models.AppState, proxyPort string) {
+	tunnelCtx, cancel := context.WithCancel(context.Background())
+
+	state.ConfigMu.Lock()
+	if state.TunnelCancel != nil {
+		state.TunnelCancel()
+	}
+	state.TunnelCancel = cancel
+	token, url := "", ""
+	if state.Config != nil {
+		token, url = state.Config.ZrokToken, state.Config.ZrokURL
+	}
+	state.ConfigMu.Unlock()
+
+	settings := state.Settings.Load().Tunnel
+	t, err := tunnel.New(settings, token, url)
+	if err != nil {
+		fmt.Printf("Failed to create tunnel: %v\n", err)
+		return
+	}
+	if settings.Provider == config.TunnelZrok {
+		if token != "" {
+			fmt.Println("Using reserved zrok token:", token)
+		} else {
+			fmt.Println("No reserved token available, using random public share")
+		}
 	}
+
+	go func() {
+		if err := state.Tunnel.Start(tunnelCtx, t, proxyPort); err != nil {
+			fmt.Printf("Public URL will not be available: %v\n", err)
+		}
+	}()
+}
+
+// Unconfigure stops the proxy, its backend monitor and its tunnel
+func Unconfigure(state *models.AppState) bool {
+	state.ReconfigureMu.Lock()
+	defer state.ReconfigureMu.Unlock()
+
+	state.ConfigMu.Lock()
+	configured := state.Config != nil
+	stopLifecycles(state)
+	state.Config = nil
+	state.ConfigMu.Unlock()
+
+	state.Tunnel.Stop()
+
+	state.StatusMu.Lock()
+	state.ServerStatus = "Not configured"
+	state.StatusMu.Unlock()
+
+	return configured
 }
 
-// HandleHTTPRequest handles all HTTP requests
+// CleanupTunnel stops the tunnel and releases the reserved zrok token it
+// used when DRIFT owns it and does not keep it for the next run
+func CleanupTunnel(state *models.AppState) {
+	// Release the reserved token only if a tunnel was running
+	if !state.Tunnel.Stop() {
+		return
+	}
+
+	state.ConfigMu.Lock()
+	token, owner := "", ""
+	if state.Config != nil {
+		token, owner = state.Config.ZrokToken, state.Config.ZrokOwner
+	}
+	state.ConfigMu.Unlock()
+	if token == "" {
+		return
+	}
+
+	switch {
+	case owner == models.TokenUser:
+		fmt.Println("Keeping zrok token supplied by the user:", token)
+		return
+	case owner == models.TokenPersisted && !state.Settings.Load().Tunnel.ReleaseOnExit:
+		fmt.Println("Keeping zrok token for the next run:", token)
+		if err := state.Reservations.Lease(token, 0); err != nil {
+			fmt.Printf("Failed to update %s: %v\n", state.Reservations.Path(), err)
+		}
+		return
+	}
+
+	fmt.Println("Releasing zrok token:", token)
+	if err := tunnel.ReleaseZrokToken(token); err != nil {
+		fmt.Printf("Failed to release zrok token: %v\n", err)
+		return
+	}
+	fmt.Println("Successfully released zrok token")
+	if owner == models.TokenPersisted {
+		if _, err := state.Reservations.Remove(token); err != nil {
+			fmt.Printf("Failed to update %s: %v\n", state.Reservations.Path(), err)
+		}
+	}
+}
+
+// RestartTunnel stops the current tunnel and starts a new one for the
+// configured proxy
+func RestartTunnel(state *models.AppState, proxyPort string) error {
+	state.ConfigMu.Lock()
+	if state.Config == nil {
+		state.ConfigMu.Unlock()
+		return errNotConfigured
+	}
+	if state.Config.TunnelMode == TunnelNone {
+		state.ConfigMu.Unlock()
+		return errTunnelDisabled
+	}
+	state.ConfigMu.Unlock()
+
+	startTunnel(state, proxyPort)
+	return nil
+}
+
+// HandleHTTPRequest handles the requests of the admin listener: the
+// inspector pages, their static files and, for everything else, the proxy
 func HandleHTTPRequest(state *models.AppState, staticFiles embed.FS) http.HandlerFunc {
+	forward := HandleProxy(state)
 	return func(w http.ResponseWriter, r *http.Request) {
 		path := r.URL.Path
 
-		// Handle inspector routes - only allow from localhost
-		if strings.HasPrefix(path, "/inspector/") {
-			host := r.Host
-			isLocalhost := strings.HasPrefix(host, "localhost:")
-
-			if !isLocalhost {
-				// Not localhost - forward to proxy
-				state.ConfigMu.Lock()
-				if state.Config == nil || state.Config.Proxy == nil {
-					state.ConfigMu.Unlock()
-					http.Error(w, "Proxy not configured", http.StatusServiceUnavailable)
-					return
-				}
-				proxy := state.Config.Proxy
-				state.ConfigMu.Unlock()
-				proxy.ServeHTTP(w, r)
-				return
-			}
+		if path == "/inspector" {
+			http.Redirect(w, r, "/inspector/dashboard", http.StatusFound)
+			return
+		}
 
-			// Continue with normal inspector handling for localhost
-			if path == "/inspector/configure" || path == "/inspector/configure/" {
+		if strings.HasPrefix(path, "/inspector/") {
+			if path == "/inspector/login" || path == "/inspector/login/" {
+				http.ServeFileFS(w, r, staticFiles, "static/login/index.html")
+			} else if path == "/inspector/configure" || path == "/inspector/configure/" {
 				http.ServeFileFS(w, r, staticFiles, "static/configure/index.html")
 			} else if path == "/inspector/analytics" || path == "/inspector/analytics/" {
 				http.ServeFileFS(w, r, staticFiles, "static/analytics/index.html")
@@ -141,6 +393,25 @@ func HandleHTTPRequest(state *models.AppState, staticFiles embed.FS) http.Handle
 			return
 		}
 
+		// Replays go through the proxy from the inspector
+		if !auth.FromContext(r.Context()).Admin() {
+			writeJSON(w, http.StatusForbidden, apiError{Error: "replaying requests requires the admin role"})
+			return
+		}
+		forward(w, r.WithContext(proxy.InspectorContext(r.Context())))
+	}
+}
+
+// HandleProxy forwards every request to the configured proxy. It serves the
+// public port, which the tunnel points to, so that no admin route can be
+// reached through it.
+func HandleProxy(state *models.AppState) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Health probes of the tunnel only check that they get here
+		if state.Tunnel.AnswerProbe(w, r) {
+			return
+		}
+
 		state.ConfigMu.Lock()
 		if state.Config == nil || state.Config.Proxy == nil {
 			state.ConfigMu.Unlock()
@@ -0,0 +1,102 @@
+package handlers
+
+import (
+	"net/http"
+	"net/url"
+	"time"
+
+	"drift/internal/config"
+	"drift/internal/models"
+	"drift/internal/proxy"
+)
+
+// proxySettings are the settings baked into the proxy handler
+var proxySettings = map[string]bool{
+	"backend":   true,
+	"routes":    true,
+	"mocks":     true,
+	"faults":    true,
+	"access":    true,
+	"capture":   true,
+	"redaction": true,
+	// Descriptor, key and GeoIP files are loaded when the handler is built
+	"proto_descriptors": true,
+	"jwks":              true,
+	"trusted_proxies":   true,
+	"geoip":             true,
+}
+
+// ApplySettings swaps the running settings for next. Settings that need a
+// restart keep their current value, and the proxy handler is rebuilt when
+// its backend, routes, rules or capture limits changed. The listener, the
+// tunnel and the captured history are left untouched. It waits for a
+// configuration in progress, so that the handler it builds is never stored
+// over that of another backend.
+func ApplySettings(state *models.AppState, next *config.Config, trigger string) models.ReloadResult {
+	state.ReconfigureMu.Lock()
+	defer state.ReconfigureMu.Unlock()
+
+	current := state.Settings.Load()
+	merged := config.Merge(current, next)
+	result := models.ReloadResult{
+		Trigger:   trigger,
+		Timestamp: time.Now().Format(time.RFC3339),
+	}
+
+	rebuild := false
+	for _, setting := range config.Diff(current, next) {
+		if config.RequiresRestart(setting) {
+			result.RestartRequired = append(result.RestartRequired, setting)
+			continue
+		}
+		result.Applied = append(result.Applied, setting)
+		rebuild = rebuild || proxySettings[setting]
+	}
+
+	// Build the new handler before publishing anything so that a bad route
+	// leaves the running configuration untouched
+	var handler http.Handler
+	var backendURL *url.URL
+	if rebuild {
+		state.ConfigMu.Lock()
+		if state.Config != nil {
+			backendURL = state.Config.BackendURL
+		}
+		state.ConfigMu.Unlock()
+
+		if backendURL != nil {
+			if merged.Backend != "" && merged.Backend != current.Backend {
+				parsed, err := config.ParseBackend(merged.Backend)
+				if err != nil {
+					result.Error = err.Error()
+					result.Applied = nil
+					return result
+				}
+				backendURL = parsed
+			}
+
+			var err error
+			handler, err = proxy.NewHandler(backendURL, merged, state)
+			if err != nil {
+				result.Error = err.Error()
+				result.Applied = nil
+				return result
+			}
+		}
+	}
+
+	state.Settings.Store(merged)
+	state.Capture.SetPolicy(merged.Capture.Overflow)
+	state.Bodies.SetLimit(int64(merged.Capture.MaxSpill))
+	if handler != nil {
+		state.ConfigMu.Lock()
+		if state.Config != nil {
+			state.Config.Proxy = handler
+			state.Config.BackendURL = backendURL
+			state.Config.BackendPort = backendURL.Port()
+		}
+		state.ConfigMu.Unlock()
+	}
+
+	return result
+}
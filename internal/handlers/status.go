@@ -3,6 +3,7 @@ package handlers
 import (
 	"encoding/json"
 	"net/http"
+	"time"
 
 	"drift/internal/models"
 )
@@ -14,8 +15,6 @@ func GetStatus(state *models.AppState) http.HandlerFunc {
 		defer state.StatusMu.Unlock()
 		state.ConfigMu.Lock()
 		defer state.ConfigMu.Unlock()
-		state.ZrokMu.Lock()
-		defer state.ZrokMu.Unlock()
 
 		localhostURL := "http://localhost:4040"
 		if state.Config != nil && state.Config.BackendURL != nil {
@@ -25,7 +24,15 @@ func GetStatus(state *models.AppState) http.HandlerFunc {
 		response := models.StatusResponse{
 			ServerStatus: state.ServerStatus,
 			LocalhostURL: localhostURL,
-			ZrokURL:      state.ZrokURL,
+			Tunnel:       state.Tunnel.Status(),
+			Capture:      state.Capture.Stats(),
+			WebSocket:    state.Hub.Stats(),
+		}
+		response.PublicURL = response.Tunnel.URL
+		retention := state.Settings.Load().Retention
+		response.Retention = models.RetentionStatus{
+			MaxEntries: retention.MaxEntries,
+			MaxAgeMs:   time.Duration(retention.MaxAge).Milliseconds(),
 		}
 
 		w.Header().Set("Content-Type", "application/json")
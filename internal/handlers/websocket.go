@@ -4,15 +4,16 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
-	"time"
 
 	"drift/internal/models"
 
 	"github.com/gorilla/websocket"
 )
 
+// upgrader only accepts dashboards served by the inspector, so that other
+// sites open in the browser cannot read the captured traffic
 var upgrader = websocket.Upgrader{
-	CheckOrigin: func(r *http.Request) bool { return true },
+	CheckOrigin: sameOrigin,
 }
 
 // HandleWebSocket handles WebSocket connections
@@ -28,36 +29,8 @@ func HandleWebSocket(state *models.AppState) http.HandlerFunc {
 			return nil
 		})
 
-		state.ClientsMu.Lock()
-		state.Clients[conn] = true
-		state.ClientsMu.Unlock()
-
-		go func() {
-			ticker := time.NewTicker(30 * time.Second)
-			defer ticker.Stop()
-			for range ticker.C {
-				state.ClientsMu.Lock()
-				if _, ok := state.Clients[conn]; !ok {
-					state.ClientsMu.Unlock()
-					return
-				}
-				err := conn.WriteMessage(websocket.PingMessage, nil)
-				if err != nil {
-					conn.Close()
-					delete(state.Clients, conn)
-					state.ClientsMu.Unlock()
-					return
-				}
-				state.ClientsMu.Unlock()
-			}
-		}()
-
-		defer func() {
-			state.ClientsMu.Lock()
-			delete(state.Clients, conn)
-			state.ClientsMu.Unlock()
-			conn.Close()
-		}()
+		client := state.Hub.Register(conn)
+		defer state.Hub.Unregister(client)
 
 		for {
 			_, _, err := conn.ReadMessage()
@@ -68,25 +41,12 @@ func HandleWebSocket(state *models.AppState) http.HandlerFunc {
 	}
 }
 
-// BroadcastLogs broadcasts API logs to all connected WebSocket clients
-func BroadcastLogs(state *models.AppState) {
-	go func() {
-		for logEntry := range state.LogChan {
-			logJSON, err := json.Marshal(logEntry)
-			if err != nil {
-				fmt.Printf("Error marshaling log: %v\n", err)
-				continue
-			}
-
-			state.ClientsMu.Lock()
-			for client := range state.Clients {
-				err := client.WriteMessage(websocket.TextMessage, logJSON)
-				if err != nil {
-					client.Close()
-					delete(state.Clients, client)
-				}
-			}
-			state.ClientsMu.Unlock()
-		}
-	}()
+// BroadcastEvent sends a server event to all connected WebSocket clients
+func BroadcastEvent(state *models.AppState, eventType string, data interface{}) {
+	eventJSON, err := json.Marshal(models.Event{Type: eventType, Data: data})
+	if err != nil {
+		fmt.Printf("Error marshaling event: %v\n", err)
+		return
+	}
+	state.Hub.Broadcast(eventJSON)
 }
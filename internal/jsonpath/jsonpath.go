@@ -0,0 +1,89 @@
+// Package jsonpath parses the JSON paths that select the body values of
+// redaction rules
+package jsonpath
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// Segment is a step of a JSON path: a member name, an array index or a
+// wildcard, optionally matched at any depth
+type Segment struct {
+	Name string
+	// Index is -1 for member names and wildcards
+	Index     int
+	Wildcard  bool
+	Recursive bool
+}
+
+// MatchesKey reports whether the segment selects the member key
+func (s Segment) MatchesKey(key string) bool {
+	return s.Wildcard || (s.Index < 0 && s.Name == key)
+}
+
+// MatchesIndex reports whether the segment selects the array item i
+func (s Segment) MatchesIndex(i int) bool {
+	return s.Wildcard || s.Index == i
+}
+
+// Parse parses the subset of JSON path used by redaction rules:
+// $.name, $['name'], $[0], $[*], $.* and $..name
+func Parse(path string) ([]Segment, error) {
+	if !strings.HasPrefix(path, "$") {
+		return nil, errors.New(`must start with "$"`)
+	}
+	rest := path[1:]
+	var segments []Segment
+
+	for rest != "" {
+		segment := Segment{Index: -1}
+		switch {
+		case strings.HasPrefix(rest, ".."):
+			segment.Recursive = true
+			rest = rest[2:]
+		case rest[0] == '.':
+			rest = rest[1:]
+		case rest[0] != '[':
+			return nil, errors.New("expected \".\" or \"[\" at " + strconv.Quote(rest))
+		}
+
+		if strings.HasPrefix(rest, "[") {
+			end := strings.Index(rest, "]")
+			if end < 0 {
+				return nil, errors.New("unclosed \"[\"")
+			}
+			inner := rest[1:end]
+			rest = rest[end+1:]
+			switch {
+			case inner == "*":
+				segment.Wildcard = true
+			case len(inner) >= 2 && (inner[0] == '\'' || inner[0] == '"') && inner[len(inner)-1] == inner[0]:
+				segment.Name = inner[1 : len(inner)-1]
+			default:
+				index, err := strconv.Atoi(inner)
+				if err != nil || index < 0 {
+					return nil, errors.New("invalid index " + strconv.Quote(inner))
+				}
+				segment.Index = index
+			}
+		} else {
+			end := strings.IndexAny(rest, ".[")
+			if end < 0 {
+				end = len(rest)
+			}
+			segment.Name, rest = rest[:end], rest[end:]
+			if segment.Name == "" {
+				return nil, errors.New("empty member name")
+			}
+			segment.Wildcard = segment.Name == "*"
+		}
+		segments = append(segments, segment)
+	}
+
+	if len(segments) == 0 {
+		return nil, errors.New("selects the whole document")
+	}
+	return segments, nil
+}
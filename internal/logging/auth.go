@@ -0,0 +1,201 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"drift/internal/models"
+)
+
+// decodeAuth decodes the Authorization header of a request: bearer JWTs,
+// verified against keys when it is set, and basic credentials
+func decodeAuth(header http.Header, at time.Time, keys *KeySet) *models.AuthInfo {
+	scheme, credentials, ok := strings.Cut(strings.TrimSpace(header.Get("Authorization")), " ")
+	if !ok {
+		return nil
+	}
+	credentials = strings.TrimSpace(credentials)
+
+	switch strings.ToLower(scheme) {
+	case models.AuthBearer:
+		if strings.Count(credentials, ".") != 2 {
+			// Opaque tokens carry nothing to decode
+			return &models.AuthInfo{Scheme: models.AuthBearer}
+		}
+		return &models.AuthInfo{Scheme: models.AuthBearer, JWT: decodeJWT(credentials, at, keys)}
+	case models.AuthBasic:
+		auth := &models.AuthInfo{Scheme: models.AuthBasic}
+		decoded, err := base64.StdEncoding.DecodeString(credentials)
+		if err != nil {
+			return auth
+		}
+		username, password, _ := strings.Cut(string(decoded), ":")
+		auth.Username = username
+		if password != "" {
+			auth.Password = redacted
+		}
+		return auth
+	}
+	return nil
+}
+
+// jwtClaims are the registered claims DRIFT reports
+type jwtClaims struct {
+	Issuer    string          `json:"iss"`
+	Subject   string          `json:"sub"`
+	Audience  json.RawMessage `json:"aud"`
+	ExpiresAt *json.Number    `json:"exp"`
+	NotBefore *json.Number    `json:"nbf"`
+	IssuedAt  *json.Number    `json:"iat"`
+}
+
+// decodeJWT decodes a compact JWT and checks its validity period at the
+// time it was sent
+func decodeJWT(token string, at time.Time, keys *KeySet) *models.JWTInfo {
+	info := &models.JWTInfo{Signature: models.SignatureUnverified}
+	parts := strings.Split(token, ".")
+
+	header, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil || !json.Valid(header) {
+		info.Error = "header is not base64url-encoded JSON"
+		return info
+	}
+	info.Header = compactJSON(header)
+	var jose struct {
+		Algorithm string `json:"alg"`
+		KeyID     string `json:"kid"`
+	}
+	json.Unmarshal(header, &jose)
+	info.Algorithm, info.KeyID = jose.Algorithm, jose.KeyID
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil || !json.Valid(payload) {
+		// Encrypted or detached payloads cannot be read
+		info.Error = "payload is not base64url-encoded JSON"
+		return info
+	}
+	info.Claims = compactJSON(payload)
+
+	var claims jwtClaims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		info.Error = "claims are not a JSON object"
+		return info
+	}
+	info.Issuer, info.Subject = claims.Issuer, claims.Subject
+	info.Audience = audience(claims.Audience)
+
+	if exp, ok := numericDate(claims.ExpiresAt); ok {
+		info.ExpiresAt = exp.Format(time.RFC3339)
+		info.Expired = !at.Before(exp)
+	}
+	if nbf, ok := numericDate(claims.NotBefore); ok {
+		info.NotBefore = nbf.Format(time.RFC3339)
+		info.NotYetValid = at.Before(nbf)
+	}
+	if iat, ok := numericDate(claims.IssuedAt); ok {
+		info.IssuedAt = iat.Format(time.RFC3339)
+	}
+
+	if keys != nil {
+		info.Signature, info.SignatureError = keys.verify(jose.Algorithm, jose.KeyID, parts)
+	}
+	return info
+}
+
+// audience reads the aud claim, a string or an array of strings
+func audience(raw json.RawMessage) []string {
+	var single string
+	if err := json.Unmarshal(raw, &single); err == nil {
+		return []string{single}
+	}
+	var list []string
+	json.Unmarshal(raw, &list)
+	return list
+}
+
+// numericDate converts a JWT NumericDate, seconds since the epoch that may
+// have a fraction
+func numericDate(n *json.Number) (time.Time, bool) {
+	if n == nil {
+		return time.Time{}, false
+	}
+	seconds, err := n.Float64()
+	if err != nil || math.IsInf(seconds, 0) || math.Abs(seconds) > 1<<53 {
+		return time.Time{}, false
+	}
+	whole, fraction := math.Modf(seconds)
+	return time.Unix(int64(whole), int64(fraction*float64(time.Second))).UTC(), true
+}
+
+func compactJSON(data []byte) json.RawMessage {
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, data); err != nil {
+		return nil
+	}
+	return buf.Bytes()
+}
+
+// requestCookies parses the Cookie headers of a request
+func requestCookies(header http.Header) []models.Cookie {
+	var cookies []models.Cookie
+	for _, line := range header.Values("Cookie") {
+		parsed, err := http.ParseCookie(line)
+		if err != nil {
+			cookies = append(cookies, models.Cookie{Error: err.Error()})
+			continue
+		}
+		for _, c := range parsed {
+			cookies = append(cookies, models.Cookie{Name: c.Name, Value: c.Value})
+		}
+	}
+	return cookies
+}
+
+// responseCookies parses the Set-Cookie headers of a response with their
+// attributes
+func responseCookies(header http.Header) []models.Cookie {
+	var cookies []models.Cookie
+	for _, line := range header.Values("Set-Cookie") {
+		c, err := http.ParseSetCookie(line)
+		if err != nil {
+			cookies = append(cookies, models.Cookie{Error: err.Error()})
+			continue
+		}
+		cookie := models.Cookie{
+			Name:        c.Name,
+			Value:       c.Value,
+			Path:        c.Path,
+			Domain:      c.Domain,
+			MaxAge:      c.MaxAge,
+			Secure:      c.Secure,
+			HttpOnly:    c.HttpOnly,
+			SameSite:    sameSite(c.SameSite),
+			Partitioned: c.Partitioned,
+		}
+		if c.RawExpires != "" {
+			cookie.Expires = c.RawExpires
+			if !c.Expires.IsZero() {
+				cookie.Expires = c.Expires.UTC().Format(time.RFC3339)
+			}
+		}
+		cookies = append(cookies, cookie)
+	}
+	return cookies
+}
+
+func sameSite(mode http.SameSite) string {
+	switch mode {
+	case http.SameSiteLaxMode:
+		return "Lax"
+	case http.SameSiteStrictMode:
+		return "Strict"
+	case http.SameSiteNoneMode:
+		return "None"
+	}
+	return ""
+}
@@ -0,0 +1,202 @@
+package logging
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"drift/internal/models"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+	"golang.org/x/text/encoding/htmlindex"
+)
+
+// textTypes are media types captured as text besides text/*
+var textTypes = map[string]bool{
+	"application/json":                  true,
+	"application/xml":                   true,
+	"application/javascript":            true,
+	"application/ecmascript":            true,
+	"application/x-www-form-urlencoded": true,
+	"application/graphql":               true,
+	"application/x-ndjson":              true,
+	"application/ndjson":                true,
+	"application/jsonl":                 true,
+	"application/yaml":                  true,
+	"application/x-yaml":                true,
+	"application/toml":                  true,
+	"application/sql":                   true,
+	"image/svg+xml":                     true,
+}
+
+// isTextType reports whether a media type holds text
+func isTextType(mediaType string) bool {
+	return strings.HasPrefix(mediaType, "text/") ||
+		textTypes[mediaType] ||
+		strings.HasSuffix(mediaType, "+json") ||
+		strings.HasSuffix(mediaType, "+xml")
+}
+
+// bodyParser extracts the structure of a decoded body, or returns nil
+type bodyParser func(body []byte, mediaType string, params map[string]string, truncated bool) *models.ParsedBody
+
+// captureBody converts a raw message body into its captured form. Content
+// encodings are undone, text is converted to UTF-8 and anything else is
+// stored as base64 along with its SHA-256. A truncated body is decoded as
+// far as its head allows. parse defaults to parseBody.
+func captureBody(raw []byte, header http.Header, truncated bool, parse bodyParser) (string, models.BodyInfo) {
+	info := models.BodyInfo{Size: int64(len(raw))}
+	if len(raw) == 0 {
+		return "", info
+	}
+
+	body := raw
+	if coding := header.Get("Content-Encoding"); coding != "" {
+		decoded, err := decodeContent(raw, coding, truncated)
+		if err != nil {
+			info.DecodeError = err.Error()
+		} else {
+			body = decoded
+			info.ContentEncoding = coding
+			info.EncodedSize = int64(len(raw))
+			info.Size = int64(len(decoded))
+		}
+	}
+
+	contentType := header.Get("Content-Type")
+	if contentType == "" || info.DecodeError != "" {
+		contentType = http.DetectContentType(body)
+	}
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType = strings.ToLower(strings.TrimSpace(strings.Split(contentType, ";")[0]))
+	}
+	info.ContentType = mediaType
+	if parse == nil {
+		parse = parseBody
+	}
+	info.Parsed = parse(body, mediaType, params, truncated)
+
+	if isTextType(mediaType) {
+		text := body
+		if truncated {
+			text = trimPartialRune(text)
+		}
+		if text, charset, ok := toUTF8(text, params["charset"]); ok {
+			info.Encoding = models.BodyText
+			info.Charset = charset
+			return text, info
+		}
+	}
+
+	sum := sha256.Sum256(body)
+	info.Encoding = models.BodyBase64
+	info.SHA256 = hex.EncodeToString(sum[:])
+	return base64.StdEncoding.EncodeToString(body), info
+}
+
+// trimPartialRune drops a UTF-8 sequence cut off at the end of a truncated body
+func trimPartialRune(body []byte) []byte {
+	for i := 1; i < utf8.UTFMax && i <= len(body); i++ {
+		if utf8.RuneStart(body[len(body)-i]) {
+			if !utf8.FullRune(body[len(body)-i:]) {
+				return body[:len(body)-i]
+			}
+			break
+		}
+	}
+	return body
+}
+
+// toUTF8 decodes text in the given charset. It fails when the charset is
+// unknown or the result is not valid UTF-8.
+func toUTF8(body []byte, charset string) (string, string, bool) {
+	charset = strings.ToLower(strings.TrimSpace(charset))
+	if charset == "" || charset == "utf-8" || charset == "utf8" || charset == "us-ascii" {
+		return string(body), charset, utf8.Valid(body)
+	}
+
+	encoding, err := htmlindex.Get(charset)
+	if err != nil {
+		return "", charset, false
+	}
+	decoded, err := encoding.NewDecoder().Bytes(body)
+	if err != nil || !utf8.Valid(decoded) {
+		return "", charset, false
+	}
+	return string(decoded), charset, true
+}
+
+// decodeContent undoes the content codings listed in a Content-Encoding
+// header, last applied first. When the body is truncated whatever could be
+// decoded before the cut is kept.
+func decodeContent(body []byte, contentEncoding string, truncated bool) ([]byte, error) {
+	codings := strings.Split(contentEncoding, ",")
+	for i := len(codings) - 1; i >= 0; i-- {
+		coding := strings.ToLower(strings.TrimSpace(codings[i]))
+		decoded, err := decodeCoding(body, coding)
+		if err != nil && !(truncated && len(decoded) > 0) {
+			return nil, fmt.Errorf("%s: %w", coding, err)
+		}
+		body = decoded
+	}
+	return body, nil
+}
+
+// maxDecodedBody bounds the output of content decoding so that a small
+// compressed body cannot expand without limit
+const maxDecodedBody = 64 << 20
+
+// readDecoded reads a decoder's output up to maxDecodedBody bytes
+func readDecoded(reader io.Reader) ([]byte, error) {
+	decoded, err := io.ReadAll(io.LimitReader(reader, maxDecodedBody+1))
+	if len(decoded) > maxDecodedBody {
+		return decoded[:maxDecodedBody], fmt.Errorf("decoded body exceeds %d bytes", maxDecodedBody)
+	}
+	return decoded, err
+}
+
+func decodeCoding(body []byte, coding string) ([]byte, error) {
+	switch coding {
+	case "", "identity":
+		return body, nil
+	case "gzip", "x-gzip":
+		reader, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		defer reader.Close()
+		return readDecoded(reader)
+	case "br":
+		return readDecoded(brotli.NewReader(bytes.NewReader(body)))
+	case "zstd":
+		reader, err := zstd.NewReader(bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		defer reader.Close()
+		return readDecoded(reader)
+	case "deflate":
+		// "deflate" is zlib-wrapped per the spec, but some servers send raw deflate
+		if reader, err := zlib.NewReader(bytes.NewReader(body)); err == nil {
+			defer reader.Close()
+			if decoded, err := readDecoded(reader); err == nil || len(decoded) > 0 {
+				return decoded, err
+			}
+		}
+		return readDecoded(flate.NewReader(bytes.NewReader(body)))
+	default:
+		return nil, fmt.Errorf("unsupported content encoding")
+	}
+}
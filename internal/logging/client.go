@@ -0,0 +1,181 @@
+package logging
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"strings"
+
+	"drift/internal/config"
+	"drift/internal/models"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// ClientLocator finds the address of the client behind trusted proxies, such
+// as the zrok agent, and looks up where it comes from in GeoIP databases
+type ClientLocator struct {
+	trusted   []netip.Prefix
+	databases []*maxminddb.Reader
+}
+
+// NewClientLocator parses the trusted proxy addresses and CIDR ranges and
+// loads the MaxMind databases
+func NewClientLocator(trusted []string, databases []string) (*ClientLocator, error) {
+	l := &ClientLocator{}
+	for _, entry := range trusted {
+		prefix, err := config.ParseAddressRange(entry)
+		if err != nil {
+			return nil, err
+		}
+		l.trusted = append(l.trusted, prefix)
+	}
+	for _, path := range databases {
+		// Databases are read into memory so that a reload never unmaps one
+		// still used by in-flight requests
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("reading GeoIP database: %w", err)
+		}
+		db, err := maxminddb.FromBytes(data)
+		if err != nil {
+			return nil, fmt.Errorf("GeoIP database %s: %w", path, err)
+		}
+		l.databases = append(l.databases, db)
+	}
+	return l, nil
+}
+
+func (l *ClientLocator) isTrusted(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, prefix := range l.trusted {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientAddress returns the address of the client that sent a request. The
+// forwarding headers are only believed when the peer is a trusted proxy, and
+// are read from the right, the first untrusted hop being the client.
+// X-Real-IP is only read when there is neither Forwarded nor X-Forwarded-For.
+func (l *ClientLocator) ClientAddress(req *http.Request) netip.Addr {
+	peer := remoteAddr(req.RemoteAddr)
+	if l == nil || !peer.IsValid() || !l.isTrusted(peer) {
+		return peer
+	}
+
+	if req.Header.Get("Forwarded") == "" && req.Header.Get("X-Forwarded-For") == "" {
+		// Proxies that only set X-Real-IP put the client there
+		if realIP := remoteAddr(strings.TrimSpace(req.Header.Get("X-Real-IP"))); realIP.IsValid() {
+			return realIP
+		}
+		return peer
+	}
+
+	// Forwarded is preferred as X-Forwarded-For is also extended by the
+	// proxy itself with the peer address
+	hops := forwardedFor(req.Header.Values("Forwarded"))
+	if len(hops) == 0 {
+		for _, value := range req.Header.Values("X-Forwarded-For") {
+			for _, hop := range strings.Split(value, ",") {
+				hops = append(hops, strings.TrimSpace(hop))
+			}
+		}
+	}
+	client := peer
+	for i := len(hops) - 1; i >= 0; i-- {
+		addr := remoteAddr(hops[i])
+		if !addr.IsValid() {
+			// Obfuscated or unknown hops end the chain that can be followed
+			return client
+		}
+		if !l.isTrusted(addr) {
+			return addr
+		}
+		client = addr
+	}
+	return client
+}
+
+// forwardedFor returns the for= parameters of Forwarded headers (RFC 7239)
+func forwardedFor(values []string) []string {
+	var hops []string
+	for _, value := range values {
+		for _, element := range strings.Split(value, ",") {
+			for _, pair := range strings.Split(element, ";") {
+				key, hop, ok := strings.Cut(strings.TrimSpace(pair), "=")
+				if ok && strings.EqualFold(key, "for") {
+					hops = append(hops, strings.Trim(hop, `"`))
+				}
+			}
+		}
+	}
+	return hops
+}
+
+// remoteAddr parses an address with an optional port, IPv6 addresses being
+// bracketed when they have one
+func remoteAddr(s string) netip.Addr {
+	if host, _, err := net.SplitHostPort(s); err == nil {
+		s = host
+	}
+	addr, err := netip.ParseAddr(strings.Trim(s, "[]"))
+	if err != nil {
+		return netip.Addr{}
+	}
+	return addr.Unmap().WithZone("")
+}
+
+// geoRecord holds the fields read from GeoIP2/GeoLite2 City, Country and ASN
+// databases
+type geoRecord struct {
+	Country struct {
+		ISOCode string            `maxminddb:"iso_code"`
+		Names   map[string]string `maxminddb:"names"`
+	} `maxminddb:"country"`
+	Subdivisions []struct {
+		Names map[string]string `maxminddb:"names"`
+	} `maxminddb:"subdivisions"`
+	City struct {
+		Names map[string]string `maxminddb:"names"`
+	} `maxminddb:"city"`
+	ASN          uint   `maxminddb:"autonomous_system_number"`
+	Organization string `maxminddb:"autonomous_system_organization"`
+}
+
+// geo looks an address up in every database, merging what they know
+func (l *ClientLocator) geo(addr netip.Addr) *models.GeoInfo {
+	if l == nil || len(l.databases) == 0 || !addr.IsValid() || !addr.IsGlobalUnicast() || addr.IsPrivate() {
+		return nil
+	}
+
+	info := &models.GeoInfo{}
+	for _, db := range l.databases {
+		var record geoRecord
+		if err := db.Lookup(net.IP(addr.AsSlice()), &record); err != nil {
+			continue
+		}
+		if info.Country == "" {
+			info.Country = record.Country.ISOCode
+			info.CountryName = record.Country.Names["en"]
+		}
+		if info.Region == "" && len(record.Subdivisions) > 0 {
+			info.Region = record.Subdivisions[0].Names["en"]
+		}
+		if info.City == "" {
+			info.City = record.City.Names["en"]
+		}
+		if info.ASN == 0 {
+			info.ASN = record.ASN
+			info.Organization = record.Organization
+		}
+	}
+	if *info == (models.GeoInfo{}) {
+		return nil
+	}
+	return info
+}
@@ -0,0 +1,43 @@
+package logging
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientAddress(t *testing.T) {
+	locator, err := NewClientLocator([]string{"127.0.0.1", "10.0.0.0/8"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		peer    string
+		headers map[string]string
+		want    string
+	}{
+		{"untrusted peer", "203.0.113.9:4000", map[string]string{"X-Forwarded-For": "198.51.100.1", "X-Real-IP": "198.51.100.2"}, "203.0.113.9"},
+		{"no headers", "127.0.0.1:4000", nil, "127.0.0.1"},
+		{"x-forwarded-for", "127.0.0.1:4000", map[string]string{"X-Forwarded-For": "198.51.100.1, 10.0.0.2"}, "198.51.100.1"},
+		{"forwarded", "127.0.0.1:4000", map[string]string{"Forwarded": `for="[2001:db8::1]:80", for=10.0.0.2`, "X-Forwarded-For": "198.51.100.1"}, "2001:db8::1"},
+		{"every hop trusted", "127.0.0.1:4000", map[string]string{"X-Forwarded-For": "10.0.0.3, 10.0.0.2"}, "10.0.0.3"},
+		{"x-real-ip", "127.0.0.1:4000", map[string]string{"X-Real-IP": "198.51.100.2"}, "198.51.100.2"},
+		{"x-real-ip from untrusted peer", "203.0.113.9:4000", map[string]string{"X-Real-IP": "198.51.100.2"}, "203.0.113.9"},
+		{"x-real-ip with x-forwarded-for", "127.0.0.1:4000", map[string]string{"X-Forwarded-For": "10.0.0.2", "X-Real-IP": "198.51.100.2"}, "10.0.0.2"},
+		{"x-real-ip with forwarded", "127.0.0.1:4000", map[string]string{"Forwarded": "for=unknown", "X-Real-IP": "198.51.100.2"}, "127.0.0.1"},
+		{"invalid x-real-ip", "127.0.0.1:4000", map[string]string{"X-Real-IP": "nope"}, "127.0.0.1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			req.RemoteAddr = tt.peer
+			for name, value := range tt.headers {
+				req.Header.Set(name, value)
+			}
+			if got := locator.ClientAddress(req).String(); got != tt.want {
+				t.Errorf("ClientAddress = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
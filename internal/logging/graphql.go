@@ -0,0 +1,309 @@
+package logging
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"drift/internal/models"
+)
+
+// graphqlRequest is the JSON body of a GraphQL request over HTTP
+type graphqlRequest struct {
+	Query         *string         `json:"query"`
+	OperationName string          `json:"operationName"`
+	Variables     json.RawMessage `json:"variables"`
+	Extensions    struct {
+		PersistedQuery *struct {
+			SHA256Hash string `json:"sha256Hash"`
+		} `json:"persistedQuery"`
+	} `json:"extensions"`
+}
+
+// graphqlOperations recognizes a GraphQL request, sent as JSON (alone or in
+// a batch), as application/graphql or in the query string of a GET, and
+// returns its operations. It returns nil for anything else.
+func graphqlOperations(log models.RequestLog) []models.GraphQLOperation {
+	if log.Method == http.MethodGet {
+		u, err := url.Parse(log.URL)
+		if err != nil {
+			return nil
+		}
+		query := u.Query()
+		if !query.Has("query") && !query.Has("extensions") {
+			return nil
+		}
+		gql := graphqlRequest{OperationName: query.Get("operationName")}
+		if query.Has("query") {
+			text := query.Get("query")
+			gql.Query = &text
+		}
+		if variables := query.Get("variables"); json.Valid([]byte(variables)) {
+			gql.Variables = json.RawMessage(variables)
+		}
+		if extensions := query.Get("extensions"); extensions != "" {
+			json.Unmarshal([]byte(extensions), &gql.Extensions)
+		}
+		if operation, ok := gql.operation(); ok {
+			// Only queries may be sent with GET
+			if operation.Type == "" {
+				operation.Type = "query"
+			}
+			return []models.GraphQLOperation{operation}
+		}
+		return nil
+	}
+
+	if log.Encoding != models.BodyText || log.Body == "" {
+		return nil
+	}
+
+	if log.ContentType == "application/graphql" {
+		gql := graphqlRequest{Query: &log.Body}
+		if operation, ok := gql.operation(); ok {
+			return []models.GraphQLOperation{operation}
+		}
+		return nil
+	}
+
+	if log.ContentType != "application/json" && !strings.HasSuffix(log.ContentType, "+json") {
+		return nil
+	}
+
+	body := strings.TrimSpace(log.Body)
+	var batch []graphqlRequest
+	if strings.HasPrefix(body, "[") {
+		if err := json.Unmarshal([]byte(body), &batch); err != nil {
+			return nil
+		}
+	} else {
+		var gql graphqlRequest
+		if err := json.Unmarshal([]byte(body), &gql); err != nil {
+			return nil
+		}
+		batch = []graphqlRequest{gql}
+	}
+
+	var operations []models.GraphQLOperation
+	for _, gql := range batch {
+		operation, ok := gql.operation()
+		if !ok {
+			return nil
+		}
+		operations = append(operations, operation)
+	}
+	return operations
+}
+
+// operation extracts the operation from a request, reporting false when it
+// is not a GraphQL request
+func (g graphqlRequest) operation() (models.GraphQLOperation, bool) {
+	operation := models.GraphQLOperation{Name: g.OperationName}
+	if len(g.Variables) > 0 && string(g.Variables) != "null" {
+		operation.Variables = g.Variables
+	}
+	if persisted := g.Extensions.PersistedQuery; persisted != nil {
+		operation.PersistedQueryHash = persisted.SHA256Hash
+	}
+
+	if g.Query == nil || strings.TrimSpace(*g.Query) == "" {
+		// Persisted queries may omit the text once it is registered
+		return operation, operation.PersistedQueryHash != ""
+	}
+
+	opType, name, ok := findOperation(*g.Query, g.OperationName)
+	if !ok {
+		return operation, false
+	}
+	operation.Type = opType
+	if operation.Name == "" {
+		operation.Name = name
+	}
+	return operation, true
+}
+
+// findOperation scans a GraphQL document for the operation called name, or
+// the first operation when name is empty, and returns its type and name
+func findOperation(document, name string) (string, string, bool) {
+	s := &graphqlScanner{src: document}
+	found := false
+	var firstType, firstName string
+
+	for {
+		token := s.next()
+		switch {
+		case token == "":
+			return firstType, firstName, found
+		case token == "{":
+			// Shorthand query without a name
+			if !found {
+				firstType, found = "query", true
+			}
+			s.skipBlock()
+		case token == "query" || token == "mutation" || token == "subscription":
+			opName := s.peekName()
+			if name != "" && opName == name {
+				return token, opName, true
+			}
+			if !found {
+				firstType, firstName, found = token, opName, true
+			}
+			s.skipDefinition()
+		case token == "fragment" || token == "extend" || token == "schema" || token == "type" ||
+			token == "interface" || token == "union" || token == "enum" || token == "input" ||
+			token == "scalar" || token == "directive":
+			s.skipDefinition()
+		default:
+			return "", "", false
+		}
+	}
+}
+
+// graphqlScanner is a minimal GraphQL lexer that is just enough to find the
+// operation definitions of a document
+type graphqlScanner struct {
+	src string
+	pos int
+}
+
+// skipIgnored skips whitespace, commas and comments
+func (s *graphqlScanner) skipIgnored() {
+	for s.pos < len(s.src) {
+		switch c := s.src[s.pos]; {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == ',':
+			s.pos++
+		case c == '#':
+			for s.pos < len(s.src) && s.src[s.pos] != '\n' {
+				s.pos++
+			}
+		default:
+			return
+		}
+	}
+}
+
+// next returns the next name or punctuator, skipping strings
+func (s *graphqlScanner) next() string {
+	s.skipIgnored()
+	if s.pos >= len(s.src) {
+		return ""
+	}
+	c := s.src[s.pos]
+	if isNameStart(c) {
+		start := s.pos
+		for s.pos < len(s.src) && isNameChar(s.src[s.pos]) {
+			s.pos++
+		}
+		return s.src[start:s.pos]
+	}
+	if c == '"' {
+		s.skipString()
+		return `"`
+	}
+	s.pos++
+	return string(c)
+}
+
+// peekName returns the operation name that follows a keyword, if any
+func (s *graphqlScanner) peekName() string {
+	s.skipIgnored()
+	if s.pos < len(s.src) && isNameStart(s.src[s.pos]) {
+		return s.next()
+	}
+	return ""
+}
+
+// skipString skips a string or block string starting at the current position
+func (s *graphqlScanner) skipString() {
+	if strings.HasPrefix(s.src[s.pos:], `"""`) {
+		end := strings.Index(s.src[s.pos+3:], `"""`)
+		if end < 0 {
+			s.pos = len(s.src)
+		} else {
+			s.pos += 3 + end + 3
+		}
+		return
+	}
+	s.pos++
+	for s.pos < len(s.src) {
+		switch s.src[s.pos] {
+		case '\\':
+			s.pos += 2
+			continue
+		case '"', '\n':
+			s.pos++
+			return
+		}
+		s.pos++
+	}
+}
+
+// skipDefinition skips the rest of a definition up to the end of its
+// selection set or body
+func (s *graphqlScanner) skipDefinition() {
+	for {
+		token := s.next()
+		switch token {
+		case "":
+			return
+		case "{":
+			s.skipBlock()
+			return
+		}
+	}
+}
+
+// skipBlock skips to the brace closing an opened block
+func (s *graphqlScanner) skipBlock() {
+	depth := 1
+	for depth > 0 {
+		switch s.next() {
+		case "":
+			return
+		case "{":
+			depth++
+		case "}":
+			depth--
+		}
+	}
+}
+
+func isNameStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isNameChar(c byte) bool {
+	return isNameStart(c) || (c >= '0' && c <= '9')
+}
+
+// graphqlErrors returns the top-level errors of a GraphQL response, or of
+// every response of a batch
+func graphqlErrors(log models.ResponseLog) []models.GraphQLError {
+	if log.Encoding != models.BodyText || log.Body == "" {
+		return nil
+	}
+
+	type response struct {
+		Errors []models.GraphQLError `json:"errors"`
+	}
+	body := strings.TrimSpace(log.Body)
+	var responses []response
+	if strings.HasPrefix(body, "[") {
+		if err := json.Unmarshal([]byte(body), &responses); err != nil {
+			return nil
+		}
+	} else {
+		var single response
+		if err := json.Unmarshal([]byte(body), &single); err != nil {
+			return nil
+		}
+		responses = []response{single}
+	}
+
+	var errs []models.GraphQLError
+	for _, r := range responses {
+		errs = append(errs, r.Errors...)
+	}
+	return errs
+}
@@ -0,0 +1,217 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"net/http"
+	"net/textproto"
+	"sort"
+	"sync"
+
+	"drift/internal/models"
+)
+
+// maxHeaderBlock bounds the bytes recorded for one header block
+const maxHeaderBlock = 64 << 10
+
+var headerTerminator = []byte("\r\n\r\n")
+
+// tapConn records the raw header block of each HTTP/1 message read from a
+// connection so that the wire order of header fields can be recovered
+type tapConn struct {
+	net.Conn
+
+	mu        sync.Mutex
+	recording bool
+	buffer    []byte
+	block     []byte
+	// armOnWrite re-arms recording after each write, for client connections
+	// where a response follows every request written
+	armOnWrite bool
+}
+
+func (c *tapConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if n > 0 {
+		c.record(p[:n])
+	}
+	return n, err
+}
+
+func (c *tapConn) Write(p []byte) (int, error) {
+	if c.armOnWrite {
+		c.arm()
+	}
+	return c.Conn.Write(p)
+}
+
+func (c *tapConn) record(data []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.recording {
+		return
+	}
+
+	c.buffer = append(c.buffer, data...)
+	if end := bytes.Index(c.buffer, headerTerminator); end >= 0 {
+		c.block = append([]byte(nil), c.buffer[:end+2]...)
+		c.buffer = c.buffer[:0]
+		c.recording = false
+	} else if len(c.buffer) > maxHeaderBlock {
+		c.buffer = c.buffer[:0]
+		c.recording = false
+	}
+}
+
+// arm starts recording the next header block
+func (c *tapConn) arm() {
+	c.mu.Lock()
+	c.recording = true
+	c.buffer = c.buffer[:0]
+	c.mu.Unlock()
+}
+
+// headerOrder returns the header names of the last recorded block, in the
+// order they appeared on the wire
+func (c *tapConn) headerOrder() []string {
+	c.mu.Lock()
+	block := c.block
+	c.mu.Unlock()
+	return parseHeaderOrder(block)
+}
+
+// parseHeaderOrder extracts the field names of a raw header block. The start
+// line is skipped; it may be truncated when the server read ahead.
+func parseHeaderOrder(block []byte) []string {
+	lines := bytes.Split(block, []byte("\r\n"))
+	if len(lines) < 2 {
+		return nil
+	}
+
+	var names []string
+	for _, line := range lines[1:] {
+		if len(line) == 0 {
+			break
+		}
+		if line[0] == ' ' || line[0] == '\t' {
+			// Obsolete line folding continues the previous field
+			continue
+		}
+		colon := bytes.IndexByte(line, ':')
+		if colon <= 0 {
+			return nil
+		}
+		names = append(names, textproto.CanonicalMIMEHeaderKey(string(line[:colon])))
+	}
+	return names
+}
+
+// tapListener wraps accepted connections in a tapConn
+type tapListener struct {
+	net.Listener
+}
+
+func (l tapListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &tapConn{Conn: conn}, nil
+}
+
+// TapListener records the raw request headers of connections accepted by l.
+// The server must call ArmHeaderTap from its ConnState hook and expose the
+// connection with HeaderTapContext from its ConnContext hook.
+func TapListener(l net.Listener) net.Listener {
+	return tapListener{Listener: l}
+}
+
+// ArmHeaderTap starts recording the next request on conn. It is meant to be
+// used as, or called from, http.Server.ConnState.
+func ArmHeaderTap(conn net.Conn, state http.ConnState) {
+	if tap, ok := conn.(*tapConn); ok && (state == http.StateNew || state == http.StateIdle) {
+		tap.arm()
+	}
+}
+
+type tapContextKey struct{}
+
+// HeaderTapContext stores the connection in ctx so that the transport can
+// find the raw header order of the request. It is meant to be used as
+// http.Server.ConnContext.
+func HeaderTapContext(ctx context.Context, conn net.Conn) context.Context {
+	if tap, ok := conn.(*tapConn); ok {
+		return context.WithValue(ctx, tapContextKey{}, tap)
+	}
+	return ctx
+}
+
+// requestHeaderOrder returns the wire order of the inbound request headers
+func requestHeaderOrder(req *http.Request) []string {
+	if req.ProtoMajor != 1 {
+		return nil
+	}
+	if tap, ok := req.Context().Value(tapContextKey{}).(*tapConn); ok {
+		return tap.headerOrder()
+	}
+	return nil
+}
+
+// NewTappedTransport clones base so that the response headers of plain HTTP
+// backends are recorded in wire order
+func NewTappedTransport(base *http.Transport) *http.Transport {
+	transport := base.Clone()
+	dial := transport.DialContext
+	if dial == nil {
+		dial = (&net.Dialer{}).DialContext
+	}
+	transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dial(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		return &tapConn{Conn: conn, armOnWrite: true}, nil
+	}
+	return transport
+}
+
+// headerFields lists every value of header, following order where known.
+// Values not covered by order, such as headers added by the proxy, follow in
+// sorted order.
+func headerFields(header http.Header, order []string) []models.HeaderField {
+	fields := make([]models.HeaderField, 0, len(header))
+	used := make(map[string]int, len(header))
+
+	for _, name := range order {
+		values := header[name]
+		if used[name] < len(values) {
+			fields = append(fields, models.HeaderField{Name: name, Value: values[used[name]]})
+			used[name]++
+		}
+	}
+
+	names := make([]string, 0, len(header))
+	for name := range header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, value := range header[name][used[name]:] {
+			fields = append(fields, models.HeaderField{Name: name, Value: value})
+		}
+	}
+
+	return fields
+}
+
+// firstValues is the single-value view of header kept for the dashboard
+func firstValues(header http.Header) map[string]string {
+	values := make(map[string]string, len(header))
+	for key, v := range header {
+		if len(v) > 0 {
+			values[key] = v[0]
+		}
+	}
+	return values
+}
@@ -0,0 +1,141 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+
+	"drift/internal/jsonpath"
+)
+
+// jsonObject is a decoded JSON object that keeps the order of its members
+type jsonObject []jsonMember
+
+type jsonMember struct {
+	key   string
+	value interface{}
+}
+
+// decodeOrdered decodes a JSON document into jsonObject, []interface{},
+// string, json.Number, bool and nil values
+func decodeOrdered(data []byte) (interface{}, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	value, err := decodeValue(dec)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, errors.New("unexpected data after the document")
+	}
+	return value, nil
+}
+
+func decodeValue(dec *json.Decoder) (interface{}, error) {
+	token, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch token {
+	case json.Delim('{'):
+		object := jsonObject{}
+		for dec.More() {
+			key, err := dec.Token()
+			if err != nil {
+				return nil, err
+			}
+			value, err := decodeValue(dec)
+			if err != nil {
+				return nil, err
+			}
+			object = append(object, jsonMember{key: key.(string), value: value})
+		}
+		_, err := dec.Token()
+		return object, err
+	case json.Delim('['):
+		array := []interface{}{}
+		for dec.More() {
+			value, err := decodeValue(dec)
+			if err != nil {
+				return nil, err
+			}
+			array = append(array, value)
+		}
+		_, err := dec.Token()
+		return array, err
+	}
+	return token, nil
+}
+
+// encodeOrdered encodes a value from decodeOrdered as compact JSON
+func encodeOrdered(value interface{}) []byte {
+	var buf bytes.Buffer
+	writeOrdered(&buf, value)
+	return buf.Bytes()
+}
+
+func writeOrdered(buf *bytes.Buffer, value interface{}) {
+	switch v := value.(type) {
+	case jsonObject:
+		buf.WriteByte('{')
+		for i, member := range v {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			writeOrdered(buf, member.key)
+			buf.WriteByte(':')
+			writeOrdered(buf, member.value)
+		}
+		buf.WriteByte('}')
+	case []interface{}:
+		buf.WriteByte('[')
+		for i, item := range v {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			writeOrdered(buf, item)
+		}
+		buf.WriteByte(']')
+	default:
+		enc := json.NewEncoder(buf)
+		enc.SetEscapeHTML(false)
+		enc.Encode(v)
+		buf.Truncate(buf.Len() - 1) // Encode adds a newline
+	}
+}
+
+// redactPath replaces the values selected by path with mask, reporting
+// whether any matched
+func redactPath(value interface{}, path []jsonpath.Segment, mask func(interface{}) interface{}) (interface{}, bool) {
+	if len(path) == 0 {
+		return mask(value), true
+	}
+	segment, rest := path[0], path[1:]
+	changed := false
+
+	descend := func(child interface{}, matches bool) interface{} {
+		var matched bool
+		if matches {
+			child, matched = redactPath(child, rest, mask)
+			changed = changed || matched
+		}
+		if segment.Recursive {
+			child, matched = redactPath(child, path, mask)
+			changed = changed || matched
+		}
+		return child
+	}
+
+	switch node := value.(type) {
+	case jsonObject:
+		for i := range node {
+			node[i].value = descend(node[i].value, segment.MatchesKey(node[i].key))
+		}
+	case []interface{}:
+		for i := range node {
+			node[i] = descend(node[i], segment.MatchesIndex(i))
+		}
+	}
+	return value, changed
+}
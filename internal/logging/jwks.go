@@ -0,0 +1,255 @@
+package logging
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rsa"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+
+	"drift/internal/models"
+)
+
+// KeySet holds the keys of a JSON Web Key Set used to verify captured JWTs
+type KeySet struct {
+	keys []jwk
+}
+
+// jwk is a key of a JWKS. Only the members needed for verification are read.
+type jwk struct {
+	Type      string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Algorithm string `json:"alg"`
+	Use       string `json:"use"`
+	Curve     string `json:"crv"`
+	N         string `json:"n"`
+	E         string `json:"e"`
+	X         string `json:"x"`
+	Y         string `json:"y"`
+	K         string `json:"k"`
+
+	public interface{}
+}
+
+// LoadKeySet reads a JWKS file. An empty path returns a nil set, which leaves
+// signatures unverified.
+func LoadKeySet(path string) (*KeySet, error) {
+	if path == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading JWKS: %w", err)
+	}
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("parsing JWKS %s: %w", path, err)
+	}
+
+	ks := &KeySet{}
+	for i, key := range set.Keys {
+		if key.Use != "" && key.Use != "sig" {
+			continue
+		}
+		if err := key.parse(); err != nil {
+			return nil, fmt.Errorf("JWKS %s: key %d: %w", path, i, err)
+		}
+		ks.keys = append(ks.keys, key)
+	}
+	return ks, nil
+}
+
+// parse builds the public key, or the secret of a symmetric key
+func (k *jwk) parse() error {
+	decode := func(name, value string) ([]byte, error) {
+		b, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(value, "="))
+		if err != nil || len(b) == 0 {
+			return nil, fmt.Errorf("invalid or missing %q", name)
+		}
+		return b, nil
+	}
+
+	switch k.Type {
+	case "RSA":
+		n, err := decode("n", k.N)
+		if err != nil {
+			return err
+		}
+		e, err := decode("e", k.E)
+		if err != nil {
+			return err
+		}
+		exponent := new(big.Int).SetBytes(e)
+		if !exponent.IsInt64() || exponent.Int64() > 1<<31-1 {
+			return errors.New("RSA exponent is too large")
+		}
+		k.public = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exponent.Int64())}
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Curve {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return fmt.Errorf("unsupported curve %q", k.Curve)
+		}
+		x, err := decode("x", k.X)
+		if err != nil {
+			return err
+		}
+		y, err := decode("y", k.Y)
+		if err != nil {
+			return err
+		}
+		k.public = &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+	case "OKP":
+		if k.Curve != "Ed25519" {
+			return fmt.Errorf("unsupported curve %q", k.Curve)
+		}
+		x, err := decode("x", k.X)
+		if err != nil {
+			return err
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return errors.New("invalid Ed25519 key size")
+		}
+		k.public = ed25519.PublicKey(x)
+	case "oct":
+		secret, err := decode("k", k.K)
+		if err != nil {
+			return err
+		}
+		k.public = secret
+	default:
+		return fmt.Errorf("unsupported key type %q", k.Type)
+	}
+	return nil
+}
+
+// hashes maps the size suffix of JWS algorithms to their hash
+var hashes = map[string]crypto.Hash{
+	"256": crypto.SHA256,
+	"384": crypto.SHA384,
+	"512": crypto.SHA512,
+}
+
+// verify checks the signature of a JWT split in its three parts. It returns
+// unverified when no key of the set can check it.
+func (ks *KeySet) verify(alg, kid string, parts []string) (string, string) {
+	if alg == "" || strings.EqualFold(alg, "none") {
+		return models.SignatureInvalid, "token is not signed"
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return models.SignatureInvalid, "signature is not base64url-encoded"
+	}
+	input := []byte(parts[0] + "." + parts[1])
+
+	tried := false
+	var lastErr error
+	for _, key := range ks.keys {
+		if (kid != "" && key.KeyID != kid) || (key.Algorithm != "" && key.Algorithm != alg) {
+			continue
+		}
+		err := verifySignature(alg, key.public, input, signature)
+		if errors.Is(err, errKeyMismatch) {
+			continue
+		}
+		if err == nil {
+			return models.SignatureValid, ""
+		}
+		tried, lastErr = true, err
+	}
+
+	if !tried {
+		if kid != "" {
+			return models.SignatureUnverified, fmt.Sprintf("no %s key with kid %q in the JWKS", alg, kid)
+		}
+		return models.SignatureUnverified, fmt.Sprintf("no %s key in the JWKS", alg)
+	}
+	return models.SignatureInvalid, lastErr.Error()
+}
+
+// errKeyMismatch reports a key that cannot be used with an algorithm
+var errKeyMismatch = errors.New("key does not match the algorithm")
+
+func verifySignature(alg string, key interface{}, input, signature []byte) error {
+	if alg == "EdDSA" || alg == "Ed25519" {
+		pub, ok := key.(ed25519.PublicKey)
+		if !ok {
+			return errKeyMismatch
+		}
+		if !ed25519.Verify(pub, input, signature) {
+			return errors.New("signature does not match")
+		}
+		return nil
+	}
+
+	if len(alg) != 5 {
+		return fmt.Errorf("unsupported algorithm %q", alg)
+	}
+	hash, ok := hashes[alg[2:]]
+	if !ok {
+		return fmt.Errorf("unsupported algorithm %q", alg)
+	}
+	h := hash.New()
+	h.Write(input)
+	digest := h.Sum(nil)
+
+	var valid bool
+	switch alg[:2] {
+	case "HS":
+		secret, ok := key.([]byte)
+		if !ok {
+			return errKeyMismatch
+		}
+		mac := hmac.New(hash.New, secret)
+		mac.Write(input)
+		valid = hmac.Equal(mac.Sum(nil), signature)
+	case "RS", "PS":
+		pub, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return errKeyMismatch
+		}
+		if alg[0] == 'R' {
+			valid = rsa.VerifyPKCS1v15(pub, hash, digest, signature) == nil
+		} else {
+			valid = rsa.VerifyPSS(pub, hash, digest, signature, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthAuto}) == nil
+		}
+	case "ES":
+		pub, ok := key.(*ecdsa.PublicKey)
+		if !ok {
+			return errKeyMismatch
+		}
+		// JWS ECDSA signatures are r and s concatenated at the curve size
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		if len(signature) != 2*size {
+			return errors.New("signature has the wrong length for the curve")
+		}
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		valid = ecdsa.Verify(pub, digest, r, s)
+	default:
+		return fmt.Errorf("unsupported algorithm %q", alg)
+	}
+
+	if !valid {
+		return errors.New("signature does not match")
+	}
+	return nil
+}
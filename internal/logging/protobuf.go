@@ -0,0 +1,399 @@
+package logging
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"drift/internal/models"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+
+	// Register the well-known types so descriptor sets built without
+	// --include_imports still resolve them
+	_ "google.golang.org/protobuf/types/known/anypb"
+	_ "google.golang.org/protobuf/types/known/durationpb"
+	_ "google.golang.org/protobuf/types/known/emptypb"
+	_ "google.golang.org/protobuf/types/known/fieldmaskpb"
+	_ "google.golang.org/protobuf/types/known/structpb"
+	_ "google.golang.org/protobuf/types/known/timestamppb"
+	_ "google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// protobufTypes are the media types of protobuf messages sent over HTTP
+var protobufTypes = map[string]bool{
+	"application/protobuf":            true,
+	"application/x-protobuf":          true,
+	"application/x-google-protobuf":   true,
+	"application/vnd.google.protobuf": true,
+}
+
+// grpcStatusNames are the names of the gRPC status codes
+var grpcStatusNames = []string{
+	"OK", "CANCELLED", "UNKNOWN", "INVALID_ARGUMENT", "DEADLINE_EXCEEDED",
+	"NOT_FOUND", "ALREADY_EXISTS", "PERMISSION_DENIED", "RESOURCE_EXHAUSTED",
+	"FAILED_PRECONDITION", "ABORTED", "OUT_OF_RANGE", "UNIMPLEMENTED",
+	"INTERNAL", "UNAVAILABLE", "DATA_LOSS", "UNAUTHENTICATED",
+}
+
+// maxRawDepth bounds how deep the raw field dump looks for nested messages
+const maxRawDepth = 8
+
+// Descriptors resolves protobuf message types from FileDescriptorSet files.
+// A nil *Descriptors decodes every message as raw wire-format fields.
+type Descriptors struct {
+	files *protoregistry.Files
+	types *dynamicpb.Types
+}
+
+// LoadDescriptors reads FileDescriptorSet files, as written by
+// protoc --descriptor_set_out or buf build. It returns nil when paths is
+// empty.
+func LoadDescriptors(paths []string) (*Descriptors, error) {
+	if len(paths) == 0 {
+		return nil, nil
+	}
+
+	set := &descriptorpb.FileDescriptorSet{}
+	seen := make(map[string]bool)
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read proto descriptors: %w", err)
+		}
+		var fileSet descriptorpb.FileDescriptorSet
+		if err := proto.Unmarshal(data, &fileSet); err != nil {
+			return nil, fmt.Errorf("%s is not a FileDescriptorSet: %w", path, err)
+		}
+		for _, file := range fileSet.File {
+			if !seen[file.GetName()] {
+				seen[file.GetName()] = true
+				set.File = append(set.File, file)
+			}
+		}
+	}
+
+	// Add well-known imports that were left out of the set
+	for i := 0; i < len(set.File); i++ {
+		for _, dependency := range set.File[i].Dependency {
+			if seen[dependency] {
+				continue
+			}
+			if file, err := protoregistry.GlobalFiles.FindFileByPath(dependency); err == nil {
+				seen[dependency] = true
+				set.File = append(set.File, protodesc.ToFileDescriptorProto(file))
+			}
+		}
+	}
+
+	files, err := protodesc.FileOptions{AllowUnresolvable: true}.NewFiles(set)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proto descriptors: %w", err)
+	}
+	return &Descriptors{files: files, types: dynamicpb.NewTypes(files)}, nil
+}
+
+// message finds a message type by its full name
+func (d *Descriptors) message(name string) protoreflect.MessageDescriptor {
+	if d == nil || name == "" {
+		return nil
+	}
+	desc, err := d.files.FindDescriptorByName(protoreflect.FullName(strings.TrimPrefix(name, ".")))
+	if err != nil {
+		return nil
+	}
+	message, _ := desc.(protoreflect.MessageDescriptor)
+	return message
+}
+
+// method finds the gRPC method called by a /package.Service/Method path
+func (d *Descriptors) method(path string) protoreflect.MethodDescriptor {
+	if d == nil {
+		return nil
+	}
+	service, method, ok := strings.Cut(strings.TrimPrefix(path, "/"), "/")
+	if !ok {
+		return nil
+	}
+	desc, err := d.files.FindDescriptorByName(protoreflect.FullName(service))
+	if err != nil {
+		return nil
+	}
+	serviceDesc, ok := desc.(protoreflect.ServiceDescriptor)
+	if !ok {
+		return nil
+	}
+	return serviceDesc.Methods().ByName(protoreflect.Name(method))
+}
+
+// parser returns the body parser for one direction of a call to path. It
+// decodes gRPC and protobuf bodies and leaves the rest to parseBody.
+func (d *Descriptors) parser(path string, response bool, header http.Header) bodyParser {
+	return func(body []byte, mediaType string, params map[string]string, truncated bool) *models.ParsedBody {
+		switch {
+		case mediaType == "application/grpc" || strings.HasPrefix(mediaType, "application/grpc+"):
+			var desc protoreflect.MessageDescriptor
+			if method := d.method(path); method != nil {
+				desc = method.Input()
+				if response {
+					desc = method.Output()
+				}
+			}
+			return d.parseGRPC(body, desc, header.Get("Grpc-Encoding"), truncated)
+		case protobufTypes[mediaType]:
+			name := params["proto"]
+			if name == "" {
+				name = params["messagetype"]
+			}
+			return d.parseProtobuf(body, d.message(name), truncated)
+		}
+		return parseBody(body, mediaType, params, truncated)
+	}
+}
+
+// parseGRPC decodes the length-prefixed messages of a gRPC stream
+func (d *Descriptors) parseGRPC(body []byte, desc protoreflect.MessageDescriptor, encoding string, truncated bool) *models.ParsedBody {
+	parsed := &models.ParsedBody{Kind: models.ParsedGRPC}
+
+	for len(body) > 0 {
+		if len(body) < 5 {
+			parsed.Error = "incomplete message prefix"
+			break
+		}
+		compressed := body[0]&1 == 1
+		size := binary.BigEndian.Uint32(body[1:5])
+		if uint64(len(body)-5) < uint64(size) {
+			parsed.Error = fmt.Sprintf("incomplete message: %d of %d bytes", len(body)-5, size)
+			break
+		}
+		data := body[5 : 5+size]
+		body = body[5+size:]
+
+		message := models.ProtoMessage{Size: int(size), Compressed: compressed}
+		if compressed {
+			decoded, err := decompressGRPC(data, encoding)
+			if err != nil {
+				message.Error = err.Error()
+				parsed.Messages = append(parsed.Messages, message)
+				continue
+			}
+			data = decoded
+		}
+		d.decodeMessage(&message, data, desc)
+		parsed.Messages = append(parsed.Messages, message)
+	}
+
+	if truncated && parsed.Error != "" {
+		parsed.Error = "body truncated, the last message is incomplete"
+	}
+	return parsed
+}
+
+// decompressGRPC undoes the per-message compression named by grpc-encoding
+func decompressGRPC(data []byte, encoding string) ([]byte, error) {
+	switch strings.ToLower(encoding) {
+	case "gzip":
+		reader, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		defer reader.Close()
+		return readDecoded(reader)
+	case "", "identity":
+		return nil, fmt.Errorf("message is compressed but no grpc-encoding was sent")
+	default:
+		return nil, fmt.Errorf("unsupported grpc-encoding %q", encoding)
+	}
+}
+
+// parseProtobuf decodes a single protobuf message sent over plain HTTP
+func (d *Descriptors) parseProtobuf(body []byte, desc protoreflect.MessageDescriptor, truncated bool) *models.ParsedBody {
+	parsed := &models.ParsedBody{Kind: models.ParsedProtobuf}
+	if truncated {
+		parsed.Error = "body truncated, the message is incomplete"
+		return parsed
+	}
+	message := models.ProtoMessage{Size: len(body)}
+	d.decodeMessage(&message, body, desc)
+	parsed.Messages = append(parsed.Messages, message)
+	return parsed
+}
+
+// decodeMessage decodes data with desc into JSON, falling back to the raw
+// wire-format fields when there is no descriptor or decoding fails
+func (d *Descriptors) decodeMessage(message *models.ProtoMessage, data []byte, desc protoreflect.MessageDescriptor) {
+	if desc != nil {
+		decoded, err := d.toJSON(data, desc)
+		if err == nil {
+			message.Type = string(desc.FullName())
+			message.Message = decoded
+			return
+		}
+		message.Error = fmt.Sprintf("%s: %v", desc.FullName(), err)
+	}
+
+	fields, err := rawFields(data, 0)
+	if err != nil {
+		if message.Error == "" {
+			message.Error = err.Error()
+		}
+		message.Message, _ = json.Marshal(base64.StdEncoding.EncodeToString(data))
+		return
+	}
+	message.Raw = true
+	message.Message, _ = json.Marshal(fields)
+}
+
+func (d *Descriptors) toJSON(data []byte, desc protoreflect.MessageDescriptor) (json.RawMessage, error) {
+	message := dynamicpb.NewMessage(desc)
+	if err := (proto.UnmarshalOptions{Resolver: d.types}).Unmarshal(data, message); err != nil {
+		return nil, err
+	}
+	return protojson.MarshalOptions{Resolver: d.types}.Marshal(message)
+}
+
+// wireField is a field of the raw wire-format dump
+type wireField struct {
+	Field int         `json:"field"`
+	Type  string      `json:"type"`
+	Value interface{} `json:"value"`
+}
+
+// rawFields dumps the wire-format fields of a message without a schema.
+// Length-delimited fields are shown as text when printable, as a nested
+// message when they parse as one, and as base64 otherwise.
+func rawFields(data []byte, depth int) ([]wireField, error) {
+	var fields []wireField
+	for len(data) > 0 {
+		number, wireType, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+		data = data[n:]
+
+		field := wireField{Field: int(number)}
+		switch wireType {
+		case protowire.VarintType:
+			value, n := protowire.ConsumeVarint(data)
+			if n < 0 {
+				return nil, protowire.ParseError(n)
+			}
+			field.Type, field.Value = "varint", value
+			data = data[n:]
+		case protowire.Fixed32Type:
+			value, n := protowire.ConsumeFixed32(data)
+			if n < 0 {
+				return nil, protowire.ParseError(n)
+			}
+			field.Type, field.Value = "fixed32", value
+			data = data[n:]
+		case protowire.Fixed64Type:
+			value, n := protowire.ConsumeFixed64(data)
+			if n < 0 {
+				return nil, protowire.ParseError(n)
+			}
+			field.Type, field.Value = "fixed64", value
+			data = data[n:]
+		case protowire.BytesType:
+			value, n := protowire.ConsumeBytes(data)
+			if n < 0 {
+				return nil, protowire.ParseError(n)
+			}
+			field.Type, field.Value = describeBytes(value, depth)
+			data = data[n:]
+		case protowire.StartGroupType:
+			value, n := protowire.ConsumeGroup(number, data)
+			if n < 0 {
+				return nil, protowire.ParseError(n)
+			}
+			nested, err := rawFields(value, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			field.Type, field.Value = "group", nested
+			data = data[n:]
+		default:
+			return nil, fmt.Errorf("unexpected wire type %d", wireType)
+		}
+		fields = append(fields, field)
+	}
+	return fields, nil
+}
+
+func describeBytes(value []byte, depth int) (string, interface{}) {
+	if isPrintable(value) {
+		return "string", string(value)
+	}
+	if depth < maxRawDepth {
+		if nested, err := rawFields(value, depth+1); err == nil && len(nested) > 0 {
+			return "message", nested
+		}
+	}
+	return "bytes", base64.StdEncoding.EncodeToString(value)
+}
+
+func isPrintable(value []byte) bool {
+	if !utf8.Valid(value) {
+		return false
+	}
+	for _, r := range string(value) {
+		if !unicode.IsPrint(r) && !unicode.IsSpace(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// grpcStatus reads the status of a gRPC call from its trailers, or from
+// the headers of a trailers-only response
+func (d *Descriptors) grpcStatus(header, trailer http.Header) *models.GRPCStatus {
+	source := trailer
+	if source.Get("Grpc-Status") == "" {
+		source = header
+	}
+	value := source.Get("Grpc-Status")
+	if value == "" {
+		return nil
+	}
+
+	code, err := strconv.Atoi(value)
+	if err != nil {
+		return &models.GRPCStatus{Code: -1, Status: value}
+	}
+	status := &models.GRPCStatus{Code: code, Status: "UNKNOWN"}
+	if code >= 0 && code < len(grpcStatusNames) {
+		status.Status = grpcStatusNames[code]
+	}
+	// grpc-message is percent-encoded
+	status.Message = source.Get("Grpc-Message")
+	if message, err := url.PathUnescape(status.Message); err == nil {
+		status.Message = message
+	}
+
+	if details := source.Get("Grpc-Status-Details-Bin"); details != "" {
+		data, err := base64.RawStdEncoding.DecodeString(strings.TrimRight(details, "="))
+		if err == nil {
+			var message models.ProtoMessage
+			d.decodeMessage(&message, data, d.message("google.rpc.Status"))
+			status.Details = message.Message
+		}
+	}
+	return status
+}
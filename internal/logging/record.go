@@ -0,0 +1,197 @@
+package logging
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+
+	"drift/internal/capture"
+	"drift/internal/models"
+)
+
+// bodyRecorder captures a body while it streams through the proxy. Up to
+// limit bytes are kept in memory; past the limit the body is only counted
+// and hashed, and optionally written in full to the body store.
+type bodyRecorder struct {
+	mu     sync.Mutex
+	limit  int64
+	store  *capture.BodyStore
+	head   bytes.Buffer
+	hash   hash.Hash
+	size   int64
+	file   *os.File
+	failed bool
+	done   bool
+}
+
+func newBodyRecorder(limit int64, store *capture.BodyStore) *bodyRecorder {
+	return &bodyRecorder{limit: limit, store: store, hash: sha256.New()}
+}
+
+// Write records p. It never fails so that capture problems cannot break the
+// proxied request.
+func (r *bodyRecorder) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.done {
+		return len(p), nil
+	}
+	r.size += int64(len(p))
+	r.hash.Write(p)
+
+	if r.limit <= 0 || (r.file == nil && int64(r.head.Len()+len(p)) <= r.limit) {
+		r.head.Write(p)
+		return len(p), nil
+	}
+
+	// Over the limit: move the body to disk once, then keep appending
+	if r.file == nil && r.store != nil && !r.failed {
+		file, err := r.store.Create()
+		if err == nil {
+			_, err = file.Write(r.head.Bytes())
+		}
+		r.file = file
+		if err != nil {
+			r.abandonFile()
+		}
+	}
+	if r.file != nil {
+		if _, err := r.file.Write(p); err != nil {
+			r.abandonFile()
+		}
+	}
+
+	if room := r.limit - int64(r.head.Len()); room > 0 {
+		r.head.Write(p[:room])
+	}
+	return len(p), nil
+}
+
+// abandonFile gives up spilling after a disk error; the head is still kept
+func (r *bodyRecorder) abandonFile() {
+	if r.file != nil {
+		r.file.Close()
+		os.Remove(r.file.Name())
+		r.file = nil
+	}
+	r.failed = true
+}
+
+// finish stops recording and returns the raw body. A spilled body is added
+// to the store under key.
+func (r *bodyRecorder) finish(header http.Header, key string) models.RawBody {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.done = true
+
+	// The head is no longer written once done, so it is handed over as is
+	raw := models.RawBody{
+		Head:      r.head.Bytes(),
+		Size:      r.size,
+		Truncated: r.limit > 0 && r.size > r.limit,
+	}
+	if !raw.Truncated {
+		return raw
+	}
+	raw.SHA256 = hex.EncodeToString(r.hash.Sum(nil))
+
+	if r.file != nil {
+		r.file.Close()
+		r.store.Add(key, capture.StoredBody{
+			Path:            r.file.Name(),
+			Size:            r.size,
+			ContentType:     header.Get("Content-Type"),
+			ContentEncoding: header.Get("Content-Encoding"),
+		})
+		r.file = nil
+		raw.Spilled = true
+	}
+	return raw
+}
+
+// decodeBody converts a raw body into its captured form. The files of a
+// multipart body are added to the store under key.
+func decodeBody(raw models.RawBody, header http.Header, parse bodyParser, store *capture.BodyStore, key string) (string, models.BodyInfo) {
+	body, info := captureBody(raw.Head, header, raw.Truncated, parse)
+	storeFiles(store, info.Parsed, key)
+	if raw.Truncated {
+		info.Truncated = true
+		info.TotalSize = raw.Size
+		info.TotalSHA256 = raw.SHA256
+		info.Spilled = raw.Spilled
+	}
+	return body, info
+}
+
+// storeFiles saves the files of a multipart body for download and drops
+// their content from the capture
+func storeFiles(store *capture.BodyStore, parsed *models.ParsedBody, key string) {
+	if parsed == nil {
+		return
+	}
+	for i := range parsed.Files {
+		file := &parsed.Files[i]
+		if store != nil && len(file.Data) > 0 {
+			err := store.Save(FileKey(key, i), file.Data, file.ContentType, file.Filename)
+			file.Downloadable = err == nil
+		}
+		file.Data = nil
+	}
+}
+
+// discard stops recording and removes any spilled data
+func (r *bodyRecorder) discard() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.done = true
+	r.abandonFile()
+}
+
+// teeBody copies everything read from a body into a recorder and calls
+// onDone once, when the body reaches EOF or is closed
+type teeBody struct {
+	io.ReadCloser
+	recorder *bodyRecorder
+	once     sync.Once
+	onDone   func()
+}
+
+func (b *teeBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if n > 0 {
+		b.recorder.Write(p[:n])
+	}
+	if err == io.EOF {
+		b.finish()
+	}
+	return n, err
+}
+
+func (b *teeBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.finish()
+	return err
+}
+
+func (b *teeBody) finish() {
+	if b.onDone != nil {
+		b.once.Do(b.onDone)
+	}
+}
+
+// BodyKey names a spilled body in the body store
+func BodyKey(id, direction string) string {
+	return id + "/" + direction
+}
+
+// FileKey names a multipart file part of a body in the body store
+func FileKey(bodyKey string, index int) string {
+	return bodyKey + "/files/" + strconv.Itoa(index)
+}
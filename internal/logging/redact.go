@@ -0,0 +1,303 @@
+package logging
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"drift/internal/config"
+	"drift/internal/jsonpath"
+	"drift/internal/models"
+)
+
+// redacted replaces secrets in captures
+const redacted = "[REDACTED]"
+
+// redactionKey keys the hashes of redacted values. It is random for each
+// run, so equal secrets can be correlated within a session without the
+// hashes being usable to guess them.
+var redactionKey = func() []byte {
+	key := make([]byte, 32)
+	rand.Read(key)
+	return key
+}()
+
+// Redactor hides secrets in captured exchanges before they are published.
+// Header rules match header and trailer names, query rules match query
+// parameters and form fields, and body rules match JSON paths or regular
+// expressions in text bodies and in their parsed structure.
+type Redactor struct {
+	headers  map[string]bool
+	query    map[string]bool
+	paths    [][]jsonpath.Segment
+	patterns []*regexp.Regexp
+	hash     bool
+}
+
+// NewRedactor compiles the redaction rules of settings
+func NewRedactor(settings config.RedactionConfig) (*Redactor, error) {
+	r := &Redactor{
+		headers: make(map[string]bool),
+		query:   make(map[string]bool),
+		hash:    settings.Hash,
+	}
+	for _, name := range settings.Headers {
+		r.headers[strings.ToLower(name)] = true
+	}
+	for _, name := range settings.Query {
+		r.query[strings.ToLower(name)] = true
+	}
+	for _, rule := range settings.Body {
+		if rule.Path != "" {
+			segments, err := jsonpath.Parse(rule.Path)
+			if err != nil {
+				return nil, fmt.Errorf("redaction path %q: %w", rule.Path, err)
+			}
+			r.paths = append(r.paths, segments)
+		}
+		if rule.Pattern != "" {
+			pattern, err := regexp.Compile(rule.Pattern)
+			if err != nil {
+				return nil, fmt.Errorf("redaction pattern %q: %w", rule.Pattern, err)
+			}
+			r.patterns = append(r.patterns, pattern)
+		}
+	}
+	return r, nil
+}
+
+// redactsBodies reports whether bodies may be rewritten, in which case they
+// must not be stored on disk as they were sent
+func (r *Redactor) redactsBodies() bool {
+	return r != nil && (len(r.query) > 0 || len(r.paths) > 0 || len(r.patterns) > 0)
+}
+
+// mask returns the replacement of a secret, tagged with a keyed hash of the
+// value when hashing is enabled
+func (r *Redactor) mask(value string) string {
+	if !r.hash {
+		return redacted
+	}
+	mac := hmac.New(sha256.New, redactionKey)
+	mac.Write([]byte(value))
+	return "[REDACTED:" + hex.EncodeToString(mac.Sum(nil))[:12] + "]"
+}
+
+// redactRequest hides the secrets of a captured request
+func (r *Redactor) redactRequest(log *models.RequestLog) {
+	if r == nil {
+		return
+	}
+	r.redactHeaders(log.Headers, log.HeaderList, log.Trailers)
+	if r.headers["user-agent"] && log.UserAgent != "" {
+		log.UserAgent = r.mask(log.UserAgent)
+	}
+	if len(r.query) > 0 {
+		if u, err := url.Parse(log.URL); err == nil && u.RawQuery != "" {
+			u.RawQuery = r.redactQuery(u.RawQuery)
+			log.URL = u.String()
+		}
+	}
+	r.redactCookies(log.Cookies, "cookie")
+	if log.Auth != nil && log.Auth.JWT != nil && len(log.Auth.JWT.Claims) > 0 {
+		log.Auth.JWT.Claims = r.redactRawJSON(log.Auth.JWT.Claims)
+	}
+	r.redactBody(&log.Body, &log.BodyInfo)
+}
+
+// redactResponse hides the secrets of a captured response
+func (r *Redactor) redactResponse(log *models.ResponseLog) {
+	if r == nil {
+		return
+	}
+	r.redactHeaders(log.Headers, log.HeaderList, log.Trailers)
+	r.redactCookies(log.Cookies, "set-cookie")
+	r.redactBody(&log.Body, &log.BodyInfo)
+}
+
+// redactCookies masks cookie values when the header they came from is
+// redacted. Names and attributes are kept.
+func (r *Redactor) redactCookies(cookies []models.Cookie, header string) {
+	if !r.headers[header] {
+		return
+	}
+	for i, cookie := range cookies {
+		cookies[i].Value = r.mask(cookie.Value)
+	}
+}
+
+func (r *Redactor) redactHeaders(headers map[string]string, lists ...[]models.HeaderField) {
+	for name, value := range headers {
+		if r.headers[strings.ToLower(name)] {
+			headers[name] = r.mask(value)
+		}
+	}
+	for _, list := range lists {
+		for i, field := range list {
+			if r.headers[strings.ToLower(field.Name)] {
+				list[i].Value = r.mask(field.Value)
+			}
+		}
+	}
+}
+
+// redactQuery masks the values of matching parameters in a query string,
+// keeping the order and encoding of the others
+func (r *Redactor) redactQuery(query string) string {
+	pairs := strings.Split(query, "&")
+	for i, pair := range pairs {
+		key, value, _ := strings.Cut(pair, "=")
+		name, err := url.QueryUnescape(key)
+		if err != nil {
+			name = key
+		}
+		if !r.formField(name) {
+			continue
+		}
+		if unescaped, err := url.QueryUnescape(value); err == nil {
+			value = unescaped
+		}
+		pairs[i] = key + "=" + url.QueryEscape(r.mask(value))
+	}
+	return strings.Join(pairs, "&")
+}
+
+// formField reports whether a query parameter or form field is a secret:
+// either a query rule names it or a body rule selects it at the top level
+func (r *Redactor) formField(name string) bool {
+	if r.query[strings.ToLower(name)] {
+		return true
+	}
+	for _, path := range r.paths {
+		if len(path) == 1 && path[0].MatchesKey(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// redactBody hides the secrets of a text body and of its parsed structure.
+// JSON paths only apply to bodies that parse, so the head of a truncated
+// JSON body is only covered by patterns.
+func (r *Redactor) redactBody(body *string, info *models.BodyInfo) {
+	if parsed := info.Parsed; parsed != nil {
+		r.redactParsed(body, parsed, info.Encoding == models.BodyText)
+	}
+	if info.Encoding != models.BodyText || *body == "" {
+		return
+	}
+
+	if info.Parsed == nil || info.Parsed.Kind != models.ParsedNDJSON {
+		if text, ok := r.redactJSON(*body); ok {
+			*body = text
+		}
+	}
+	*body = r.redactPatterns(*body)
+}
+
+func (r *Redactor) redactParsed(body *string, parsed *models.ParsedBody, text bool) {
+	switch parsed.Kind {
+	case models.ParsedForm:
+		if text {
+			*body = r.redactQuery(*body)
+		}
+	case models.ParsedMultipart:
+		for i, field := range parsed.Fields {
+			if !r.formField(field.Name) {
+				continue
+			}
+			// The value of a part sits between its headers and the next
+			// boundary
+			if text && field.Value != "" {
+				*body = strings.ReplaceAll(*body, "\r\n\r\n"+field.Value+"\r\n--", "\r\n\r\n"+r.mask(field.Value)+"\r\n--")
+			}
+			parsed.Fields[i].Value = r.mask(field.Value)
+		}
+	case models.ParsedNDJSON:
+		if text {
+			lines := strings.Split(*body, "\n")
+			for i, line := range lines {
+				if redactedLine, ok := r.redactJSON(line); ok {
+					lines[i] = redactedLine
+				}
+			}
+			*body = strings.Join(lines, "\n")
+		}
+		for i, record := range parsed.Records {
+			parsed.Records[i] = r.redactRawJSON(record)
+		}
+	case models.ParsedGRPC, models.ParsedProtobuf:
+		for i, message := range parsed.Messages {
+			if len(message.Message) > 0 {
+				parsed.Messages[i].Message = r.redactRawJSON(message.Message)
+			}
+		}
+	case models.ParsedXML:
+		parsed.XML = r.redactPatterns(parsed.XML)
+	}
+
+	for i, field := range parsed.Fields {
+		if parsed.Kind == models.ParsedForm && r.formField(field.Name) {
+			parsed.Fields[i].Value = r.mask(field.Value)
+			continue
+		}
+		parsed.Fields[i].Value = r.redactPatterns(field.Value)
+	}
+}
+
+// redactJSON applies the JSON path rules to a JSON document, reporting
+// false when it is not JSON or nothing matched
+func (r *Redactor) redactJSON(text string) (string, bool) {
+	trimmed := strings.TrimSpace(text)
+	if len(r.paths) == 0 || trimmed == "" || (trimmed[0] != '{' && trimmed[0] != '[') {
+		return text, false
+	}
+	document, err := decodeOrdered([]byte(trimmed))
+	if err != nil {
+		return text, false
+	}
+
+	changed := false
+	for _, path := range r.paths {
+		var matched bool
+		document, matched = redactPath(document, path, r.maskJSON)
+		changed = changed || matched
+	}
+	if !changed {
+		return text, false
+	}
+	return string(encodeOrdered(document)), true
+}
+
+// redactRawJSON applies every body rule to an embedded JSON value, keeping
+// it valid when a pattern cuts through its syntax
+func (r *Redactor) redactRawJSON(raw json.RawMessage) json.RawMessage {
+	text, _ := r.redactJSON(string(raw))
+	text = r.redactPatterns(text)
+	if !json.Valid([]byte(text)) {
+		quoted, _ := json.Marshal(text)
+		return quoted
+	}
+	return json.RawMessage(text)
+}
+
+func (r *Redactor) redactPatterns(text string) string {
+	for _, pattern := range r.patterns {
+		text = pattern.ReplaceAllStringFunc(text, r.mask)
+	}
+	return text
+}
+
+// maskJSON masks a JSON value, hashing the encoding of non-string values
+func (r *Redactor) maskJSON(value interface{}) interface{} {
+	if s, ok := value.(string); ok {
+		return r.mask(s)
+	}
+	return r.mask(string(encodeOrdered(value)))
+}
@@ -0,0 +1,237 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/url"
+	"strings"
+	"unicode/utf8"
+
+	"drift/internal/models"
+)
+
+// ndjsonTypes are the media types holding one JSON document per line
+var ndjsonTypes = map[string]bool{
+	"application/x-ndjson":      true,
+	"application/ndjson":        true,
+	"application/jsonl":         true,
+	"application/x-jsonlines":   true,
+	"application/json-seq":      true,
+	"application/stream+json":   true,
+	"application/x-json-stream": true,
+}
+
+// parseBody extracts the structure of form, multipart, XML and NDJSON
+// bodies. It returns nil for other media types. A truncated body is parsed
+// as far as it goes.
+func parseBody(body []byte, mediaType string, params map[string]string, truncated bool) *models.ParsedBody {
+	switch {
+	case mediaType == "application/x-www-form-urlencoded":
+		return parseForm(body, truncated)
+	case strings.HasPrefix(mediaType, "multipart/"):
+		return parseMultipart(body, params["boundary"], truncated)
+	case mediaType == "application/xml" || mediaType == "text/xml" || strings.HasSuffix(mediaType, "+xml"):
+		return parseXML(body, truncated)
+	case ndjsonTypes[mediaType]:
+		return parseNDJSON(body, truncated)
+	}
+	return nil
+}
+
+func parseForm(body []byte, truncated bool) *models.ParsedBody {
+	parsed := &models.ParsedBody{Kind: models.ParsedForm}
+
+	pairs := strings.Split(string(body), "&")
+	if truncated && len(pairs) > 0 {
+		pairs = pairs[:len(pairs)-1]
+	}
+	for _, pair := range pairs {
+		if pair == "" {
+			continue
+		}
+		name, value, _ := strings.Cut(pair, "=")
+		field := models.FormField{Name: name, Value: value}
+		var err error
+		if field.Name, err = url.QueryUnescape(name); err == nil {
+			field.Value, err = url.QueryUnescape(value)
+		}
+		if err != nil && parsed.Error == "" {
+			parsed.Error = fmt.Sprintf("field %q: %v", name, err)
+		}
+		parsed.Fields = append(parsed.Fields, field)
+	}
+	return parsed
+}
+
+func parseMultipart(body []byte, boundary string, truncated bool) *models.ParsedBody {
+	parsed := &models.ParsedBody{Kind: models.ParsedMultipart}
+	if boundary == "" {
+		parsed.Error = "missing boundary parameter"
+		return parsed
+	}
+
+	reader := multipart.NewReader(bytes.NewReader(body), boundary)
+	for {
+		part, err := reader.NextRawPart()
+		if errors.Is(err, io.EOF) {
+			return parsed
+		}
+		if err != nil {
+			parsed.Error = partError(err, truncated)
+			return parsed
+		}
+
+		data, err := io.ReadAll(part)
+		if err != nil {
+			// The last part of a truncated body is incomplete
+			parsed.Error = partError(err, truncated)
+			if !truncated {
+				return parsed
+			}
+		}
+
+		name := part.FormName()
+		filename := part.FileName()
+		if filename == "" && utf8.Valid(data) {
+			parsed.Fields = append(parsed.Fields, models.FormField{Name: name, Value: string(data)})
+		} else {
+			parsed.Files = append(parsed.Files, models.FilePart{
+				Field:       name,
+				Filename:    filename,
+				ContentType: part.Header.Get("Content-Type"),
+				Size:        int64(len(data)),
+				Data:        data,
+			})
+		}
+		if err != nil {
+			return parsed
+		}
+	}
+}
+
+// partError describes where multipart parsing stopped
+func partError(err error, truncated bool) string {
+	if truncated {
+		return "body truncated, the last part is incomplete"
+	}
+	return err.Error()
+}
+
+func parseXML(body []byte, truncated bool) *models.ParsedBody {
+	parsed := &models.ParsedBody{Kind: models.ParsedXML}
+
+	var out bytes.Buffer
+	decoder := xml.NewDecoder(bytes.NewReader(body))
+	decoder.Strict = false
+	depth := 0
+	// inline is set while an element only has text so far, which keeps
+	// <a>text</a> on one line
+	inline := false
+	newline := func() {
+		if out.Len() > 0 {
+			out.WriteByte('\n')
+		}
+		out.WriteString(strings.Repeat("  ", depth))
+	}
+
+	for {
+		// RawToken keeps namespace prefixes as written
+		token, err := decoder.RawToken()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			if truncated {
+				parsed.Error = "body truncated"
+			} else {
+				parsed.Error = err.Error()
+			}
+			break
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			newline()
+			out.WriteString("<" + qualifiedName(t.Name))
+			for _, attr := range t.Attr {
+				out.WriteString(" " + qualifiedName(attr.Name) + `="`)
+				xml.EscapeText(&out, []byte(attr.Value))
+				out.WriteString(`"`)
+			}
+			out.WriteString(">")
+			depth++
+			inline = true
+		case xml.EndElement:
+			if depth > 0 {
+				depth--
+			}
+			if !inline {
+				newline()
+			}
+			out.WriteString("</" + qualifiedName(t.Name) + ">")
+			inline = false
+		case xml.CharData:
+			text := bytes.TrimSpace(t)
+			if len(text) == 0 {
+				continue
+			}
+			if !inline {
+				newline()
+			}
+			xml.EscapeText(&out, text)
+		case xml.Comment:
+			newline()
+			out.WriteString("<!--" + string(t) + "-->")
+			inline = false
+		case xml.ProcInst:
+			newline()
+			out.WriteString("<?" + t.Target + " " + string(t.Inst) + "?>")
+			inline = false
+		case xml.Directive:
+			newline()
+			out.WriteString("<!" + string(t) + ">")
+			inline = false
+		}
+	}
+
+	parsed.XML = out.String()
+	return parsed
+}
+
+func qualifiedName(name xml.Name) string {
+	if name.Space == "" {
+		return name.Local
+	}
+	return name.Space + ":" + name.Local
+}
+
+func parseNDJSON(body []byte, truncated bool) *models.ParsedBody {
+	parsed := &models.ParsedBody{Kind: models.ParsedNDJSON}
+
+	lines := bytes.Split(body, []byte("\n"))
+	// The last line of a truncated body is usually cut off
+	if truncated {
+		lines = lines[:len(lines)-1]
+	}
+
+	for i, line := range lines {
+		// json-seq records start with a record separator
+		line = bytes.TrimSpace(bytes.TrimPrefix(line, []byte{0x1e}))
+		if len(line) == 0 {
+			continue
+		}
+		if !json.Valid(line) {
+			if parsed.Error == "" {
+				parsed.Error = fmt.Sprintf("line %d is not valid JSON", i+1)
+			}
+			continue
+		}
+		parsed.Records = append(parsed.Records, json.RawMessage(line))
+	}
+	return parsed
+}
@@ -1,105 +1,175 @@
 package logging
 
 import (
-	"bytes"
-	"compress/gzip"
-	"io"
+	"net"
 	"net/http"
+	"net/http/httptrace"
+	"net/netip"
 	"time"
 
+	"drift/internal/capture"
+	"drift/internal/config"
 	"drift/internal/models"
 
-	"github.com/andybalholm/brotli"
 	"github.com/google/uuid"
 )
 
-// Transport is an http.RoundTripper that logs requests and responses
+// Publisher receives captured request-response cycles without blocking
+type Publisher interface {
+	Publish(exchange models.Exchange)
+}
+
+// Transport is an http.RoundTripper that logs requests and responses.
+// Bodies stream through untouched; at most MaxRequestBody and
+// MaxResponseBody bytes of them are captured, and larger bodies are spilled
+// to Bodies when it is set. Only a raw snapshot of the exchange is taken on
+// the request path; Decode runs in the capture pipeline. Protobuf and gRPC
+// messages are decoded with Protos, or dumped as raw fields when it is nil.
+// Bearer JWTs are verified against Keys when it is set. The client address
+// and location are found by Clients. Secrets are hidden by Redact.
 type Transport struct {
 	http.RoundTripper
-	LogChan chan models.APILog
+	Capture         Publisher
+	Bodies          *capture.BodyStore
+	Protos          *Descriptors
+	Keys            *KeySet
+	Clients         *ClientLocator
+	Redact          *Redactor
+	MaxRequestBody  int64
+	MaxResponseBody int64
 }
 
 // RoundTrip implements the http.RoundTripper interface
 func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	sent := time.Now()
 	reqLog := models.RequestLog{
-		ID:        uuid.New().String(),
-		Method:    req.Method,
-		URL:       req.URL.String(),
-		Headers:   make(map[string]string),
-		Timestamp: time.Now().Format(time.RFC3339),
-		ClientIP:  req.RemoteAddr,
-		UserAgent: req.Header.Get("User-Agent"),
+		ID:         uuid.New().String(),
+		Method:     req.Method,
+		URL:        req.URL.String(),
+		Proto:      req.Proto,
+		Headers:    firstValues(req.Header),
+		HeaderList: headerFields(req.Header, requestHeaderOrder(req)),
+		Timestamp:  sent.Format(time.RFC3339),
+		RemoteAddr: req.RemoteAddr,
+		UserAgent:  req.Header.Get("User-Agent"),
 	}
 
-	for key, values := range req.Header {
-		if len(values) > 0 {
-			reqLog.Headers[key] = values[0]
-		}
+	if client := t.Clients.ClientAddress(req); client.IsValid() {
+		reqLog.ClientIP = client.String()
 	}
 
-	var reqBody []byte
-	if req.Body != nil {
-		reqBody, _ = io.ReadAll(req.Body)
-		reqLog.Body = string(reqBody)
-		req.Body = io.NopCloser(bytes.NewReader(reqBody))
+	reqRecorder := newBodyRecorder(t.MaxRequestBody, t.Bodies)
+	if req.Body != nil && req.Body != http.NoBody {
+		req.Body = &teeBody{ReadCloser: req.Body, recorder: reqRecorder}
 	}
 
+	// Remember the backend connection to recover the response header order
+	var backendConn net.Conn
+	trace := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) { backendConn = info.Conn },
+	}
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+
 	resp, err := t.RoundTripper.RoundTrip(req)
 	if err != nil {
+		reqRecorder.discard()
 		return nil, err
 	}
 
+	var responseOrder []string
+	if tap, ok := backendConn.(*tapConn); ok && resp.ProtoMajor == 1 {
+		responseOrder = tap.headerOrder()
+	}
+
 	respLog := models.ResponseLog{
 		ID:         reqLog.ID,
 		StatusCode: resp.StatusCode,
-		Headers:    make(map[string]string),
+		Proto:      resp.Proto,
+		Headers:    firstValues(resp.Header),
+		HeaderList: headerFields(resp.Header, responseOrder),
+		Denied:     resp.Header.Get(models.DeniedHeader),
 		Timestamp:  time.Now().Format(time.RFC3339),
 	}
 
-	for key, values := range resp.Header {
-		if len(values) > 0 {
-			respLog.Headers[key] = values[0]
-		}
+	// The exchange is published once the response body has been streamed
+	// to the client, when both bodies and their trailers are known
+	respRecorder := newBodyRecorder(t.MaxResponseBody, t.Bodies)
+	publish := func() {
+		t.Capture.Publish(models.Exchange{
+			Request:         reqLog,
+			Response:        respLog,
+			Sent:            sent,
+			Path:            req.URL.Path,
+			RequestHeader:   req.Header.Clone(),
+			RequestTrailer:  req.Trailer.Clone(),
+			RequestBody:     reqRecorder.finish(req.Header, BodyKey(reqLog.ID, "request")),
+			ResponseHeader:  resp.Header.Clone(),
+			ResponseTrailer: resp.Trailer.Clone(),
+			ResponseBody:    respRecorder.finish(resp.Header, BodyKey(respLog.ID, "response")),
+			Decoder:         t,
+		})
 	}
 
-	var respBody []byte
-	if resp.Body != nil {
-		respBody, _ = io.ReadAll(resp.Body)
-		contentEncoding := resp.Header.Get("Content-Encoding")
-		switch contentEncoding {
-		case "gzip":
-			reader, err := gzip.NewReader(bytes.NewReader(respBody))
-			if err == nil {
-				decompressedBody, _ := io.ReadAll(reader)
-				respLog.Body = string(decompressedBody)
-				reader.Close()
-			} else {
-				respLog.Body = string(respBody)
-			}
-		case "br":
-			reader := brotli.NewReader(bytes.NewReader(respBody))
-			decompressedBody, err := io.ReadAll(reader)
-			if err == nil {
-				respLog.Body = string(decompressedBody)
-			} else {
-				respLog.Body = string(respBody)
-			}
-		default:
-			respLog.Body = string(respBody)
-		}
-		resp.Body = io.NopCloser(bytes.NewReader(respBody))
+	// Upgraded connections hand their body over to the proxy as a raw
+	// stream, so it must not be wrapped
+	if resp.StatusCode == http.StatusSwitchingProtocols || resp.Body == nil {
+		publish()
+		return resp, nil
 	}
-
-	apiLog := models.APILog{Request: reqLog, Response: respLog}
-	t.LogChan <- apiLog
+	resp.Body = &teeBody{ReadCloser: resp.Body, recorder: respRecorder, onDone: publish}
 
 	return resp, nil
 }
 
-// NewTransport creates a new logging transport
-func NewTransport(rt http.RoundTripper, logChan chan models.APILog) *Transport {
-	return &Transport{
-		RoundTripper: rt,
-		LogChan:      logChan,
+// Decode turns a raw exchange into its log: it decodes the bodies, auth and
+// cookies, locates the client and hides secrets
+func (t *Transport) Decode(e models.Exchange) models.APILog {
+	reqLog, respLog := e.Request, e.Response
+
+	reqLog.Body, reqLog.BodyInfo = decodeBody(e.RequestBody, e.RequestHeader,
+		t.Protos.parser(e.Path, false, e.RequestHeader), t.Bodies, BodyKey(reqLog.ID, "request"))
+	if len(e.RequestTrailer) > 0 {
+		reqLog.Trailers = headerFields(e.RequestTrailer, nil)
+	}
+	respLog.Body, respLog.BodyInfo = decodeBody(e.ResponseBody, e.ResponseHeader,
+		t.Protos.parser(e.Path, true, e.ResponseHeader), t.Bodies, BodyKey(respLog.ID, "response"))
+	if len(e.ResponseTrailer) > 0 {
+		respLog.Trailers = headerFields(e.ResponseTrailer, nil)
+	}
+
+	if client, err := netip.ParseAddr(reqLog.ClientIP); err == nil {
+		reqLog.Geo = t.Clients.geo(client)
+	}
+	reqLog.Auth = decodeAuth(e.RequestHeader, e.Sent, t.Keys)
+	reqLog.Cookies = requestCookies(e.RequestHeader)
+	respLog.Cookies = responseCookies(e.ResponseHeader)
+
+	t.Redact.redactRequest(&reqLog)
+	t.Redact.redactResponse(&respLog)
+
+	respLog.GRPCStatus = t.Protos.grpcStatus(e.ResponseHeader, e.ResponseTrailer)
+	if reqLog.GraphQL = graphqlOperations(reqLog); reqLog.GraphQL != nil {
+		respLog.GraphQLErrors = graphqlErrors(respLog)
+	}
+	return models.APILog{Request: reqLog, Response: respLog}
+}
+
+// NewTransport creates a new logging transport. Bodies are captured up to
+// the limits in settings, 0 meaning no limit. Bodies are not spilled when
+// redaction may rewrite them, since the stored copy would be unredacted.
+func NewTransport(rt http.RoundTripper, capture Publisher, bodies *capture.BodyStore, protos *Descriptors, keys *KeySet, clients *ClientLocator, redact *Redactor, settings config.CaptureConfig) *Transport {
+	t := &Transport{
+		RoundTripper:    rt,
+		Capture:         capture,
+		Protos:          protos,
+		Keys:            keys,
+		Clients:         clients,
+		Redact:          redact,
+		MaxRequestBody:  int64(settings.MaxRequestBody),
+		MaxResponseBody: int64(settings.MaxResponseBody),
+	}
+	if settings.SpillBodies && !redact.redactsBodies() {
+		t.Bodies = bodies
 	}
+	return t
 }
@@ -1,79 +1,410 @@
 package models
 
 import (
-	"net/http/httputil"
+	"context"
+	"encoding/json"
+	"net/http"
 	"net/url"
 	"sync"
+	"sync/atomic"
+	"time"
 
-	"github.com/gorilla/websocket"
+	"drift/internal/capture"
+	"drift/internal/config"
+	"drift/internal/tunnel"
 )
 
-// RequestLog represents a logged HTTP request
+// HeaderField is a single header line
+type HeaderField struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// Body encodings used in captures
+const (
+	BodyText   = "text"
+	BodyBase64 = "base64"
+)
+
+// BodyInfo describes how a captured body was decoded. Text bodies are stored
+// as UTF-8; anything else is stored as base64 with its SHA-256. Bodies over
+// the capture limit are truncated, and the full body may be spilled to disk.
+type BodyInfo struct {
+	Encoding        string `json:"body_encoding,omitempty"`
+	Size            int64  `json:"body_size"`
+	ContentType     string `json:"body_content_type,omitempty"`
+	Charset         string `json:"body_charset,omitempty"`
+	SHA256          string `json:"body_sha256,omitempty"`
+	ContentEncoding string `json:"body_content_encoding,omitempty"`
+	EncodedSize     int64  `json:"body_encoded_size,omitempty"`
+	DecodeError     string `json:"body_decode_error,omitempty"`
+	// Truncated bodies keep only their head; TotalSize and TotalSHA256
+	// describe the whole body as it was sent
+	Truncated   bool   `json:"body_truncated,omitempty"`
+	TotalSize   int64  `json:"body_total_size,omitempty"`
+	TotalSHA256 string `json:"body_total_sha256,omitempty"`
+	Spilled     bool   `json:"body_spilled,omitempty"`
+	// Parsed is the structure of form, multipart, XML and NDJSON bodies
+	Parsed *ParsedBody `json:"body_parsed,omitempty"`
+}
+
+// Parsed body kinds
+const (
+	ParsedForm      = "form"
+	ParsedMultipart = "multipart"
+	ParsedXML       = "xml"
+	ParsedNDJSON    = "ndjson"
+	ParsedGRPC      = "grpc"
+	ParsedProtobuf  = "protobuf"
+)
+
+// ParsedBody is the structure extracted from a body. Which fields are set
+// depends on Kind. Error reports where parsing stopped, for malformed or
+// truncated bodies.
+type ParsedBody struct {
+	Kind    string            `json:"kind"`
+	Fields  []FormField       `json:"fields,omitempty"`
+	Files   []FilePart        `json:"files,omitempty"`
+	XML     string            `json:"xml,omitempty"`
+	Records []json.RawMessage `json:"records,omitempty"`
+	// Messages are the protobuf messages of a protobuf body or gRPC stream
+	Messages []ProtoMessage `json:"messages,omitempty"`
+	Error    string         `json:"error,omitempty"`
+}
+
+// ProtoMessage is a decoded protobuf message. With a descriptor, Message is
+// its JSON form and Type its full name; otherwise Raw is set and Message
+// lists the wire-format fields.
+type ProtoMessage struct {
+	Type       string          `json:"type,omitempty"`
+	Size       int             `json:"size"`
+	Compressed bool            `json:"compressed,omitempty"`
+	Raw        bool            `json:"raw,omitempty"`
+	Message    json.RawMessage `json:"message,omitempty"`
+	Error      string          `json:"error,omitempty"`
+}
+
+// GRPCStatus is the status a gRPC call ended with, taken from the
+// grpc-status, grpc-message and grpc-status-details-bin trailers
+type GRPCStatus struct {
+	Code    int             `json:"code"`
+	Status  string          `json:"status"`
+	Message string          `json:"message,omitempty"`
+	Details json.RawMessage `json:"details,omitempty"`
+}
+
+// FormField is a form value, in the order it was sent
+type FormField struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// FilePart is a file uploaded in a multipart body. Its content is only
+// kept in Data until it has been stored for download.
+type FilePart struct {
+	Field        string `json:"field"`
+	Filename     string `json:"filename"`
+	ContentType  string `json:"content_type,omitempty"`
+	Size         int64  `json:"size"`
+	Downloadable bool   `json:"downloadable,omitempty"`
+	Data         []byte `json:"-"`
+}
+
+// RequestLog represents a logged HTTP request. Headers keeps the first value
+// of each header for convenience; HeaderList keeps every value in wire order.
 type RequestLog struct {
-	ID        string            `json:"id"`
-	Method    string            `json:"method"`
-	URL       string            `json:"url"`
-	Headers   map[string]string `json:"headers"`
-	Body      string            `json:"body"`
-	Timestamp string            `json:"timestamp"`
-	ClientIP  string            `json:"client_ip"`
-	UserAgent string            `json:"user_agent"`
+	ID         string            `json:"id"`
+	Method     string            `json:"method"`
+	URL        string            `json:"url"`
+	Proto      string            `json:"proto"`
+	Headers    map[string]string `json:"headers"`
+	HeaderList []HeaderField     `json:"header_list"`
+	Trailers   []HeaderField     `json:"trailers,omitempty"`
+	Body       string            `json:"body"`
+	BodyInfo
+	// GraphQL lists the operations of a GraphQL request, several for batches
+	GraphQL []GraphQLOperation `json:"graphql,omitempty"`
+	// Auth is the decoded Authorization header
+	Auth      *AuthInfo `json:"auth,omitempty"`
+	Cookies   []Cookie  `json:"cookies,omitempty"`
+	Timestamp string    `json:"timestamp"`
+	// ClientIP is the address of the client, read from the forwarding
+	// headers of trusted proxies; RemoteAddr is the peer that connected
+	ClientIP   string   `json:"client_ip"`
+	RemoteAddr string   `json:"remote_addr"`
+	Geo        *GeoInfo `json:"geo,omitempty"`
+	UserAgent  string   `json:"user_agent"`
+}
+
+// GeoInfo is where a client address is located, from GeoIP databases
+type GeoInfo struct {
+	Country      string `json:"country,omitempty"`
+	CountryName  string `json:"country_name,omitempty"`
+	Region       string `json:"region,omitempty"`
+	City         string `json:"city,omitempty"`
+	ASN          uint   `json:"asn,omitempty"`
+	Organization string `json:"organization,omitempty"`
+}
+
+// Authorization schemes recognized in captures
+const (
+	AuthBearer = "bearer"
+	AuthBasic  = "basic"
+)
+
+// AuthInfo describes the credentials of a request. The password of basic
+// auth is always masked and the raw token is never kept.
+type AuthInfo struct {
+	Scheme   string   `json:"scheme"`
+	JWT      *JWTInfo `json:"jwt,omitempty"`
+	Username string   `json:"username,omitempty"`
+	Password string   `json:"password,omitempty"`
+}
+
+// JWT signature states
+const (
+	SignatureValid      = "valid"
+	SignatureInvalid    = "invalid"
+	SignatureUnverified = "unverified"
+)
+
+// JWTInfo is a decoded JSON Web Token. Times are RFC 3339, and Expired and
+// NotYetValid are evaluated when the request was captured.
+type JWTInfo struct {
+	Header      json.RawMessage `json:"header,omitempty"`
+	Claims      json.RawMessage `json:"claims,omitempty"`
+	Algorithm   string          `json:"algorithm,omitempty"`
+	KeyID       string          `json:"key_id,omitempty"`
+	Issuer      string          `json:"issuer,omitempty"`
+	Subject     string          `json:"subject,omitempty"`
+	Audience    []string        `json:"audience,omitempty"`
+	ExpiresAt   string          `json:"expires_at,omitempty"`
+	NotBefore   string          `json:"not_before,omitempty"`
+	IssuedAt    string          `json:"issued_at,omitempty"`
+	Expired     bool            `json:"expired,omitempty"`
+	NotYetValid bool            `json:"not_yet_valid,omitempty"`
+	// Signature is valid, invalid or unverified when no JWKS is configured
+	// or no key matches
+	Signature      string `json:"signature"`
+	SignatureError string `json:"signature_error,omitempty"`
+	Error          string `json:"error,omitempty"`
+}
+
+// Cookie is a cookie sent by the client, or set by a response along with
+// its attributes
+type Cookie struct {
+	Name        string `json:"name"`
+	Value       string `json:"value"`
+	Path        string `json:"path,omitempty"`
+	Domain      string `json:"domain,omitempty"`
+	Expires     string `json:"expires,omitempty"`
+	MaxAge      int    `json:"max_age,omitempty"`
+	Secure      bool   `json:"secure,omitempty"`
+	HttpOnly    bool   `json:"http_only,omitempty"`
+	SameSite    string `json:"same_site,omitempty"`
+	Partitioned bool   `json:"partitioned,omitempty"`
+	Error       string `json:"error,omitempty"`
+}
+
+// GraphQLOperation describes one operation of a GraphQL request
+type GraphQLOperation struct {
+	// Type is query, mutation or subscription; it is empty for persisted
+	// queries sent without their text
+	Type               string          `json:"type,omitempty"`
+	Name               string          `json:"name,omitempty"`
+	Variables          json.RawMessage `json:"variables,omitempty"`
+	PersistedQueryHash string          `json:"persisted_query_hash,omitempty"`
 }
 
-// ResponseLog represents a logged HTTP response
+// GraphQLError is a top-level error of a GraphQL response
+type GraphQLError struct {
+	Message string        `json:"message"`
+	Path    []interface{} `json:"path,omitempty"`
+}
+
+// ResponseLog represents a logged HTTP response. Headers keeps the first
+// value of each header for convenience; HeaderList keeps every value in wire
+// order.
 type ResponseLog struct {
 	ID         string            `json:"id"`
 	StatusCode int               `json:"status_code"`
+	Proto      string            `json:"proto"`
 	Headers    map[string]string `json:"headers"`
+	HeaderList []HeaderField     `json:"header_list"`
+	Trailers   []HeaderField     `json:"trailers,omitempty"`
 	Body       string            `json:"body"`
-	Timestamp  string            `json:"timestamp"`
+	BodyInfo
+	GRPCStatus *GRPCStatus `json:"grpc_status,omitempty"`
+	// GraphQLErrors are the top-level errors of a GraphQL response, which
+	// are usually sent with a 200 status
+	GraphQLErrors []GraphQLError `json:"graphql_errors,omitempty"`
+	// Cookies are the cookies set with Set-Cookie
+	Cookies []Cookie `json:"cookies,omitempty"`
+	// Denied is why the access control of the public port rejected the
+	// request, which then never reached the backend
+	Denied    string `json:"denied,omitempty"`
+	Timestamp string `json:"timestamp"`
 }
 
+// DeniedHeader tags the responses of requests rejected by access control
+// with the reason
+const DeniedHeader = "X-Drift-Denied"
+
+// Reasons for rejecting a request on the public port
+const (
+	DeniedAddress     = "address_not_allowed"
+	DeniedRateLimit   = "rate_limited"
+	DeniedCredentials = "bad_credentials"
+	DeniedSecret      = "bad_secret"
+)
+
 // APILog represents a complete API request-response cycle
 type APILog struct {
 	Request  RequestLog  `json:"request"`
 	Response ResponseLog `json:"response"`
 }
 
+// Exchange is the raw snapshot of a request-response cycle taken on the
+// request path. Request and Response only hold what is known without
+// decoding; bodies, auth, cookies and redaction are filled in by Decoder
+// in the capture pipeline.
+type Exchange struct {
+	Request         RequestLog  `json:"request"`
+	Response        ResponseLog `json:"response"`
+	Sent            time.Time   `json:"sent"`
+	Path            string      `json:"path"`
+	RequestHeader   http.Header `json:"request_header"`
+	RequestTrailer  http.Header `json:"request_trailer,omitempty"`
+	RequestBody     RawBody     `json:"request_body"`
+	ResponseHeader  http.Header `json:"response_header"`
+	ResponseTrailer http.Header `json:"response_trailer,omitempty"`
+	ResponseBody    RawBody     `json:"response_body"`
+	// Decoder is lost when the exchange is spilled to disk; the current
+	// decoder of the proxy is used then
+	Decoder ExchangeDecoder `json:"-"`
+}
+
+// RawBody is a body as recorded while it streamed: its head up to the
+// capture limit, and the size and SHA-256 of the whole body
+type RawBody struct {
+	Head      []byte `json:"head,omitempty"`
+	Size      int64  `json:"size"`
+	Truncated bool   `json:"truncated,omitempty"`
+	SHA256    string `json:"sha256,omitempty"`
+	Spilled   bool   `json:"spilled,omitempty"`
+}
+
+// ExchangeDecoder turns a raw exchange into the log shown to clients
+type ExchangeDecoder interface {
+	Decode(exchange Exchange) APILog
+}
+
 // ProxyConfig holds the configuration for the reverse proxy
 type ProxyConfig struct {
 	BackendURL  *url.URL
-	Proxy       *httputil.ReverseProxy
+	Proxy       http.Handler
 	BackendPort string
+	TunnelMode  string
 	ZrokToken   string
 	ZrokURL     string
 	ZrokPort    string
+	ZrokOwner   string
 }
 
+// Owners of the zrok token of a proxy, which decide whether it is released
+// on exit
+const (
+	// TokenUser is supplied by the user and never released
+	TokenUser = "user"
+	// TokenReserved is reserved by this run and not kept, so always released
+	TokenReserved = "reserved"
+	// TokenPersisted is reserved by DRIFT and kept in the state file for the
+	// next run, released only with tunnel.release_on_exit
+	TokenPersisted = "persisted"
+)
+
 // AppState holds the global application state
 type AppState struct {
-	Clients      map[*websocket.Conn]bool
-	ClientsMu    sync.Mutex
-	LogChan      chan APILog
+	Hub          *capture.Hub
+	Capture      *capture.Pipeline[Exchange]
+	Bodies       *capture.BodyStore
 	Config       *ProxyConfig
 	ConfigMu     sync.Mutex
-	ZrokURL      string
-	ZrokMu       sync.Mutex
+	ProxyCancel  context.CancelFunc
+	TunnelCancel context.CancelFunc
+	Settings     atomic.Pointer[config.Config]
+	Tunnel       *tunnel.Manager
+	Reservations *tunnel.Store
 	ServerStatus string
 	StatusMu     sync.Mutex
-	ZrokCmd      *sync.Mutex
-	ZrokProcess  interface{}
+
+	// ReconfigureMu serializes the changes of the proxy configuration:
+	// configuring, unconfiguring and applying reloaded settings
+	ReconfigureMu sync.Mutex
+	// Decoder is the ExchangeDecoder of the current proxy, used for
+	// exchanges that lost theirs when spilled
+	Decoder atomic.Value
 }
 
 // NewAppState creates a new application state
-func NewAppState() *AppState {
-	return &AppState{
-		Clients:      make(map[*websocket.Conn]bool),
-		LogChan:      make(chan APILog, 100),
-		ZrokURL:      "Public URL not available",
-		ZrokCmd:      &sync.Mutex{},
+func NewAppState(settings *config.Config) *AppState {
+	hub := capture.NewHub(settings.Capture.ClientQueueSize)
+	state := &AppState{
+		Hub:          hub,
+		Bodies:       capture.NewBodyStore(int64(settings.Capture.MaxSpill)),
+		Tunnel:       tunnel.NewManager(time.Duration(settings.Tunnel.ProbeInterval)),
+		Reservations: tunnel.NewStore(tunnel.StatePath()),
 		ServerStatus: "Not configured",
 	}
+	state.Settings.Store(settings)
+
+	// Exchanges are decoded by the pipeline worker, off the request path
+	broadcast := capture.BroadcastSink[APILog](hub)
+	state.Capture = capture.NewPipeline(
+		settings.Capture.QueueSize,
+		settings.Capture.Overflow,
+		capture.SinkFunc[Exchange](func(exchange Exchange) {
+			decoder := exchange.Decoder
+			if decoder == nil {
+				decoder, _ = state.Decoder.Load().(ExchangeDecoder)
+			}
+			if decoder != nil {
+				broadcast.Write(decoder.Decode(exchange))
+			}
+		}),
+	)
+	return state
 }
 
 // StatusResponse represents the response for the status endpoint
 type StatusResponse struct {
-	ServerStatus string `json:"serverStatus"`
-	LocalhostURL string `json:"localhostURL"`
-	ZrokURL      string `json:"zrokURL"`
+	ServerStatus string           `json:"serverStatus"`
+	LocalhostURL string           `json:"localhostURL"`
+	PublicURL    string           `json:"publicURL,omitempty"`
+	Tunnel       tunnel.Status    `json:"tunnel"`
+	Capture      capture.Stats    `json:"capture"`
+	WebSocket    capture.HubStats `json:"websocket"`
+	Retention    RetentionStatus  `json:"retention"`
+}
+
+// RetentionStatus tells the inspector how much captured history to keep,
+// 0 meaning no limit
+type RetentionStatus struct {
+	MaxEntries int   `json:"max_entries"`
+	MaxAgeMs   int64 `json:"max_age_ms"`
+}
+
+// Event is a server notification sent to WebSocket clients alongside logs
+type Event struct {
+	Type string      `json:"type"`
+	Data interface{} `json:"data"`
+}
+
+// ReloadResult reports the outcome of a configuration reload
+type ReloadResult struct {
+	Trigger         string   `json:"trigger"`
+	Applied         []string `json:"applied"`
+	RestartRequired []string `json:"restart_required"`
+	Error           string   `json:"error,omitempty"`
+	Timestamp       string   `json:"timestamp"`
 }
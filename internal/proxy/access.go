@@ -0,0 +1,190 @@
+package proxy
+
+import (
+	"context"
+	"crypto/subtle"
+	"math"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"sync"
+	"time"
+
+	"drift/internal/config"
+	"drift/internal/logging"
+	"drift/internal/models"
+)
+
+type inspectorKey struct{}
+
+// InspectorContext marks a request as sent from the inspector, such as a
+// replay, which access control lets through
+func InspectorContext(ctx context.Context) context.Context {
+	return context.WithValue(ctx, inspectorKey{}, true)
+}
+
+func fromInspector(ctx context.Context) bool {
+	inspector, _ := ctx.Value(inspectorKey{}).(bool)
+	return inspector
+}
+
+// AccessTransport is an http.RoundTripper that rejects requests from
+// clients not allowed to use the public port. Rejected requests never reach
+// the backend and are answered with a response tagged with the reason, so
+// they are still captured.
+type AccessTransport struct {
+	http.RoundTripper
+	clients   *logging.ClientLocator
+	allow     []netip.Prefix
+	basicAuth map[string]string
+	secret    config.SecretHeader
+	limiter   *rateLimiter
+}
+
+// NewAccessTransport creates a transport applying the access settings.
+// Client addresses are read behind the trusted proxies of clients.
+func NewAccessTransport(rt http.RoundTripper, settings config.AccessConfig, clients *logging.ClientLocator) (*AccessTransport, error) {
+	t := &AccessTransport{
+		RoundTripper: rt,
+		clients:      clients,
+		secret:       settings.Secret,
+	}
+	for _, entry := range settings.Allow {
+		prefix, err := config.ParseAddressRange(entry)
+		if err != nil {
+			return nil, err
+		}
+		t.allow = append(t.allow, prefix)
+	}
+	if len(settings.BasicAuth) > 0 {
+		t.basicAuth = make(map[string]string)
+		for _, user := range settings.BasicAuth {
+			t.basicAuth[user.Username] = user.Password
+		}
+	}
+	if limit := settings.RateLimit; limit.Requests > 0 {
+		t.limiter = newRateLimiter(limit)
+	}
+	return t, nil
+}
+
+// RoundTrip implements the http.RoundTripper interface
+func (t *AccessTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if fromInspector(req.Context()) {
+		return t.RoundTripper.RoundTrip(req)
+	}
+
+	client := t.clients.ClientAddress(req)
+	if len(t.allow) > 0 && !t.allowed(client) {
+		return denied(req, http.StatusForbidden, models.DeniedAddress, nil), nil
+	}
+	if t.limiter != nil {
+		if ok, wait := t.limiter.allow(client, time.Now()); !ok {
+			retry := strconv.Itoa(int(math.Ceil(wait.Seconds())))
+			return denied(req, http.StatusTooManyRequests, models.DeniedRateLimit, map[string]string{"Retry-After": retry}), nil
+		}
+	}
+
+	// The credentials of the share are checked here and not forwarded, the
+	// request being cloned so that the capture keeps them
+	var stripped []string
+	if t.basicAuth != nil {
+		username, password, ok := req.BasicAuth()
+		expected, known := t.basicAuth[username]
+		if !ok || !known || subtle.ConstantTimeCompare([]byte(password), []byte(expected)) != 1 {
+			return denied(req, http.StatusUnauthorized, models.DeniedCredentials, map[string]string{"WWW-Authenticate": `Basic realm="DRIFT", charset="UTF-8"`}), nil
+		}
+		stripped = append(stripped, "Authorization")
+	}
+	if t.secret.Header != "" {
+		if subtle.ConstantTimeCompare([]byte(req.Header.Get(t.secret.Header)), []byte(t.secret.Value)) != 1 {
+			return denied(req, http.StatusForbidden, models.DeniedSecret, nil), nil
+		}
+		stripped = append(stripped, t.secret.Header)
+	}
+	if len(stripped) > 0 {
+		req = req.Clone(req.Context())
+		for _, name := range stripped {
+			req.Header.Del(name)
+		}
+	}
+	return t.RoundTripper.RoundTrip(req)
+}
+
+func (t *AccessTransport) allowed(client netip.Addr) bool {
+	if !client.IsValid() {
+		return false
+	}
+	for _, prefix := range t.allow {
+		if prefix.Contains(client) {
+			return true
+		}
+	}
+	return false
+}
+
+// denied answers a rejected request. Its body is left unread, so a probe
+// cannot make DRIFT receive a large upload.
+func denied(req *http.Request, status int, reason string, headers map[string]string) *http.Response {
+	resp := newResponse(req, status, headers, http.StatusText(status))
+	resp.Header.Set(models.DeniedHeader, reason)
+	return resp
+}
+
+// rateLimiter is a token bucket for each client address
+type rateLimiter struct {
+	rate  float64 // tokens per second
+	burst float64
+
+	mu      sync.Mutex
+	buckets map[netip.Addr]*bucket
+	swept   time.Time
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(limit config.RateLimit) *rateLimiter {
+	burst := limit.Burst
+	if burst == 0 {
+		burst = limit.Requests
+	}
+	return &rateLimiter{
+		rate:    float64(limit.Requests) / time.Duration(limit.Per).Seconds(),
+		burst:   float64(burst),
+		buckets: make(map[netip.Addr]*bucket),
+	}
+}
+
+// allow takes a token for a client, or reports how long until one is
+// available
+func (l *rateLimiter) allow(client netip.Addr, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// Forget clients whose bucket has refilled, so that the map does not
+	// grow with every address ever seen
+	if now.Sub(l.swept) > time.Minute {
+		for addr, b := range l.buckets {
+			if b.tokens+now.Sub(b.last).Seconds()*l.rate >= l.burst {
+				delete(l.buckets, addr)
+			}
+		}
+		l.swept = now
+	}
+
+	b, ok := l.buckets[client]
+	if !ok {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[client] = b
+	}
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+}
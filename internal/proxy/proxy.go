@@ -1,70 +1,229 @@
 package proxy
 
 import (
+	"context"
 	"fmt"
 	"net"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
+	"sort"
+	"strings"
 	"time"
 
+	"drift/internal/config"
 	"drift/internal/logging"
 	"drift/internal/models"
 )
 
-// Setup configures a new reverse proxy
-func Setup(port string, state *models.AppState) (*models.ProxyConfig, error) {
-	backendURL, err := url.Parse(fmt.Sprintf("http://localhost:%s", port))
+// backendTransport is shared by every proxy so that connections to the
+// backends are pooled across reconfigurations
+var backendTransport = logging.NewTappedTransport(http.DefaultTransport.(*http.Transport))
+
+// grpcTransport carries gRPC calls, which need HTTP/2: over TLS as usual
+// and with prior knowledge (h2c) to plain-text backends
+var grpcTransport = newGRPCTransport()
+
+func newGRPCTransport() *http.Transport {
+	transport := backendTransport.Clone()
+	transport.Protocols = new(http.Protocols)
+	transport.Protocols.SetHTTP2(true)
+	transport.Protocols.SetUnencryptedHTTP2(true)
+	return transport
+}
+
+// protocolTransport sends gRPC calls through grpcTransport and everything
+// else through backendTransport
+type protocolTransport struct{}
+
+func (protocolTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	contentType := req.Header.Get("Content-Type")
+	if strings.HasPrefix(contentType, "application/grpc") && !strings.HasPrefix(contentType, "application/grpc-web") {
+		return grpcTransport.RoundTrip(req)
+	}
+	return backendTransport.RoundTrip(req)
+}
+
+// Setup configures a new reverse proxy for the given backend, which may be a
+// port, a host:port pair or a URL. Routes and rules are taken from settings.
+func Setup(backend string, settings *config.Config, state *models.AppState) (*models.ProxyConfig, error) {
+	backendURL, err := config.ParseBackend(backend)
 	if err != nil {
-		return nil, fmt.Errorf("invalid port: %w", err)
+		return nil, err
 	}
 
 	// Check if backend port is open rather than pinging a specific endpoint
-	conn, err := net.DialTimeout("tcp", fmt.Sprintf("localhost:%s", port), 2*time.Second)
+	conn, err := net.DialTimeout("tcp", hostPort(backendURL), 2*time.Second)
 	if err != nil {
-		return nil, fmt.Errorf("backend server on port %s is not reachable: %w", port, err)
+		return nil, fmt.Errorf("backend server %s is not reachable: %w", backendURL.Host, err)
 	}
 	conn.Close()
 
-	// Create reverse proxy
-	proxy := httputil.NewSingleHostReverseProxy(backendURL)
-	proxy.Transport = logging.NewTransport(http.DefaultTransport, state.LogChan)
+	handler, err := NewHandler(backendURL, settings, state)
+	if err != nil {
+		return nil, err
+	}
 
 	config := &models.ProxyConfig{
 		BackendURL:  backendURL,
-		Proxy:       proxy,
-		BackendPort: port,
+		Proxy:       handler,
+		BackendPort: backendURL.Port(),
 	}
 
 	return config, nil
 }
 
-// MonitorBackend continuously checks if the backend is available
-func MonitorBackend(backendURL *url.URL, state *models.AppState) {
+// NewHandler builds the proxy handler for a backend, including the routes,
+// mock and fault rules, the proto descriptors, the JWKS, the trusted proxies
+// and GeoIP databases and the redaction rules from settings
+func NewHandler(backendURL *url.URL, settings *config.Config, state *models.AppState) (http.Handler, error) {
+	protos, err := logging.LoadDescriptors(settings.ProtoDescriptors)
+	if err != nil {
+		return nil, err
+	}
+	keys, err := logging.LoadKeySet(settings.JWKS)
+	if err != nil {
+		return nil, err
+	}
+	clients, err := logging.NewClientLocator(settings.TrustedProxies, settings.GeoIP)
+	if err != nil {
+		return nil, err
+	}
+	redaction := settings.Redaction
+	if header := settings.Access.Secret.Header; header != "" {
+		// The shared secret of the share is never shown in captures
+		redaction.Headers = append(append([]string(nil), redaction.Headers...), header)
+	}
+	redactor, err := logging.NewRedactor(redaction)
+	if err != nil {
+		return nil, err
+	}
+	access, err := NewAccessTransport(NewRulesTransport(protocolTransport{}, settings.Mocks, settings.Faults), settings.Access, clients)
+	if err != nil {
+		return nil, err
+	}
+
+	transport := logging.NewTransport(
+		access,
+		state.Capture,
+		state.Bodies,
+		protos,
+		keys,
+		clients,
+		redactor,
+		settings.Capture,
+	)
+	// Exchanges spilled by the pipeline are decoded by the latest proxy
+	state.Decoder.Store(transport)
+
+	newProxy := func(target *url.URL) *httputil.ReverseProxy {
+		proxy := httputil.NewSingleHostReverseProxy(target)
+		proxy.Transport = transport
+		return proxy
+	}
+
+	if len(settings.Routes) == 0 {
+		return newProxy(backendURL), nil
+	}
+
+	r := &router{fallback: newProxy(backendURL)}
+	for _, route := range settings.Routes {
+		target, err := config.ParseBackend(route.Backend)
+		if err != nil {
+			return nil, fmt.Errorf("route %s: %w", route.Path, err)
+		}
+		r.routes = append(r.routes, routeHandler{
+			prefix:      strings.TrimSuffix(route.Path, "/"),
+			stripPrefix: route.StripPrefix,
+			proxy:       newProxy(target),
+		})
+	}
+
+	// Longest prefix wins
+	sort.SliceStable(r.routes, func(i, j int) bool {
+		return len(r.routes[i].prefix) > len(r.routes[j].prefix)
+	})
+
+	return r, nil
+}
+
+type routeHandler struct {
+	prefix      string
+	stripPrefix bool
+	proxy       *httputil.ReverseProxy
+}
+
+// router dispatches requests to the backend of the longest matching route
+type router struct {
+	routes   []routeHandler
+	fallback *httputil.ReverseProxy
+}
+
+func (r *router) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	path := req.URL.Path
+	for _, route := range r.routes {
+		if path != route.prefix && !strings.HasPrefix(path, route.prefix+"/") {
+			continue
+		}
+
+		if route.stripPrefix {
+			req = req.Clone(req.Context())
+			req.URL.Path = "/" + strings.TrimPrefix(strings.TrimPrefix(path, route.prefix), "/")
+			req.URL.RawPath = ""
+		}
+		route.proxy.ServeHTTP(w, req)
+		return
+	}
+
+	r.fallback.ServeHTTP(w, req)
+}
+
+// hostPort returns the host:port to dial for a backend URL
+func hostPort(backendURL *url.URL) string {
+	port := backendURL.Port()
+	if port == "" {
+		if backendURL.Scheme == "https" {
+			port = "443"
+		} else {
+			port = "80"
+		}
+	}
+	return net.JoinHostPort(backendURL.Hostname(), port)
+}
+
+// MonitorBackend continuously checks if the backend is available until ctx
+// is cancelled
+func MonitorBackend(ctx context.Context, state *models.AppState) {
 	go func() {
 		for {
-			// Extract port from backendURL
-			port := backendURL.Port()
-			if port == "" {
-				if backendURL.Scheme == "https" {
-					port = "443"
-				} else {
-					port = "80"
-				}
+			state.ConfigMu.Lock()
+			if ctx.Err() != nil || state.Config == nil {
+				state.ConfigMu.Unlock()
+				return
 			}
+			backendURL := state.Config.BackendURL
+			state.ConfigMu.Unlock()
 
 			// Check if port is open
-			conn, err := net.DialTimeout("tcp", fmt.Sprintf("localhost:%s", port), 2*time.Second)
+			conn, err := net.DialTimeout("tcp", hostPort(backendURL), 2*time.Second)
 			state.StatusMu.Lock()
-			if err != nil {
-				state.ServerStatus = "Inactive"
-			} else {
-				conn.Close()
-				state.ServerStatus = "Active"
+			if ctx.Err() == nil {
+				if err != nil {
+					state.ServerStatus = "Inactive"
+				} else {
+					state.ServerStatus = "Active"
+				}
 			}
 			state.StatusMu.Unlock()
+			if err == nil {
+				conn.Close()
+			}
 
-			time.Sleep(5 * time.Second)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(5 * time.Second):
+			}
 		}
 	}()
 }
@@ -0,0 +1,109 @@
+package proxy
+
+import (
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"drift/internal/config"
+)
+
+// RulesTransport is an http.RoundTripper that answers mocked requests and
+// injects faults before forwarding the rest to the backend
+type RulesTransport struct {
+	http.RoundTripper
+	Mocks  []config.MockRule
+	Faults []config.FaultRule
+}
+
+// RoundTrip implements the http.RoundTripper interface
+func (t *RulesTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	for _, fault := range t.Faults {
+		if !matches(fault.Method, fault.Path, req) {
+			continue
+		}
+		if fault.Probability > 0 && rand.Float64() >= fault.Probability {
+			continue
+		}
+
+		if fault.Delay > 0 {
+			select {
+			case <-time.After(time.Duration(fault.Delay)):
+			case <-req.Context().Done():
+				return nil, req.Context().Err()
+			}
+		}
+		if fault.Status != 0 {
+			resp := syntheticResponse(req, fault.Status, nil, http.StatusText(fault.Status))
+			resp.Header.Set("X-Drift-Fault", "true")
+			return resp, nil
+		}
+		break
+	}
+
+	for _, mock := range t.Mocks {
+		if !matches(mock.Method, mock.Path, req) {
+			continue
+		}
+
+		status := mock.Status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		resp := syntheticResponse(req, status, mock.Headers, mock.Body)
+		resp.Header.Set("X-Drift-Mock", "true")
+		return resp, nil
+	}
+
+	return t.RoundTripper.RoundTrip(req)
+}
+
+// matches reports whether a rule's method and path pattern match the request
+func matches(method, pattern string, req *http.Request) bool {
+	if method != "" && method != req.Method {
+		return false
+	}
+	ok, err := path.Match(pattern, req.URL.Path)
+	return err == nil && ok
+}
+
+// syntheticResponse builds a response that never reached the backend
+func syntheticResponse(req *http.Request, status int, headers map[string]string, body string) *http.Response {
+	// Consume the request body as a backend would, so that it is captured
+	if req.Body != nil {
+		io.Copy(io.Discard, req.Body)
+	}
+	return newResponse(req, status, headers, body)
+}
+
+// newResponse builds a response without reading the request body
+func newResponse(req *http.Request, status int, headers map[string]string, body string) *http.Response {
+	resp := &http.Response{
+		Status:        fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		StatusCode:    status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        make(http.Header),
+		Body:          io.NopCloser(strings.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}
+	for key, value := range headers {
+		resp.Header.Set(key, value)
+	}
+	return resp
+}
+
+// NewRulesTransport creates a transport applying the given mock and fault rules
+func NewRulesTransport(rt http.RoundTripper, mocks []config.MockRule, faults []config.FaultRule) *RulesTransport {
+	return &RulesTransport{
+		RoundTripper: rt,
+		Mocks:        mocks,
+		Faults:       faults,
+	}
+}
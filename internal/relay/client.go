@@ -0,0 +1,102 @@
+package relay
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gorilla/websocket"
+	"github.com/hashicorp/yamux"
+)
+
+// Session is a tunnel registered with a relay
+type Session struct {
+	// URL is the public URL of the tunnel
+	URL string
+	mux *yamux.Session
+}
+
+// Dial connects to a relay and registers a tunnel, named by the relay when
+// name is empty. relayURL may use the ws, wss, http or https scheme.
+func Dial(ctx context.Context, relayURL, token, name string) (*Session, error) {
+	u, err := url.Parse(relayURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid relay URL: %w", err)
+	}
+	switch u.Scheme {
+	case "http":
+		u.Scheme = "ws"
+	case "https":
+		u.Scheme = "wss"
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/") + ConnectPath
+	if name != "" {
+		u.RawQuery = url.Values{"name": {name}}.Encode()
+	}
+
+	header := http.Header{"Authorization": {"Bearer " + token}}
+	ws, resp, err := websocket.DefaultDialer.DialContext(ctx, u.String(), header)
+	if err != nil {
+		if resp != nil {
+			body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+			return nil, fmt.Errorf("relay refused the tunnel: %s: %s", resp.Status, strings.TrimSpace(string(body)))
+		}
+		return nil, fmt.Errorf("connecting to relay: %w", err)
+	}
+
+	mux, err := yamux.Server(newWSConn(ws), sessionConfig())
+	if err != nil {
+		ws.Close()
+		return nil, err
+	}
+	return &Session{URL: resp.Header.Get(URLHeader), mux: mux}, nil
+}
+
+// Serve connects every stream opened by the relay to target, the address of
+// the public port, until the session ends
+func (s *Session) Serve(target string) error {
+	for {
+		stream, err := s.mux.Accept()
+		if err != nil {
+			if s.mux.IsClosed() {
+				return fmt.Errorf("relay connection closed")
+			}
+			return err
+		}
+		go pipe(stream, target)
+	}
+}
+
+// Close ends the session
+func (s *Session) Close() error {
+	return s.mux.Close()
+}
+
+// pipe copies a stream to and from a new connection to target
+func pipe(stream net.Conn, target string) {
+	defer stream.Close()
+	local, err := net.Dial("tcp", target)
+	if err != nil {
+		return
+	}
+	defer local.Close()
+
+	done := make(chan struct{}, 2)
+	go func() {
+		io.Copy(local, stream)
+		if tcp, ok := local.(*net.TCPConn); ok {
+			tcp.CloseWrite()
+		}
+		done <- struct{}{}
+	}()
+	go func() {
+		io.Copy(stream, local)
+		done <- struct{}{}
+	}()
+	<-done
+	<-done
+}
@@ -0,0 +1,156 @@
+package relay
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// startTunnel starts a relay and a backend, and connects the backend to the
+// relay as the tunnel named demo
+func startTunnel(t *testing.T) (*httptest.Server, *Session) {
+	t.Helper()
+	var upgrader websocket.Upgrader
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/ws" {
+			ws, err := upgrader.Upgrade(w, r, nil)
+			if err != nil {
+				return
+			}
+			defer ws.Close()
+			for {
+				kind, msg, err := ws.ReadMessage()
+				if err != nil {
+					return
+				}
+				ws.WriteMessage(kind, append([]byte("echo: "), msg...))
+			}
+		}
+		fmt.Fprintf(w, "%s %s?%s prefix=%s", r.Method, r.URL.Path, r.URL.RawQuery, r.Header.Get("X-Forwarded-Prefix"))
+	}))
+	t.Cleanup(backend.Close)
+
+	relay := httptest.NewServer(NewServer("secret", ""))
+	t.Cleanup(relay.Close)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	session, err := Dial(ctx, relay.URL, "secret", "demo")
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	t.Cleanup(func() { session.Close() })
+	go session.Serve(backend.Listener.Addr().String())
+
+	// The relay registers the tunnel right after the upgrade
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		resp, err := http.Get(session.URL)
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusBadGateway {
+				break
+			}
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("tunnel was not registered: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return relay, session
+}
+
+func get(t *testing.T, url string) string {
+	t.Helper()
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET %s: %s: %s", url, resp.Status, body)
+	}
+	return string(body)
+}
+
+func TestDialRejectsInvalidToken(t *testing.T) {
+	relay := httptest.NewServer(NewServer("secret", ""))
+	defer relay.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	session, err := Dial(ctx, relay.URL, "wrong", "demo")
+	if err == nil {
+		session.Close()
+		t.Fatal("Dial succeeded with an invalid token")
+	}
+	if !strings.Contains(err.Error(), "401") {
+		t.Errorf("Dial error = %v, want 401 Unauthorized", err)
+	}
+
+	resp, err := http.Get(relay.URL + "/t/demo/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadGateway {
+		t.Errorf("rejected tunnel answered %s, want 502", resp.Status)
+	}
+}
+
+func TestTunnelHTTP(t *testing.T) {
+	relay, session := startTunnel(t)
+	if want := relay.URL + "/t/demo"; session.URL != want {
+		t.Errorf("session URL = %q, want %q", session.URL, want)
+	}
+
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"/t/demo/api/users?id=1", "GET /api/users?id=1 prefix=/t/demo"},
+		{"/t/demo/", "GET /? prefix=/t/demo"},
+		{"/t/demo", "GET /? prefix=/t/demo"},
+	}
+	for _, tt := range tests {
+		if got := get(t, relay.URL+tt.path); got != tt.want {
+			t.Errorf("GET %s = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestTunnelWebSocket(t *testing.T) {
+	_, session := startTunnel(t)
+
+	wsURL := "ws" + strings.TrimPrefix(session.URL, "http") + "/ws"
+	ws, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	if err != nil {
+		t.Fatalf("dialing %s: %v", wsURL, err)
+	}
+	defer ws.Close()
+
+	for _, msg := range []string{"hello", "again"} {
+		if err := ws.WriteMessage(websocket.TextMessage, []byte(msg)); err != nil {
+			t.Fatal(err)
+		}
+		ws.SetReadDeadline(time.Now().Add(2 * time.Second))
+		_, reply, err := ws.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := "echo: " + msg; string(reply) != want {
+			t.Errorf("reply = %q, want %q", reply, want)
+		}
+	}
+}
@@ -0,0 +1,206 @@
+package relay
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/hashicorp/yamux"
+)
+
+// tunnelName is a DNS label, so that names can be used as subdomains
+var tunnelName = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]{0,30}[a-z0-9])?$`)
+
+// pathPrefix is where tunnels are reached when they have no subdomain
+const pathPrefix = "/t/"
+
+// Server is the public side of the relay. It accepts tunnels from DRIFT
+// instances that present the token and forwards public HTTP requests,
+// WebSockets included, to them. A tunnel named demo is reached at
+// demo.<domain> when a domain is set, and always at /t/demo/.
+type Server struct {
+	token  string
+	domain string
+
+	upgrader websocket.Upgrader
+	mu       sync.Mutex
+	tunnels  map[string]*httputil.ReverseProxy
+}
+
+// NewServer creates a relay accepting tunnels that present token
+func NewServer(token, domain string) *Server {
+	return &Server{
+		token:   token,
+		domain:  strings.ToLower(strings.TrimPrefix(domain, ".")),
+		tunnels: make(map[string]*httputil.ReverseProxy),
+	}
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == ConnectPath {
+		s.connect(w, r)
+		return
+	}
+
+	name, prefix := s.route(r)
+	if name == "" {
+		http.Error(w, "DRIFT relay: no tunnel here", http.StatusNotFound)
+		return
+	}
+	s.mu.Lock()
+	proxy := s.tunnels[name]
+	s.mu.Unlock()
+	if proxy == nil {
+		http.Error(w, fmt.Sprintf("DRIFT relay: tunnel %q is not connected", name), http.StatusBadGateway)
+		return
+	}
+
+	if prefix != "" {
+		r = r.Clone(r.Context())
+		r.URL.Path = "/" + strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, prefix), "/")
+		r.URL.RawPath = ""
+		r.Header.Set("X-Forwarded-Prefix", prefix)
+	}
+	proxy.ServeHTTP(w, r)
+}
+
+// route finds the tunnel of a request, from its subdomain or its path. The
+// prefix to strip is returned for paths.
+func (s *Server) route(r *http.Request) (name, prefix string) {
+	if s.domain != "" {
+		host := strings.ToLower(r.Host)
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if label, ok := strings.CutSuffix(host, "."+s.domain); ok && tunnelName.MatchString(label) {
+			return label, ""
+		}
+	}
+	if rest, ok := strings.CutPrefix(r.URL.Path, pathPrefix); ok {
+		name, _, _ = strings.Cut(rest, "/")
+		if tunnelName.MatchString(name) {
+			return name, pathPrefix + name
+		}
+	}
+	return "", ""
+}
+
+// connect registers a tunnel and serves it until the client disconnects
+func (s *Server) connect(w http.ResponseWriter, r *http.Request) {
+	scheme, token, _ := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !strings.EqualFold(scheme, "bearer") || subtle.ConstantTimeCompare([]byte(token), []byte(s.token)) != 1 {
+		http.Error(w, "invalid relay token", http.StatusUnauthorized)
+		return
+	}
+
+	name := r.URL.Query().Get("name")
+	if name == "" {
+		name = randomName()
+	} else if !tunnelName.MatchString(name) {
+		http.Error(w, "tunnel names are lowercase letters, digits and dashes, up to 32 characters", http.StatusBadRequest)
+		return
+	}
+
+	// Reserve the name before upgrading, so that a taken name is refused
+	// with a status the client can report
+	s.mu.Lock()
+	if _, taken := s.tunnels[name]; taken {
+		s.mu.Unlock()
+		http.Error(w, fmt.Sprintf("tunnel %q is already connected", name), http.StatusConflict)
+		return
+	}
+	s.tunnels[name] = nil
+	s.mu.Unlock()
+	release := func() {
+		s.mu.Lock()
+		delete(s.tunnels, name)
+		s.mu.Unlock()
+	}
+
+	publicURL := s.publicURL(r, name)
+	ws, err := s.upgrader.Upgrade(w, r, http.Header{URLHeader: {publicURL}})
+	if err != nil {
+		release()
+		return
+	}
+	session, err := yamux.Client(newWSConn(ws), sessionConfig())
+	if err != nil {
+		ws.Close()
+		release()
+		return
+	}
+	defer session.Close()
+	defer release()
+
+	s.mu.Lock()
+	s.tunnels[name] = newTunnelProxy(session)
+	s.mu.Unlock()
+
+	fmt.Printf("🔌 Tunnel %s connected from %s at %s\n", name, r.RemoteAddr, publicURL)
+	<-session.CloseChan()
+	fmt.Printf("🔌 Tunnel %s disconnected\n", name)
+}
+
+// publicURL returns the URL a tunnel is reached at
+func (s *Server) publicURL(r *http.Request, name string) string {
+	scheme := "http"
+	if r.TLS != nil || strings.EqualFold(r.Header.Get("X-Forwarded-Proto"), "https") {
+		scheme = "https"
+	}
+	if s.domain != "" {
+		host := name + "." + s.domain
+		if _, port, err := net.SplitHostPort(r.Host); err == nil {
+			host = net.JoinHostPort(host, port)
+		}
+		return scheme + "://" + host
+	}
+	return scheme + "://" + r.Host + pathPrefix + name
+}
+
+// newTunnelProxy forwards requests through streams of the session. Each
+// stream is a connection to the public port of the DRIFT that owns the
+// tunnel, so upgraded connections pass through unchanged.
+func newTunnelProxy(session *yamux.Session) *httputil.ReverseProxy {
+	transport := &http.Transport{
+		DialContext: func(_ context.Context, _, _ string) (net.Conn, error) {
+			return session.Open()
+		},
+		MaxIdleConnsPerHost: 16,
+		IdleConnTimeout:     90 * time.Second,
+	}
+	return &httputil.ReverseProxy{
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			pr.Out.URL.Scheme = "http"
+			pr.Out.URL.Host = pr.In.Host
+			pr.Out.Host = pr.In.Host
+			pr.SetXForwarded()
+		},
+		Transport: transport,
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			http.Error(w, "DRIFT relay: "+err.Error(), http.StatusBadGateway)
+		},
+	}
+}
+
+func sessionConfig() *yamux.Config {
+	cfg := yamux.DefaultConfig()
+	cfg.LogOutput = io.Discard
+	return cfg
+}
+
+func randomName() string {
+	b := make([]byte, 5)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,80 @@
+package server
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"drift/internal/config"
+	"drift/internal/handlers"
+	"drift/internal/models"
+)
+
+// Loader loads and validates the configuration the same way as at startup
+type Loader func() (*config.Config, error)
+
+// watchConfig reloads the configuration when one of its files is created,
+// changes or is removed, or when the process receives SIGHUP
+func watchConfig(state *models.AppState, load Loader) {
+	triggers := make(chan string, 1)
+	trigger := func(source string) {
+		select {
+		case triggers <- source:
+		default:
+			// A reload is already pending
+		}
+	}
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			trigger("signal")
+		}
+	}()
+
+	if watched := state.Settings.Load().Watched; len(watched) > 0 {
+		config.Watch(watched, 2*time.Second, nil, func() { trigger("file") })
+	}
+
+	go func() {
+		for source := range triggers {
+			reload(state, load, source)
+		}
+	}()
+}
+
+// reload applies a freshly loaded configuration and reports the result
+func reload(state *models.AppState, load Loader, trigger string) {
+	var result models.ReloadResult
+
+	next, err := load()
+	if err != nil {
+		result = models.ReloadResult{
+			Trigger:   trigger,
+			Error:     err.Error(),
+			Timestamp: time.Now().Format(time.RFC3339),
+		}
+	} else {
+		result = handlers.ApplySettings(state, next, trigger)
+	}
+
+	switch {
+	case result.Error != "":
+		fmt.Printf("❌ Configuration reload failed, keeping the running configuration:\n%s\n", result.Error)
+	case len(result.Applied) == 0 && len(result.RestartRequired) == 0:
+		fmt.Println("ℹ️  Configuration reloaded, nothing changed")
+	default:
+		if len(result.Applied) > 0 {
+			fmt.Printf("🔄 Configuration reloaded, applied: %s\n", strings.Join(result.Applied, ", "))
+		}
+		if len(result.RestartRequired) > 0 {
+			fmt.Printf("⚠️  Restart DRIFT to apply: %s\n", strings.Join(result.RestartRequired, ", "))
+		}
+	}
+
+	handlers.BroadcastEvent(state, "config_reload", result)
+}
@@ -1,35 +1,205 @@
 package server
 
 import (
+	"context"
 	"embed"
+	"errors"
 	"fmt"
+	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
+	"drift/internal/auth"
+	"drift/internal/config"
 	"drift/internal/handlers"
+	"drift/internal/logging"
 	"drift/internal/models"
 	"drift/internal/tunnel"
 )
 
-// Start initializes and starts the HTTP server
-func Start(state *models.AppState, staticFiles embed.FS, port string) error {
-	// Set up cleanup handler
-	tunnel.SetupCleanupHandler(state)
+// Start initializes and starts the HTTP server. load is used to reread the
+// configuration when it changes. Start returns once the server has been shut
+// down gracefully on SIGINT or SIGTERM.
+func Start(state *models.AppState, staticFiles embed.FS, cfg *config.Config, load Loader) error {
+	port := cfg.Port
 
-	// Set up HTTP routes
-	http.HandleFunc("/", handlers.HandleHTTPRequest(state, staticFiles))
-	http.HandleFunc("/configure", handlers.ConfigureProxy(state, port))
-	http.HandleFunc("/ws", handlers.HandleWebSocket(state))
-	http.HandleFunc("/status", handlers.GetStatus(state))
+	// Reload the configuration on file changes and SIGHUP
+	watchConfig(state, load)
 
-	// Start broadcasting logs
-	handlers.BroadcastLogs(state)
+	// Push tunnel state changes to the dashboards
+	state.Tunnel.OnChange(func(status tunnel.Status) {
+		handlers.BroadcastEvent(state, "tunnel_state", status)
+	})
+
+	var authn *auth.Authenticator
+	if cfg.Auth.Enabled {
+		var err error
+		if authn, err = auth.New(cfg.Auth); err != nil {
+			return fmt.Errorf("auth: %w", err)
+		}
+	}
+
+	// The public port only proxies, since the tunnel points to it. The
+	// inspector and admin API live on a separate listener, loopback-only
+	// unless auth is enabled.
+	admin := http.NewServeMux()
+	admin.HandleFunc("/", handlers.HandleHTTPRequest(state, staticFiles))
+	admin.HandleFunc("/configure", handlers.ConfigureProxy(state, port))
+	admin.HandleFunc("/ws", handlers.HandleWebSocket(state))
+	admin.HandleFunc("/status", handlers.GetStatus(state))
+	admin.HandleFunc("/api/config", handlers.HandleConfigAPI(state, port))
+	admin.HandleFunc("/api/tunnel/restart", handlers.HandleTunnelRestart(state, port))
+	admin.HandleFunc("/api/bodies/", handlers.HandleBodyDownload(state))
+	admin.HandleFunc("/api/login", handlers.HandleLogin(authn))
+	admin.HandleFunc("/api/logout", handlers.HandleLogout(authn))
+	admin.HandleFunc("/api/session", handlers.HandleSession(authn))
+
+	srv := newServer(cfg.Addr(), handlers.HandleProxy(state))
+	adminSrv := newServer("", handlers.AdminOnly(authn, admin))
+
+	// Shut down gracefully on interrupt
+	shutdownDone := make(chan struct{})
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		shutdown(state, srv, adminSrv)
+		close(shutdownDone)
+	}()
 
 	// Start the server
 	fmt.Println("=================================================")
 	fmt.Printf("Starting DRIFT on port %s\n", port)
+	for _, source := range cfg.Sources {
+		fmt.Printf("Config file: %s\n", source)
+	}
+	if cfg.Profile != "" {
+		fmt.Printf("Profile: %s\n", cfg.Profile)
+	}
 	fmt.Println("Configure DRIFT at:")
-	fmt.Printf("Local URL: http://localhost:%s/inspector/configure\n", port)
+	if authn != nil {
+		host := cfg.AdminHost
+		if host == "" || host == "0.0.0.0" || host == "::" {
+			host = "localhost"
+		}
+		fmt.Printf("Local URL: http://%s/inspector/configure?token=%s\n", net.JoinHostPort(host, cfg.AdminPort), authn.Token())
+		fmt.Printf("Access token: %s\n", authn.Token())
+	} else {
+		fmt.Printf("Local URL: http://localhost:%s/inspector/configure\n", cfg.AdminPort)
+	}
 	fmt.Println("=================================================")
 
-	return http.ListenAndServe(":"+port, nil)
+	listener, err := net.Listen("tcp", srv.Addr)
+	if err != nil {
+		return err
+	}
+	adminListeners, err := listenAdmin(cfg)
+	if err != nil {
+		listener.Close()
+		return err
+	}
+
+	// Configure the backend straight away when the configuration names one,
+	// once the ports are bound so that a port in use leaves no tunnel behind
+	if cfg.Backend != "" {
+		req := handlers.ConfigureRequest{
+			Backend:    cfg.Backend,
+			TunnelMode: handlers.TunnelAuto,
+		}
+		switch {
+		case cfg.TunnelProvider() == config.TunnelNone:
+			req.TunnelMode = handlers.TunnelNone
+		case cfg.Tunnel.Token != "":
+			req.TunnelMode = handlers.TunnelCustom
+			req.ZrokToken = cfg.Tunnel.Token
+			req.ZrokPort = port
+		}
+		if err := handlers.Configure(state, port, req); err != nil {
+			fmt.Printf("Failed to configure backend %s: %v\n", cfg.Backend, err)
+		} else {
+			fmt.Printf("Proxying to backend %s\n", cfg.Backend)
+		}
+	}
+
+	for _, l := range adminListeners {
+		go adminSrv.Serve(logging.TapListener(l))
+	}
+	if err := srv.Serve(logging.TapListener(listener)); !errors.Is(err, http.ErrServerClosed) {
+		// Do not leave the tunnel or its reserved share behind
+		adminSrv.Close()
+		handlers.CleanupTunnel(state)
+		handlers.Unconfigure(state)
+		return err
+	}
+
+	<-shutdownDone
+	return nil
+}
+
+// newServer creates a server that records the raw request headers, so that
+// captures keep their wire order, and accepts HTTP/2 without TLS (h2c) for
+// gRPC clients
+func newServer(addr string, handler http.Handler) *http.Server {
+	srv := &http.Server{
+		Addr:        addr,
+		Handler:     handler,
+		ConnState:   logging.ArmHeaderTap,
+		ConnContext: logging.HeaderTapContext,
+	}
+	srv.Protocols = new(http.Protocols)
+	srv.Protocols.SetHTTP1(true)
+	srv.Protocols.SetUnencryptedHTTP2(true)
+	return srv
+}
+
+// listenAdmin listens on the admin addresses. The IPv6 loopback address is
+// optional, as some hosts have it disabled.
+func listenAdmin(cfg *config.Config) ([]net.Listener, error) {
+	var listeners []net.Listener
+	for i, addr := range cfg.AdminAddrs() {
+		l, err := net.Listen("tcp", addr)
+		if err != nil {
+			if i > 0 {
+				continue
+			}
+			return nil, fmt.Errorf("inspector: %w", err)
+		}
+		listeners = append(listeners, l)
+	}
+	return listeners, nil
+}
+
+// shutdown stops accepting connections, drains in-flight requests, flushes
+// the capture pipeline to WebSocket clients, closes those clients, removes
+// spilled bodies and finally releases the tunnel
+func shutdown(state *models.AppState, servers ...*http.Server) {
+	timeout := time.Duration(state.Settings.Load().DrainTimeout)
+	fmt.Printf("Shutting down, draining in-flight requests (up to %s)...\n", timeout)
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	for _, srv := range servers {
+		if err := srv.Shutdown(ctx); err != nil {
+			fmt.Printf("Drain timeout reached, closing remaining connections: %v\n", err)
+			srv.Close()
+		}
+	}
+
+	// Deliver what is still queued before disconnecting the dashboards
+	flushCtx, cancelFlush := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancelFlush()
+	if err := state.Capture.Close(flushCtx); err != nil {
+		fmt.Printf("Timed out flushing captured logs: %v\n", err)
+	}
+	state.Hub.CloseAll("DRIFT is shutting down")
+	state.Bodies.Close()
+
+	fmt.Println("Cleaning up resources...")
+	handlers.CleanupTunnel(state)
+	handlers.Unconfigure(state)
+	fmt.Println("DRIFT stopped")
 }
@@ -0,0 +1,15 @@
+//go:build !(darwin || dragonfly || freebsd || linux || netbsd || openbsd || windows)
+
+package tunnel
+
+import "os"
+
+// lockFile does nothing where file locks are not supported, leaving
+// processes that update the state file at once to race
+func lockFile(f *os.File) error {
+	return nil
+}
+
+func unlockFile(f *os.File) error {
+	return nil
+}
@@ -0,0 +1,183 @@
+package tunnel
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os/exec"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// urlTimeout bounds how long a provider may take to print its public URL
+const urlTimeout = 30 * time.Second
+
+// processTunnel runs a provider command and reads the public URL from its
+// output, or knows it up front
+type processTunnel struct {
+	provider string
+	command  string
+	args     func(port string) []string
+	// pattern finds the public URL in the output of the command, in its
+	// last matching group
+	pattern *regexp.Regexp
+	// accept rejects matches that are not the public URL, if set
+	accept func(string) bool
+	// access turns the match into the command that reaches a private share,
+	// which has no public URL, if set
+	access func(match string) string
+	// probe prepares the health probes of the public URL, reporting false
+	// when the URL cannot be probed, if set
+	probe func(*http.Request) bool
+	// url is the public URL when the provider does not print it
+	url string
+
+	mu      sync.Mutex
+	cmd     *exec.Cmd
+	stopped bool
+	exited  chan struct{}
+	events  chan Status
+}
+
+func (t *processTunnel) Provider() string {
+	return t.provider
+}
+
+func (t *processTunnel) URL() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.url
+}
+
+func (t *processTunnel) Events() <-chan Status {
+	return t.events
+}
+
+func (t *processTunnel) Start(ctx context.Context, port string) error {
+	path, err := exec.LookPath(t.command)
+	if err != nil {
+		return fmt.Errorf("%s not installed: %w", t.command, err)
+	}
+
+	cmd := exec.CommandContext(ctx, path, t.args(port)...)
+	stdout, _ := cmd.StdoutPipe()
+	stderr, _ := cmd.StderrPipe()
+	fmt.Printf("Starting %s tunnel...\n", t.provider)
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start %s: %w", t.command, err)
+	}
+
+	t.mu.Lock()
+	t.cmd = cmd
+	t.stopped = false
+	t.exited = make(chan struct{})
+	if t.pattern != nil {
+		// Some providers get a new URL on each start
+		t.url = ""
+	}
+	url := t.url
+	t.mu.Unlock()
+	t.events = make(chan Status, 8)
+	t.events <- Status{Provider: t.provider, State: StateStarting}
+
+	// Providers print their URL on either stream
+	found := make(chan string, 2)
+	if t.pattern == nil {
+		found <- url
+	} else {
+		go t.scan(stdout, found)
+		go t.scan(stderr, found)
+	}
+
+	go func() {
+		defer close(t.events)
+		select {
+		case url := <-found:
+			if t.access != nil {
+				t.events <- Status{Provider: t.provider, State: StateReady, Access: t.access(url)}
+				break
+			}
+			t.mu.Lock()
+			t.url = url
+			t.mu.Unlock()
+			t.events <- Status{Provider: t.provider, State: StateReady, URL: url}
+		case <-time.After(urlTimeout):
+			t.events <- Status{Provider: t.provider, State: StateFailed, Error: "public URL not found (timeout)"}
+		case <-t.exited:
+		}
+
+		<-t.exited
+		t.mu.Lock()
+		stopped := t.stopped
+		t.mu.Unlock()
+		switch {
+		case stopped || ctx.Err() != nil:
+			fmt.Printf("%s tunnel stopped\n", t.provider)
+			t.events <- Status{Provider: t.provider, State: StateStopped}
+		default:
+			fmt.Printf("%s exited: %v\n", t.command, cmd.ProcessState)
+			t.events <- Status{Provider: t.provider, State: StateFailed, Error: fmt.Sprintf("%s exited: %v", t.command, cmd.ProcessState)}
+		}
+	}()
+
+	go func() {
+		cmd.Wait()
+		close(t.exited)
+	}()
+	return nil
+}
+
+// scan reads an output stream of the command until it ends, reporting the
+// first URL found. The stream keeps being drained so that the process never
+// blocks on a full pipe.
+func (t *processTunnel) scan(output io.Reader, found chan<- string) {
+	scanner := bufio.NewScanner(output)
+	reported := false
+	for scanner.Scan() {
+		if reported {
+			continue
+		}
+		if url := lastGroup(t.pattern.FindStringSubmatch(scanner.Text())); url != "" && (t.accept == nil || t.accept(url)) {
+			found <- url
+			reported = true
+		}
+	}
+}
+
+// Stop kills the process and waits for it to exit
+func (t *processTunnel) Stop() {
+	t.mu.Lock()
+	cmd, exited := t.cmd, t.exited
+	t.stopped = true
+	t.mu.Unlock()
+	if cmd == nil {
+		return
+	}
+	if cmd.Process != nil {
+		cmd.Process.Kill()
+	}
+	select {
+	case <-exited:
+	case <-time.After(5 * time.Second):
+	}
+}
+
+// lastGroup returns the last group of a match that matched something, or
+// the whole match when the pattern has no groups
+func lastGroup(match []string) string {
+	for i := len(match) - 1; i >= 0; i-- {
+		if match[i] != "" {
+			return match[i]
+		}
+	}
+	return ""
+}
+
+// prepareProbe lets the provider authenticate the health probes of its
+// public URL
+func (t *processTunnel) prepareProbe(req *http.Request) bool {
+	return t.probe == nil || t.probe(req)
+}
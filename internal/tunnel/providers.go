@@ -0,0 +1,62 @@
+package tunnel
+
+import (
+	"regexp"
+	"strconv"
+
+	"drift/internal/config"
+)
+
+// NewCloudflared creates a Cloudflare quick tunnel, which needs no account
+// and gets a random trycloudflare.com URL
+func NewCloudflared() Tunnel {
+	return &processTunnel{
+		provider: config.TunnelCloudflared,
+		command:  "cloudflared",
+		args: func(port string) []string {
+			return []string{"tunnel", "--no-autoupdate", "--url", "http://localhost:" + port}
+		},
+		pattern: regexp.MustCompile(`https://[a-z0-9\-]+\.trycloudflare\.com`),
+	}
+}
+
+// NewNgrok creates an ngrok tunnel, on the static domain of the settings if
+// one is set
+func NewNgrok(settings config.NgrokConfig) Tunnel {
+	return &processTunnel{
+		provider: config.TunnelNgrok,
+		command:  "ngrok",
+		args: func(port string) []string {
+			args := []string{"http", port, "--log", "stdout", "--log-format", "logfmt"}
+			if settings.Domain != "" {
+				args = append(args, "--url", settings.Domain)
+			}
+			return args
+		},
+		// The URL is logged when the tunnel session is established
+		pattern: regexp.MustCompile(`msg="started tunnel".*\burl=(https://\S+)`),
+	}
+}
+
+// NewSSH creates a remote port forward to a host of your own. The public URL
+// is the one configured, as ssh cannot know it.
+func NewSSH(settings config.SSHConfig) Tunnel {
+	return &processTunnel{
+		provider: config.TunnelSSH,
+		command:  "ssh",
+		args: func(port string) []string {
+			args := []string{
+				"-N",
+				"-o", "BatchMode=yes",
+				"-o", "ExitOnForwardFailure=yes",
+				"-o", "ServerAliveInterval=30",
+				"-R", strconv.Itoa(settings.RemotePort) + ":localhost:" + port,
+			}
+			if settings.IdentityFile != "" {
+				args = append(args, "-i", settings.IdentityFile)
+			}
+			return append(args, settings.Host)
+		},
+		url: settings.PublicURL,
+	}
+}
@@ -0,0 +1,109 @@
+package tunnel
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+
+	"drift/internal/config"
+	"drift/internal/relay"
+)
+
+// relayTunnel registers with a relay started by drift relay, which forwards
+// public requests over the connection
+type relayTunnel struct {
+	settings config.RelayConfig
+
+	mu      sync.Mutex
+	session *relay.Session
+	cancel  context.CancelFunc
+	stopped bool
+	done    chan struct{}
+	events  chan Status
+}
+
+// NewRelay creates a tunnel through a self-hosted relay
+func NewRelay(settings config.RelayConfig) Tunnel {
+	return &relayTunnel{settings: settings}
+}
+
+func (t *relayTunnel) Provider() string {
+	return config.TunnelRelay
+}
+
+func (t *relayTunnel) URL() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.session == nil {
+		return ""
+	}
+	return t.session.URL
+}
+
+func (t *relayTunnel) Events() <-chan Status {
+	return t.events
+}
+
+func (t *relayTunnel) Start(ctx context.Context, port string) error {
+	ctx, cancel := context.WithCancel(ctx)
+	t.mu.Lock()
+	t.cancel = cancel
+	t.stopped = false
+	t.session = nil
+	t.done = make(chan struct{})
+	t.mu.Unlock()
+	t.events = make(chan Status, 8)
+	t.events <- Status{Provider: config.TunnelRelay, State: StateStarting}
+	fmt.Printf("Connecting to relay %s...\n", t.settings.URL)
+
+	go func() {
+		defer close(t.done)
+		defer close(t.events)
+		session, err := relay.Dial(ctx, t.settings.URL, t.settings.Token, t.settings.Name)
+		if err != nil {
+			t.finish(ctx, err)
+			return
+		}
+		t.mu.Lock()
+		t.session = session
+		t.mu.Unlock()
+		t.events <- Status{Provider: config.TunnelRelay, State: StateReady, URL: session.URL}
+
+		// The relay goes away with the context, like the process of the
+		// other providers
+		go func() {
+			<-ctx.Done()
+			session.Close()
+		}()
+		t.finish(ctx, session.Serve(net.JoinHostPort("localhost", port)))
+	}()
+	return nil
+}
+
+// finish reports the end of the tunnel, failed unless it was stopped
+func (t *relayTunnel) finish(ctx context.Context, err error) {
+	t.mu.Lock()
+	stopped := t.stopped
+	t.mu.Unlock()
+	if stopped || ctx.Err() != nil {
+		fmt.Println("relay tunnel stopped")
+		t.events <- Status{Provider: config.TunnelRelay, State: StateStopped}
+		return
+	}
+	fmt.Printf("relay tunnel failed: %v\n", err)
+	t.events <- Status{Provider: config.TunnelRelay, State: StateFailed, Error: err.Error()}
+}
+
+// Stop closes the connection to the relay and waits for the tunnel to end
+func (t *relayTunnel) Stop() {
+	t.mu.Lock()
+	t.stopped = true
+	cancel, done := t.cancel, t.done
+	t.mu.Unlock()
+	if cancel == nil {
+		return
+	}
+	cancel()
+	<-done
+}
@@ -0,0 +1,267 @@
+package tunnel
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"syscall"
+	"time"
+
+	"drift/internal/config"
+)
+
+// Reservation is a zrok share that DRIFT reserved for a project, kept so
+// that the project gets the same public URL after a restart
+type Reservation struct {
+	Token string `json:"token"`
+	URL   string `json:"url,omitempty"`
+	// Project is the directory DRIFT ran for, and Backend the backend it
+	// proxied to
+	Project string `json:"project"`
+	Backend string `json:"backend"`
+	// Port is the proxy port the share points to
+	Port       string    `json:"port"`
+	Mode       string    `json:"mode"`
+	UniqueName string    `json:"unique_name,omitempty"`
+	ReservedAt time.Time `json:"reserved_at"`
+	LastUsed   time.Time `json:"last_used"`
+	// PID is the DRIFT process sharing the reservation, 0 when none
+	PID int `json:"pid,omitempty"`
+}
+
+// InUse reports whether a running DRIFT shares the reservation
+func (r Reservation) InUse() bool {
+	if r.PID <= 0 {
+		return false
+	}
+	process, err := os.FindProcess(r.PID)
+	if err != nil {
+		return false
+	}
+	// Finding a process on Windows already checks that it runs
+	if runtime.GOOS == "windows" {
+		return true
+	}
+	return process.Signal(syscall.Signal(0)) == nil
+}
+
+// matches reports whether r was reserved for a project, backend and port
+// with the same share options
+func (r Reservation) matches(project, backend, port string, settings config.ZrokConfig) bool {
+	return r.Project == project && r.Backend == backend && r.Port == port &&
+		r.Mode == settings.Mode && r.UniqueName == settings.UniqueName
+}
+
+// storeFile is the layout of the state file
+type storeFile struct {
+	Version      int           `json:"version"`
+	Reservations []Reservation `json:"reservations"`
+}
+
+// Store keeps the reservations of DRIFT in a JSON state file. Every
+// operation rereads the file, so that DRIFT processes running side by side
+// see each other's reservations, and updates hold a lock on a file next to
+// it, so that they do not overwrite each other's.
+type Store struct {
+	path string
+	mu   sync.Mutex
+}
+
+// NewStore opens the state file at path, which is created on first write
+func NewStore(path string) *Store {
+	return &Store{path: path}
+}
+
+// StatePath returns the default state file,
+// $XDG_STATE_HOME/drift/tunnels.json or ~/.local/state/drift/tunnels.json
+func StatePath() string {
+	dir := os.Getenv("XDG_STATE_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return ""
+		}
+		dir = filepath.Join(home, ".local", "state")
+	}
+	return filepath.Join(dir, "drift", "tunnels.json")
+}
+
+// Path returns the state file of the store
+func (s *Store) Path() string {
+	return s.path
+}
+
+// List returns every reservation
+func (s *Store) List() ([]Reservation, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.read()
+}
+
+// Find returns the reservation of a project, backend and port with the
+// share options of settings
+func (s *Store) Find(project, backend, port string, settings config.ZrokConfig) (Reservation, bool, error) {
+	reservations, err := s.List()
+	if err != nil {
+		return Reservation{}, false, err
+	}
+	for _, r := range reservations {
+		if r.matches(project, backend, port, settings) {
+			return r, true, nil
+		}
+	}
+	return Reservation{}, false, nil
+}
+
+// Put records a reservation, replacing the one with the same token
+func (s *Store) Put(r Reservation) error {
+	unlock, err := s.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	reservations, err := s.read()
+	if err != nil {
+		return err
+	}
+	for i := range reservations {
+		if reservations[i].Token == r.Token {
+			reservations[i] = r
+			return s.write(reservations)
+		}
+	}
+	return s.write(append(reservations, r))
+}
+
+// Lease records the DRIFT process sharing the reservation of token, 0 when
+// it stops
+func (s *Store) Lease(token string, pid int) error {
+	unlock, err := s.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	reservations, err := s.read()
+	if err != nil {
+		return err
+	}
+	for i := range reservations {
+		if reservations[i].Token == token {
+			reservations[i].PID = pid
+			return s.write(reservations)
+		}
+	}
+	return nil
+}
+
+// Remove forgets the reservations of tokens, reporting how many there were
+func (s *Store) Remove(tokens ...string) (int, error) {
+	unlock, err := s.lock()
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+	reservations, err := s.read()
+	if err != nil {
+		return 0, err
+	}
+	drop := make(map[string]bool, len(tokens))
+	for _, token := range tokens {
+		drop[token] = true
+	}
+	kept := reservations[:0]
+	for _, r := range reservations {
+		if !drop[r.Token] {
+			kept = append(kept, r)
+		}
+	}
+	removed := len(reservations) - len(kept)
+	if removed == 0 {
+		return 0, nil
+	}
+	return removed, s.write(kept)
+}
+
+// lock serializes the updates of the state file, within the process and
+// with other DRIFT processes, returning the function that ends them. Reads
+// need no lock as the file is replaced at once.
+func (s *Store) lock() (unlock func(), err error) {
+	if s.path == "" {
+		return nil, errors.New("no state directory")
+	}
+	s.mu.Lock()
+	defer func() {
+		if err != nil {
+			s.mu.Unlock()
+		}
+	}()
+	if err := os.MkdirAll(filepath.Dir(s.path), 0o700); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(s.path+".lock", os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	if err := lockFile(f); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("locking %s: %w", f.Name(), err)
+	}
+	return func() {
+		unlockFile(f)
+		f.Close()
+		s.mu.Unlock()
+	}, nil
+}
+
+func (s *Store) read() ([]Reservation, error) {
+	if s.path == "" {
+		return nil, errors.New("no state directory")
+	}
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var file storeFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("%s: %w", s.path, err)
+	}
+	return file.Reservations, nil
+}
+
+// write replaces the state file, through a temporary file so that readers
+// never see it half written
+func (s *Store) write(reservations []Reservation) error {
+	if s.path == "" {
+		return errors.New("no state directory")
+	}
+	if reservations == nil {
+		reservations = []Reservation{}
+	}
+	data, err := json.MarshalIndent(storeFile{Version: 1, Reservations: reservations}, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0o700); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), ".tunnels-*.json")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
@@ -0,0 +1,251 @@
+package tunnel
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Restart policy of the supervisor. The delay before a restart doubles
+// with each failed attempt; the tunnel is given up on after maxRestarts
+// attempts in a row that never got it ready.
+const (
+	minBackoff  = time.Second
+	maxBackoff  = time.Minute
+	maxRestarts = 10
+	// probeFailuresRestart is how many failed probes in a row restart a
+	// tunnel that is still running
+	probeFailuresRestart = 3
+)
+
+// ProbeHeader marks the health probes sent through the public URL
+const ProbeHeader = "X-Drift-Probe"
+
+// supervise follows the events of t and starts it again, after a backoff,
+// whenever it goes down, until ctx is cancelled
+func (m *Manager) supervise(ctx context.Context, t Tunnel, port string, done chan struct{}) {
+	defer close(done)
+	failures := 0
+	for {
+		last := m.watch(ctx, t)
+		if ctx.Err() != nil {
+			return
+		}
+		if last.State == StateReady {
+			failures = 0
+		}
+		failures++
+		if failures > maxRestarts {
+			fmt.Printf("%s tunnel failed %d times in a row, giving up: %s\n", t.Provider(), maxRestarts, last.Error)
+			m.update(t, Status{Provider: t.Provider(), State: StateFailed, Error: last.Error})
+			return
+		}
+
+		delay := backoff(failures)
+		fmt.Printf("%s tunnel down (%s), restarting in %s\n", t.Provider(), last.Error, delay)
+		m.update(t, Status{Provider: t.Provider(), State: StateRestarting, Error: last.Error})
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+
+		m.mu.Lock()
+		m.restarts++
+		m.mu.Unlock()
+		m.update(t, Status{Provider: t.Provider(), State: StateStarting})
+		for {
+			err := t.Start(ctx, port)
+			if err == nil || ctx.Err() != nil {
+				break
+			}
+			failures++
+			if failures > maxRestarts {
+				fmt.Printf("%s tunnel failed %d times in a row, giving up: %v\n", t.Provider(), maxRestarts, err)
+				m.update(t, Status{Provider: t.Provider(), State: StateFailed, Error: err.Error()})
+				return
+			}
+			delay := backoff(failures)
+			fmt.Printf("%s tunnel could not start (%v), retrying in %s\n", t.Provider(), err, delay)
+			m.update(t, Status{Provider: t.Provider(), State: StateRestarting, Error: err.Error()})
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(delay):
+			}
+		}
+	}
+}
+
+// watch relays the events of a running tunnel to the status, probing its
+// public URL while it is ready, until the tunnel stops. It returns the
+// last state the tunnel reached before stopping, with the reason it
+// stopped: ready only if the tunnel was healthy until it went down.
+func (m *Manager) watch(ctx context.Context, t Tunnel) Status {
+	probeCtx, stopProbe := context.WithCancel(ctx)
+	defer stopProbe()
+
+	var last Status
+	reason := ""
+	for status := range t.Events() {
+		switch status.State {
+		case StateReady:
+			if status.Access != "" {
+				fmt.Printf("Private %s share, reach it with: %s\n", status.Provider, status.Access)
+			}
+			if status.URL != "" {
+				fmt.Println("=================================================")
+				fmt.Printf("Public URL (%s): %s\n", status.Provider, status.URL)
+				fmt.Println("=================================================")
+				go m.probeLoop(probeCtx, t, status)
+			}
+			last = status
+			m.update(t, status)
+		case StateFailed, StateStopped:
+			if status.Error != "" && reason == "" {
+				reason = status.Error
+			}
+			// A tunnel may fail while its process keeps running
+			if status.State == StateFailed {
+				go t.Stop()
+			}
+		default:
+			m.update(t, status)
+		}
+	}
+	if reason == "" {
+		// Tunnels stop on their own only when the probe restarts them
+		reason = "tunnel stopped"
+		if current := m.Status(); current.State == StateDegraded {
+			// An unreachable URL does not count as having been ready
+			last.State = StateDegraded
+			reason = current.Error
+		}
+	}
+	last.Error = reason
+	return last
+}
+
+// probeLoop checks the public URL of a ready tunnel until ctx is
+// cancelled. Failing probes mark the tunnel degraded, and restart it when
+// they keep failing.
+func (m *Manager) probeLoop(ctx context.Context, t Tunnel, ready Status) {
+	prepare := func(*http.Request) bool { return true }
+	if p, ok := t.(probePreparer); ok {
+		prepare = p.prepareProbe
+	}
+	if m.probe.interval <= 0 || !prepare(&http.Request{Header: http.Header{}}) {
+		return
+	}
+	ticker := time.NewTicker(m.probe.interval)
+	defer ticker.Stop()
+
+	failures := 0
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		err := m.probe.check(ctx, ready.URL, prepare)
+		if ctx.Err() != nil {
+			return
+		}
+		if err == nil {
+			if failures > 0 {
+				fmt.Printf("%s tunnel healthy again\n", t.Provider())
+				m.update(t, ready)
+			}
+			failures = 0
+			continue
+		}
+
+		failures++
+		fmt.Printf("%s tunnel probe failed (%d/%d): %v\n", t.Provider(), failures, probeFailuresRestart, err)
+		degraded := ready
+		degraded.State = StateDegraded
+		degraded.Error = "public URL probe failed: " + err.Error()
+		m.update(t, degraded)
+		if failures >= probeFailuresRestart {
+			// Stopping the tunnel ends watch, and the supervisor starts it
+			// again
+			t.Stop()
+			return
+		}
+	}
+}
+
+// probePreparer is implemented by tunnels that put a login in front of
+// their public URL. prepareProbe adds credentials to a probe, or reports
+// false when the URL cannot be probed.
+type probePreparer interface {
+	prepareProbe(req *http.Request) bool
+}
+
+// prober sends requests carrying a random token through the public URL.
+// The public port answers them itself, so they reach neither the backend
+// nor the captures.
+type prober struct {
+	interval time.Duration
+	token    string
+	client   *http.Client
+}
+
+func newProber(interval time.Duration) prober {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return prober{
+		interval: interval,
+		token:    hex.EncodeToString(b),
+		client: &http.Client{
+			Timeout: 10 * time.Second,
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+	}
+}
+
+// check sends a probe to url and verifies that it came back from this
+// DRIFT
+func (p prober) check(ctx context.Context, url string, prepare func(*http.Request) bool) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	prepare(req)
+	req.Header.Set(ProbeHeader, p.token)
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent || resp.Header.Get(ProbeHeader) != p.token {
+		return fmt.Errorf("public URL answered %s instead of DRIFT", resp.Status)
+	}
+	return nil
+}
+
+// answer replies to a probe of this prober
+func (p prober) answer(w http.ResponseWriter, r *http.Request) bool {
+	if subtle.ConstantTimeCompare([]byte(r.Header.Get(ProbeHeader)), []byte(p.token)) != 1 {
+		return false
+	}
+	w.Header().Set(ProbeHeader, p.token)
+	w.WriteHeader(http.StatusNoContent)
+	return true
+}
+
+// backoff returns the delay before restart attempt n, counted from 1
+func backoff(n int) time.Duration {
+	delay := minBackoff
+	for i := 1; i < n && delay < maxBackoff; i++ {
+		delay *= 2
+	}
+	return min(delay, maxBackoff)
+}
@@ -0,0 +1,193 @@
+package tunnel
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"drift/internal/config"
+)
+
+// States of a tunnel. Degraded tunnels are up but fail the health probe;
+// restarting tunnels went down and are waiting to be started again.
+const (
+	StateDisabled   = "disabled"
+	StateStarting   = "starting"
+	StateReady      = "ready"
+	StateDegraded   = "degraded"
+	StateRestarting = "restarting"
+	StateFailed     = "failed"
+	StateStopped    = "stopped"
+)
+
+// Status is the provider-neutral state of the public tunnel
+type Status struct {
+	Provider string `json:"provider"`
+	State    string `json:"state"`
+	URL      string `json:"url,omitempty"`
+	// Access is the command that reaches a private share, which has no URL
+	Access string `json:"access,omitempty"`
+	Error  string `json:"error,omitempty"`
+	// Restarts counts the restarts of the tunnel since it was started
+	Restarts int `json:"restarts,omitempty"`
+}
+
+// Tunnel exposes a local port at a public URL
+type Tunnel interface {
+	// Provider names the implementation, such as zrok
+	Provider() string
+	// Start opens the tunnel to a local port. It returns once the tunnel is
+	// being set up; Events then reports its state until it stops, either
+	// with Stop or when ctx is cancelled. A stopped tunnel can be started
+	// again.
+	Start(ctx context.Context, port string) error
+	// Stop closes the tunnel and waits for it to be gone
+	Stop()
+	// URL returns the public URL, empty until it is known
+	URL() string
+	// Events delivers state changes and is closed once the tunnel stopped
+	Events() <-chan Status
+}
+
+// New creates the tunnel of the configured provider. token is the reserved
+// zrok share to use, if any, and url its public URL when known.
+func New(settings config.TunnelConfig, token, url string) (Tunnel, error) {
+	switch settings.Provider {
+	case config.TunnelZrok, "":
+		return NewZrok(settings.Zrok, token, url), nil
+	case config.TunnelCloudflared:
+		return NewCloudflared(), nil
+	case config.TunnelNgrok:
+		return NewNgrok(settings.Ngrok), nil
+	case config.TunnelSSH:
+		return NewSSH(settings.SSH), nil
+	case config.TunnelRelay:
+		return NewRelay(settings.Relay), nil
+	}
+	return nil, fmt.Errorf("unknown tunnel provider %q", settings.Provider)
+}
+
+// Manager runs one tunnel at a time under a supervisor, which restarts it
+// when it goes down, and keeps the latest status of it
+type Manager struct {
+	probe  prober
+	notify func(Status)
+
+	mu       sync.Mutex
+	tunnel   Tunnel
+	cancel   context.CancelFunc
+	done     chan struct{}
+	status   Status
+	restarts int
+}
+
+// NewManager creates a manager with no tunnel. The public URL of running
+// tunnels is checked every probeInterval, unless it is 0.
+func NewManager(probeInterval time.Duration) *Manager {
+	return &Manager{
+		probe:  newProber(probeInterval),
+		status: Status{Provider: config.TunnelNone, State: StateStopped},
+	}
+}
+
+// OnChange registers a function called with every new status
+func (m *Manager) OnChange(notify func(Status)) {
+	m.mu.Lock()
+	m.notify = notify
+	m.mu.Unlock()
+}
+
+// Start stops the current tunnel and starts t, which is then supervised
+// until it is stopped or ctx is cancelled. An error is returned when t
+// cannot be started at all.
+func (m *Manager) Start(ctx context.Context, t Tunnel, port string) error {
+	m.Stop()
+
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	m.mu.Lock()
+	m.tunnel = t
+	m.cancel = cancel
+	m.done = done
+	m.restarts = 0
+	m.mu.Unlock()
+	m.update(t, Status{Provider: t.Provider(), State: StateStarting})
+
+	if err := t.Start(ctx, port); err != nil {
+		cancel()
+		close(done)
+		m.update(t, Status{Provider: t.Provider(), State: StateFailed, Error: err.Error()})
+		return err
+	}
+	go m.supervise(ctx, t, port, done)
+	return nil
+}
+
+// update records the status of t unless it has been replaced since
+func (m *Manager) update(t Tunnel, status Status) {
+	m.mu.Lock()
+	if m.tunnel != t {
+		m.mu.Unlock()
+		return
+	}
+	status.Restarts = m.restarts
+	changed := status != m.status
+	m.status = status
+	notify := m.notify
+	m.mu.Unlock()
+
+	if changed && notify != nil {
+		notify(status)
+	}
+}
+
+// Stop stops the current tunnel, reporting whether there was one
+func (m *Manager) Stop() bool {
+	m.mu.Lock()
+	t, cancel, done := m.tunnel, m.cancel, m.done
+	m.tunnel, m.cancel, m.done = nil, nil, nil
+	if t != nil {
+		m.status = Status{Provider: t.Provider(), State: StateStopped}
+	}
+	notify, status := m.notify, m.status
+	m.mu.Unlock()
+
+	if t == nil {
+		return false
+	}
+	fmt.Printf("Stopping %s tunnel...\n", t.Provider())
+	cancel()
+	t.Stop()
+	<-done
+	if notify != nil {
+		notify(status)
+	}
+	return true
+}
+
+// Disable stops the current tunnel and reports the tunnel as disabled
+func (m *Manager) Disable() {
+	m.Stop()
+	m.mu.Lock()
+	m.status = Status{Provider: config.TunnelNone, State: StateDisabled}
+	notify, status := m.notify, m.status
+	m.mu.Unlock()
+	if notify != nil {
+		notify(status)
+	}
+}
+
+// Status returns the state of the current tunnel
+func (m *Manager) Status() Status {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.status
+}
+
+// AnswerProbe replies to the health probes of the manager, which reach the
+// public port through the tunnel, and reports whether r was one
+func (m *Manager) AnswerProbe(w http.ResponseWriter, r *http.Request) bool {
+	return m.probe.answer(w, r)
+}
@@ -1,30 +1,60 @@
 package tunnel
 
 import (
-	"bufio"
 	"encoding/json"
 	"fmt"
-	"io"
+	"net"
+	"net/http"
+	neturl "net/url"
 	"os"
 	"os/exec"
-	"os/signal"
+	"path/filepath"
 	"regexp"
 	"strings"
-	"syscall"
-	"time"
 
-	"drift/internal/models"
+	"drift/internal/config"
 )
 
-// ReserveZrokToken reserves a new zrok share token for a specific port
-func ReserveZrokToken(port string) (string, string, error) {
+// ReserveZrokToken reserves a new zrok share for a specific port, with the
+// mode, unique name and login of the settings. It returns the share token
+// and its public URL, which is empty for private shares.
+func ReserveZrokToken(settings config.ZrokConfig, port string) (string, string, error) {
 	zrokPath, err := exec.LookPath("zrok")
 	if err != nil {
 		return "", "", fmt.Errorf("zrok not found: %v", err)
 	}
 
-	cmd := exec.Command(zrokPath, "reserve", "public", "--backend-mode", "proxy", port)
-	output, err := cmd.CombinedOutput()
+	args := []string{"reserve", settings.Mode, "--backend-mode", "proxy"}
+	if settings.UniqueName != "" {
+		args = append(args, "--unique-name", settings.UniqueName)
+	}
+	args = append(args, zrokAuthArgs(settings)...)
+
+	// Prefer JSON output if available
+	cmd := exec.Command(zrokPath, append(append(args, "--json"), port)...)
+	output, err := cmd.Output()
+	if err == nil {
+		var share struct {
+			Token             string   `json:"token"`
+			ShareToken        string   `json:"shareToken"`
+			FrontendEndpoints []string `json:"frontendEndpoints"`
+		}
+		if json.Unmarshal(output, &share) == nil && (share.Token != "" || share.ShareToken != "") {
+			token := share.Token
+			if token == "" {
+				token = share.ShareToken
+			}
+			url := ""
+			if len(share.FrontendEndpoints) > 0 {
+				url = share.FrontendEndpoints[0]
+			}
+			return token, url, nil
+		}
+	}
+
+	// Retry without --json in case version doesn't support it
+	cmd = exec.Command(zrokPath, append(args, port)...)
+	output, err = cmd.CombinedOutput()
 	if err != nil {
 		return "", "", fmt.Errorf("failed to reserve zrok token: %v, output: %s", err, output)
 	}
@@ -32,12 +62,17 @@ func ReserveZrokToken(port string) (string, string, error) {
 	// Parse the output to extract the token and URL
 	outputStr := string(output)
 	tokenRegex := regexp.MustCompile(`your reserved share token is '([^']+)'`)
-	urlRegex := regexp.MustCompile(`reserved frontend endpoint: (https://[^\s]+)`)
+	urlRegex := regexp.MustCompile(`reserved frontend endpoint: (https?://[^\s]+)`)
 
 	tokenMatch := tokenRegex.FindStringSubmatch(outputStr)
+	if len(tokenMatch) < 2 {
+		return "", "", fmt.Errorf("failed to parse zrok reserve output")
+	}
+	if settings.Mode == config.ZrokPrivate {
+		return tokenMatch[1], "", nil
+	}
 	urlMatch := urlRegex.FindStringSubmatch(outputStr)
-
-	if len(tokenMatch) < 2 || len(urlMatch) < 2 {
+	if len(urlMatch) < 2 {
 		return "", "", fmt.Errorf("failed to parse zrok reserve output")
 	}
 
@@ -59,8 +94,35 @@ func ReleaseZrokToken(token string) error {
 	return nil
 }
 
+// ZrokShare is a reserved share listed by zrok overview
+type ZrokShare struct {
+	Token string `json:"token"`
+	Mode  string `json:"mode,omitempty"`
+	// URL is the public URL of the share, empty for private shares
+	URL string `json:"url,omitempty"`
+	// Target is the local address zrok forwards the share to
+	Target string `json:"target,omitempty"`
+	// Traffic is set when zrok saw requests on the share recently
+	Traffic bool `json:"traffic"`
+}
+
 // GetAllReservedZrokTokens retrieves all reserved zrok share tokens
 func GetAllReservedZrokTokens() ([]string, error) {
+	shares, err := ListReservedZrokShares()
+	if err != nil {
+		return nil, err
+	}
+	tokens := make([]string, 0, len(shares))
+	for _, share := range shares {
+		tokens = append(tokens, share.Token)
+	}
+	return tokens, nil
+}
+
+// ListReservedZrokShares retrieves the reserved zrok shares of the
+// environment. Only the tokens are known when zrok overview has no JSON
+// output.
+func ListReservedZrokShares() ([]ZrokShare, error) {
 	zrokPath, err := exec.LookPath("zrok")
 	if err != nil {
 		return nil, fmt.Errorf("zrok not found: %v", err)
@@ -84,23 +146,46 @@ func GetAllReservedZrokTokens() ([]string, error) {
 	var jsonData struct {
 		Environments []struct {
 			Shares []struct {
-				Reserved   bool   `json:"reserved"`
-				ShareToken string `json:"shareToken"`
+				Reserved             bool   `json:"reserved"`
+				Token                string `json:"token"`
+				ShareToken           string `json:"shareToken"`
+				ShareMode            string `json:"shareMode"`
+				FrontendEndpoint     string `json:"frontendEndpoint"`
+				BackendProxyEndpoint string `json:"backendProxyEndpoint"`
+				Activity             []struct {
+					Rx int64 `json:"rx"`
+					Tx int64 `json:"tx"`
+				} `json:"activity"`
 			} `json:"shares"`
 		} `json:"environments"`
 	}
 
 	if json.Unmarshal([]byte(outputStr), &jsonData) == nil {
-		var tokens []string
+		var shares []ZrokShare
 		for _, env := range jsonData.Environments {
 			for _, share := range env.Shares {
-				if share.Reserved {
-					tokens = append(tokens, share.ShareToken)
+				if !share.Reserved {
+					continue
+				}
+				token := share.Token
+				if token == "" {
+					token = share.ShareToken
 				}
+				traffic := false
+				for _, sample := range share.Activity {
+					traffic = traffic || sample.Rx > 0 || sample.Tx > 0
+				}
+				shares = append(shares, ZrokShare{
+					Token:   token,
+					Mode:    share.ShareMode,
+					URL:     share.FrontendEndpoint,
+					Target:  share.BackendProxyEndpoint,
+					Traffic: traffic,
+				})
 			}
 		}
-		if len(tokens) > 0 {
-			return tokens, nil
+		if len(shares) > 0 {
+			return shares, nil
 		}
 	}
 
@@ -108,176 +193,160 @@ func GetAllReservedZrokTokens() ([]string, error) {
 	tokenRegex := regexp.MustCompile(`reserved share token:\s*([a-zA-Z0-9]+)`)
 	matches := tokenRegex.FindAllStringSubmatch(outputStr, -1)
 
-	var tokens []string
+	var shares []ZrokShare
 	for _, match := range matches {
 		if len(match) > 1 {
-			tokens = append(tokens, match[1])
+			shares = append(shares, ZrokShare{Token: match[1]})
 		}
 	}
 
-	return tokens, nil
+	return shares, nil
 }
 
-// StartZrok starts a zrok tunnel for public access
-func StartZrok(state *models.AppState, port string) {
-	// Set initial zrokURL to indicate initialization
-	state.ZrokMu.Lock()
-	state.ZrokURL = "Initializing Zrok tunnel..."
-	state.ZrokMu.Unlock()
-
-	// Check if zrok is installed
-	zrokPath, err := exec.LookPath("zrok")
-	if err != nil {
-		fmt.Println("Zrok not found. Public URL will not be available.")
-		state.ZrokMu.Lock()
-		state.ZrokURL = "Zrok not installed"
-		state.ZrokMu.Unlock()
-		return
+// zrokAccess matches the command that reaches a private share
+var zrokAccess = regexp.MustCompile(`zrok access private ([a-zA-Z0-9]+)`)
+
+// zrokEndpoint matches the public URL printed by zrok share when the domain
+// of the frontend is unknown: a URL on a line of its own, as listed under
+// "access your zrok share at the following endpoints", or after "share at"
+var zrokEndpoint = regexp.MustCompile(`^\s*(https?://[^\s"']+)\s*$|share at\b.*?(https?://[^\s"']+)`)
+
+// zrokShareURL matches the URLs of public shares on zrok.io
+var zrokShareURL = regexp.MustCompile(`(https://[a-zA-Z0-9\-]+\.share\.zrok\.io)`)
+
+// zrokShares matches the public URLs zrok prints whatever the instance
+var zrokShares = regexp.MustCompile(zrokShareURL.String() + `|` + zrokEndpoint.String())
+
+// NewZrok creates a zrok tunnel. It shares the reserved token when one is
+// given, whose public URL is url if known, and otherwise creates a random
+// share in the mode of the settings.
+func NewZrok(settings config.ZrokConfig, token, url string) Tunnel {
+	t := &processTunnel{
+		provider: config.TunnelZrok,
+		command:  "zrok",
+		args: func(port string) []string {
+			args := []string{"share"}
+			if token == "" {
+				args = append(args, settings.Mode, "--backend-mode", "proxy")
+				args = append(args, zrokAuthArgs(settings)...)
+			} else {
+				args = append(args, "reserved")
+			}
+			if settings.Headless {
+				args = append(args, "--headless")
+			}
+			if token == "" {
+				return append(args, port)
+			}
+			return append(args, token)
+		},
 	}
-
-	// Kill any existing zrok process
-	state.ZrokCmd.Lock()
-	if state.ZrokProcess != nil {
-		if process, ok := state.ZrokProcess.(*exec.Cmd); ok && process.Process != nil {
-			fmt.Println("Killing existing zrok process...")
-			process.Process.Kill()
-			process.Wait()
+	t.pattern, t.accept = zrokURLPattern(settings, url)
+
+	switch {
+	case settings.Mode == config.ZrokPrivate:
+		t.pattern = zrokAccess
+		t.access = func(token string) string { return "zrok access private " + token }
+	case settings.OAuth.Provider != "":
+		// The OAuth login of the frontend stops probes
+		t.probe = func(*http.Request) bool { return false }
+	case len(settings.BasicAuth) > 0:
+		user, password, _ := strings.Cut(settings.BasicAuth[0], ":")
+		t.probe = func(req *http.Request) bool {
+			req.SetBasicAuth(user, password)
+			return true
 		}
 	}
-	state.ZrokCmd.Unlock()
-
-	// Get the reserved token
-	state.ConfigMu.Lock()
-	reservedToken := state.Config.ZrokToken
-	tokenPort := state.Config.ZrokPort
-	state.ConfigMu.Unlock()
-
-	// Check if we have a valid token
-	if reservedToken == "" {
-		fmt.Println("No reserved token available, using random public share")
-		cmd := exec.Command(zrokPath, "share", "public", "--backend-mode", "proxy", port)
-		startZrokProcess(cmd, state, port)
-		return
+	return t
+}
+
+// zrokAuthArgs returns the flags of the login in front of a share
+func zrokAuthArgs(settings config.ZrokConfig) []string {
+	var args []string
+	for _, pair := range settings.BasicAuth {
+		args = append(args, "--basic-auth", pair)
+	}
+	if settings.OAuth.Provider != "" {
+		args = append(args, "--oauth-provider", settings.OAuth.Provider)
+		for _, domain := range settings.OAuth.EmailDomains {
+			args = append(args, "--oauth-email-domains", domain)
+		}
 	}
+	return args
+}
 
-	// Check if the token is for the current port
-	if tokenPort != port {
-		fmt.Printf("Warning: Token %s was created for port %s but current port is %s\n",
-			reservedToken, tokenPort, port)
+// zrokURLPattern finds the public URL of a share in the output of zrok:
+// the URL itself or an endpoint zrok lists when it is known from the
+// reservation, or any URL on the configured frontend domain. Otherwise it
+// matches the URLs zrok lists as endpoints and, since the frontend of an
+// instance usually shares its domain, any URL on the parent domain of the
+// API endpoint. The API itself is rejected by accept. Shares on zrok.io
+// are always matched.
+func zrokURLPattern(settings config.ZrokConfig, url string) (pattern *regexp.Regexp, accept func(string) bool) {
+	if url != "" {
+		return regexp.MustCompile(`(` + regexp.QuoteMeta(url) + `)|` + zrokShares.String()), nil
+	}
+	if settings.FrontendDomain != "" {
+		return regexp.MustCompile(`(https?://[a-zA-Z0-9\-]+\.` + regexp.QuoteMeta(settings.FrontendDomain) + `)\b|` + zrokShares.String()), nil
 	}
 
-	// Create the command for reserved token
-	cmd := exec.Command(zrokPath, "share", "reserved", reservedToken)
-	fmt.Println("Using reserved zrok token:", reservedToken)
-	startZrokProcess(cmd, state, port)
+	api, err := neturl.Parse(zrokAPIEndpoint())
+	if err != nil || api.Hostname() == "" {
+		return zrokShares, nil
+	}
+	accept = func(found string) bool {
+		u, err := neturl.Parse(found)
+		return err == nil && u.Hostname() != api.Hostname()
+	}
+	domain := zrokShareDomain(api.Hostname())
+	if domain == "" {
+		// Shares of a local instance cannot be told from its API
+		return zrokShares, accept
+	}
+	return regexp.MustCompile(`(https?://[a-zA-Z0-9\-.]+\.` + regexp.QuoteMeta(domain) + `)\b|` + zrokShares.String()), accept
 }
 
-func startZrokProcess(cmd *exec.Cmd, state *models.AppState, port string) {
-	// Set up output capture
-	stdout, _ := cmd.StdoutPipe()
-	stderr, _ := cmd.StderrPipe()
-
-	// Start the command
-	fmt.Println("Starting zrok process...")
-	if err := cmd.Start(); err != nil {
-		fmt.Printf("Failed to start zrok: %v\n", err)
-		state.ZrokMu.Lock()
-		state.ZrokURL = fmt.Sprintf("Failed to start zrok: %v", err)
-		state.ZrokMu.Unlock()
-		return
+// zrokShareDomain returns the domain the shares of an instance are expected
+// on, the parent domain of its API host: zrok.io for api-v1.zrok.io or
+// example.com for zrok.example.com. It is empty for addresses and single
+// labels such as localhost.
+func zrokShareDomain(host string) string {
+	if net.ParseIP(host) != nil {
+		return ""
 	}
+	labels := strings.Split(strings.TrimSuffix(host, "."), ".")
+	switch {
+	case len(labels) < 2:
+		return ""
+	case len(labels) > 2:
+		labels = labels[1:]
+	}
+	return strings.Join(labels, ".")
+}
 
-	// Store the command
-	state.ZrokCmd.Lock()
-	state.ZrokProcess = cmd
-	state.ZrokCmd.Unlock()
-
-	// Process output
-	go func() {
-		scanner := bufio.NewScanner(io.MultiReader(stdout, stderr))
-		timeout := time.After(30 * time.Second)
-		for scanner.Scan() {
-			select {
-			case <-timeout:
-				state.ZrokMu.Lock()
-				if state.ZrokURL == "Initializing Zrok tunnel..." {
-					state.ZrokURL = "Zrok URL not found (timeout)"
-				}
-				state.ZrokMu.Unlock()
-				return
-			default:
-				line := scanner.Text()
-				if strings.Contains(line, "http") && strings.Contains(line, "zrok.io") {
-
-					re := regexp.MustCompile(`https://[a-zA-Z0-9\-]+\.share\.zrok\.io`)
-					url := re.FindString(line)
-
-					state.ZrokMu.Lock()
-					state.ZrokURL = url
-					state.ZrokMu.Unlock()
-					fmt.Println("=================================================")
-					fmt.Println("Access DRIFT at:")
-					fmt.Printf("Local URL: http://localhost:%s/inspector/dashboard\n", port)
-					fmt.Println("Public URL:", url)
-					fmt.Println("=================================================")
-					return
-				}
-			}
-		}
-		state.ZrokMu.Lock()
-		if state.ZrokURL == "Initializing Zrok tunnel..." {
-			state.ZrokURL = "No zrok URL found in output"
-		}
-		state.ZrokMu.Unlock()
-	}()
-
-	// Wait for the process in a separate goroutine
-	go func() {
-		err := cmd.Wait()
+// zrokAPIEndpoint returns the API endpoint of the zrok environment, empty
+// when none is configured
+func zrokAPIEndpoint() string {
+	if endpoint := os.Getenv("ZROK_API_ENDPOINT"); endpoint != "" {
+		return endpoint
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	// config.json holds the endpoint set with zrok config set, which takes
+	// precedence over the one zrok enable recorded
+	for _, name := range []string{"config.json", "environment.json"} {
+		data, err := os.ReadFile(filepath.Join(home, ".zrok", name))
 		if err != nil {
-			fmt.Printf("Zrok process exited with error: %v\n", err)
-		} else {
-			fmt.Println("Zrok process exited normally")
+			continue
 		}
-	}()
-}
-
-// SetupCleanupHandler sets up a handler to clean up resources on exit
-func SetupCleanupHandler(state *models.AppState) {
-	c := make(chan os.Signal, 1)
-	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
-	go func() {
-		<-c
-		fmt.Println("Shutting down, cleaning up resources...")
-
-		// Kill zrok process only if it exists
-		state.ZrokCmd.Lock()
-		zrokProcessExists := false
-		if state.ZrokProcess != nil {
-			if process, ok := state.ZrokProcess.(*exec.Cmd); ok && process.Process != nil {
-				zrokProcessExists = true
-				fmt.Println("Stopping zrok process...")
-				process.Process.Kill()
-			}
+		var env struct {
+			APIEndpoint string `json:"api_endpoint"`
 		}
-		state.ZrokCmd.Unlock()
-
-		// Release the reserved token only if we had a zrok process
-		if zrokProcessExists {
-			state.ConfigMu.Lock()
-			token := state.Config.ZrokToken
-			state.ConfigMu.Unlock()
-
-			if token != "" {
-				fmt.Println("Releasing zrok token:", token)
-				if err := ReleaseZrokToken(token); err != nil {
-					fmt.Printf("Failed to release zrok token: %v\n", err)
-				} else {
-					fmt.Println("Successfully released zrok token")
-				}
-			}
+		if json.Unmarshal(data, &env) == nil && env.APIEndpoint != "" {
+			return env.APIEndpoint
 		}
-
-		os.Exit(0)
-	}()
+	}
+	return ""
 }
@@ -34,6 +34,8 @@ function connectWebSocket() {
   ws.onmessage = function (event) {
     try {
       const log = JSON.parse(event.data);
+      // Server events such as config reloads are not requests
+      if (log.type) return;
       requestData.push(log);
 
       // Add timestamp for the graph
@@ -207,8 +209,7 @@ function updateTopEndpoints() {
   const endpointCounts = {};
 
   requestData.forEach((log) => {
-    const url = new URL(log.request.url);
-    const endpoint = url.pathname;
+    const endpoint = endpointKey(log);
     endpointCounts[endpoint] = (endpointCounts[endpoint] || 0) + 1;
   });
 
@@ -230,26 +231,31 @@ function updateTopEndpoints() {
     const percentage = ((count / totalRequests) * 100).toFixed(1);
     const row = document.createElement("tr");
     row.innerHTML = `
-      <td>${endpoint}</td>
-      <td>${count}</td>
-      <td>${percentage}%</td>
+      <td>${escapeHTML(endpoint)}</td>
+      <td>${escapeHTML(count)}</td>
+      <td>${escapeHTML(percentage)}%</td>
     `;
     tableBody.appendChild(row);
   });
 }
 
 function updateTopErrors() {
+  // GraphQL errors are usually sent with a 200 status
+  const hasGraphQLErrors = (log) =>
+    log.response.graphql_errors && log.response.graphql_errors.length > 0;
   const errorRequests = requestData.filter((log) => {
     const statusCode = log.response.status_code;
-    return statusCode >= 400;
+    return statusCode >= 400 || hasGraphQLErrors(log);
   });
 
   const errorCounts = {};
 
   errorRequests.forEach((log) => {
-    const url = new URL(log.request.url);
-    const endpoint = url.pathname;
-    const statusCode = log.response.status_code;
+    const endpoint = endpointKey(log);
+    const statusCode =
+      log.response.status_code < 400 && hasGraphQLErrors(log)
+        ? "GQL"
+        : log.response.status_code;
     const key = `${statusCode}:${endpoint}`;
 
     errorCounts[key] = (errorCounts[key] || 0) + 1;
@@ -269,14 +275,19 @@ function updateTopErrors() {
   tableBody.innerHTML = "";
 
   sortedErrors.forEach(([key, count]) => {
-    const [statusCode, endpoint] = key.split(":");
-    const statusGroup = `${Math.floor(statusCode / 100)}xx`;
+    const separator = key.indexOf(":");
+    const statusCode = key.slice(0, separator);
+    const endpoint = key.slice(separator + 1);
+    const statusGroup =
+      statusCode === "GQL" ? "4xx" : `${Math.floor(statusCode / 100)}xx`;
 
     const row = document.createElement("tr");
     row.innerHTML = `
-      <td><span class="status-code status-${statusGroup}">${statusCode}</span></td>
-      <td>${endpoint}</td>
-      <td>${count}</td>
+      <td><span class="status-code status-${escapeHTML(statusGroup)}">${escapeHTML(
+        statusCode
+      )}</span></td>
+      <td>${escapeHTML(endpoint)}</td>
+      <td>${escapeHTML(count)}</td>
     `;
     tableBody.appendChild(row);
   });
@@ -546,9 +557,21 @@ function setupTimeFilters() {
   });
 }
 
+// Drop the requests that fall outside the retention limits from the stats
+function pruneData() {
+  const count = requestData.length + requestTimestamps.length;
+  requestData = retainRequests(requestData);
+  requestTimestamps = retainTimestamps(requestTimestamps);
+  if (requestData.length + requestTimestamps.length === count) return;
+
+  updateAnalytics();
+  updateGraph();
+}
+
 document.addEventListener("DOMContentLoaded", () => {
   loadSavedData();
   connectWebSocket();
   setupTimeFilters();
   updateGraph();
+  document.addEventListener("retention", pruneData);
 });
@@ -104,6 +104,93 @@ function clearAllData() {
   });
 }
 
+// Retention limits of the captured history, from /status
+let retention = { max_entries: 0, max_age_ms: 0 };
+
+// Keep the requests allowed by the retention limits, dropping those older
+// than max_age and the oldest beyond max_entries
+function retainRequests(logs) {
+  const cutoff = retention.max_age_ms ? Date.now() - retention.max_age_ms : 0;
+  let kept = logs.filter(
+    (log) => new Date(log.request.timestamp).getTime() >= cutoff
+  );
+  if (retention.max_entries && kept.length > retention.max_entries) {
+    kept = kept
+      .slice()
+      .sort(
+        (a, b) =>
+          new Date(a.request.timestamp) - new Date(b.request.timestamp)
+      )
+      .slice(-retention.max_entries);
+  }
+  return kept;
+}
+
+// Keep the request timestamps allowed by the retention limits
+function retainTimestamps(timestamps) {
+  const cutoff = retention.max_age_ms ? Date.now() - retention.max_age_ms : 0;
+  const kept = timestamps.filter((timestamp) => timestamp >= cutoff);
+  if (retention.max_entries && kept.length > retention.max_entries) {
+    return kept.slice(-retention.max_entries);
+  }
+  return kept;
+}
+
+// Remove the saved requests and timestamps that fall outside the retention
+// limits, then let the page prune what it shows with a "retention" event
+function applyRetention() {
+  if (!retention.max_entries && !retention.max_age_ms) return;
+
+  Promise.all([getAllRequests(), getAllTimestamps(), initDB()])
+    .then(([requests, timestamps, db]) => {
+      const keptIds = new Set(
+        retainRequests(requests).map((log) => log.request.id)
+      );
+      const removed = requests.filter((log) => !keptIds.has(log.request.id));
+      const staleTimestamps =
+        timestamps.length - retainTimestamps(timestamps).length;
+      if (removed.length === 0 && staleTimestamps === 0) return;
+
+      return new Promise((resolve, reject) => {
+        const transaction = db.transaction(
+          [REQUESTS_STORE, TIMESTAMPS_STORE],
+          "readwrite"
+        );
+        const requestStore = transaction.objectStore(REQUESTS_STORE);
+        removed.forEach((log) => requestStore.delete(log.request.id));
+
+        // Timestamps are stored in arrival order, oldest first
+        const cutoff = retention.max_age_ms
+          ? Date.now() - retention.max_age_ms
+          : 0;
+        let excess =
+          retention.max_entries && timestamps.length > retention.max_entries
+            ? timestamps.length - retention.max_entries
+            : 0;
+        transaction.objectStore(TIMESTAMPS_STORE).openCursor().onsuccess = (
+          event
+        ) => {
+          const cursor = event.target.result;
+          if (!cursor) return;
+          if (excess > 0 || cursor.value < cutoff) {
+            cursor.delete();
+            excess--;
+          }
+          cursor.continue();
+        };
+
+        transaction.oncomplete = () => resolve();
+        transaction.onerror = (event) => reject(event.target.error);
+      });
+    })
+    .catch((error) => {
+      console.error("Error applying retention:", error);
+    })
+    .finally(() => {
+      document.dispatchEvent(new CustomEvent("retention"));
+    });
+}
+
 // Function to get appropriate icon for HTTP method
 function getMethodIcon(method) {
   const icons = {
@@ -160,6 +247,37 @@ function showMessage(message, type) {
   }, 5000);
 }
 
+// Describe the GraphQL operations of a request, e.g. "query GetUser"
+function graphqlLabel(log) {
+  const operations = log.request.graphql;
+  if (!operations || operations.length === 0) return "";
+  return operations
+    .map((op) => [op.type, op.name || "(anonymous)"].filter(Boolean).join(" "))
+    .join(", ");
+}
+
+// Escape text for insertion into HTML
+function escapeHTML(text) {
+  return String(text)
+    .replace(/&/g, "&amp;")
+    .replace(/</g, "&lt;")
+    .replace(/>/g, "&gt;")
+    .replace(/"/g, "&quot;");
+}
+
+// Key used to group requests in stats and search: the path, followed by the
+// GraphQL operation for GraphQL requests, which usually share one path
+function endpointKey(log) {
+  let path;
+  try {
+    path = new URL(log.request.url).pathname;
+  } catch (e) {
+    path = log.request.url;
+  }
+  const label = graphqlLabel(log);
+  return label ? `${path} ${label}` : path;
+}
+
 // Example of how to create a method element
 function createMethodElement(method) {
   const methodEl = document.createElement("span");
@@ -190,6 +308,12 @@ function connectWebSocket(onMessageCallback) {
     try {
       const log = JSON.parse(event.data);
 
+      // Server events carry a type and are not request logs
+      if (log.type) {
+        handleServerEvent(log);
+        return;
+      }
+
       // Save to IndexedDB instead of sessionStorage
       saveRequest(log).catch((error) => {
         console.error("Error saving to IndexedDB:", error);
@@ -235,6 +359,27 @@ function connectWebSocket(onMessageCallback) {
   };
 }
 
+// Handle server events sent over the WebSocket
+function handleServerEvent(event) {
+  if (event.type === "tunnel_state") {
+    renderTunnel(event.data || {});
+    return;
+  }
+  if (event.type === "config_reload") {
+    const result = event.data || {};
+    if (result.error) {
+      showError("Configuration reload failed: " + result.error);
+    } else if (result.restart_required && result.restart_required.length) {
+      showInfo(
+        "Configuration reloaded. Restart DRIFT to apply: " +
+          result.restart_required.join(", ")
+      );
+    } else if (result.applied && result.applied.length) {
+      showSuccess("Configuration reloaded: " + result.applied.join(", "));
+    }
+  }
+}
+
 // Add loading indicator function
 function showLoading(elementId) {
   const element = document.getElementById(elementId);
@@ -253,6 +398,70 @@ function hideLoading(elementId) {
   }
 }
 
+// tunnelLabel describes a tunnel that has no public URL to show
+function tunnelLabel(tunnel) {
+  const provider = tunnel.provider || "tunnel";
+  switch (tunnel.state) {
+    case "disabled":
+      return "Public tunnel disabled";
+    case "starting":
+      return tunnel.restarts
+        ? `Restarting ${provider} tunnel (attempt ${tunnel.restarts})...`
+        : `Starting ${provider} tunnel...`;
+    case "ready":
+      return tunnel.access
+        ? `Private ${provider} share, reach it with: ${tunnel.access}`
+        : "Not available";
+    case "restarting":
+      return `${provider} tunnel down, restarting: ${tunnel.error || "unknown error"}`;
+    case "failed":
+      return `${provider} tunnel failed: ${tunnel.error || "unknown error"}`;
+    default:
+      return "Not available";
+  }
+}
+
+// renderTunnel shows the public URL of a tunnel, or why there is none. A
+// degraded tunnel keeps its URL, flagged as unreachable.
+function renderTunnel(tunnel) {
+  const zrokEl = document.getElementById("zrok-url");
+  if (!zrokEl) return;
+
+  const up = tunnel.state === "ready" || tunnel.state === "degraded";
+  const newURL = up ? tunnel.url : "";
+  const degraded = tunnel.state === "degraded";
+
+  if (newURL && newURL.startsWith("http")) {
+    const currentURL = zrokEl.querySelector("a")?.getAttribute("href");
+    if (
+      !zrokEl.classList.contains("has-url") ||
+      currentURL !== newURL ||
+      zrokEl.classList.contains("degraded") !== degraded
+    ) {
+      zrokEl.innerHTML = `Public URL: <a href="${newURL}" target="_blank">${newURL}</a>`;
+      if (degraded) {
+        const warning = document.createElement("span");
+        warning.className = "tunnel-warning";
+        warning.textContent = " (unreachable)";
+        warning.title = tunnel.error || "";
+        zrokEl.appendChild(warning);
+      }
+      zrokEl.classList.add("has-url");
+    }
+  } else {
+    const fallbackText = `Public URL: ${tunnelLabel(tunnel)}`;
+    if (
+      zrokEl.textContent !== fallbackText ||
+      zrokEl.classList.contains("has-url")
+    ) {
+      zrokEl.textContent = fallbackText;
+      zrokEl.classList.remove("has-url");
+    }
+  }
+  zrokEl.classList.toggle("degraded", degraded);
+  hideLoading("zrok-url");
+}
+
 // Update status from server
 function updateStatus() {
   fetch("/status")
@@ -293,32 +502,11 @@ function updateStatus() {
         hideLoading("server-status");
       }
 
-      const zrokEl = document.getElementById("zrok-url");
-      if (zrokEl) {
-        const currentContent = zrokEl.textContent
-          ?.replace("Public URL: ", "")
-          .trim();
-        const newURL = data.zrokURL?.trim();
-
-        if (newURL && newURL.startsWith("http")) {
-          if (
-            !zrokEl.classList.contains("has-url") ||
-            currentContent !== newURL
-          ) {
-            zrokEl.innerHTML = `Public URL: <a href="${newURL}" target="_blank">${newURL}</a>`;
-            zrokEl.classList.add("has-url");
-          }
-        } else {
-          const fallbackText = `Public URL: ${newURL || "Not available"}`;
-          if (
-            zrokEl.textContent !== fallbackText ||
-            zrokEl.classList.contains("has-url")
-          ) {
-            zrokEl.textContent = fallbackText;
-            zrokEl.classList.remove("has-url");
-          }
-        }
-        hideLoading("zrok-url");
+      renderTunnel(data.tunnel || {});
+
+      if (data.retention) {
+        retention = data.retention;
+        applyRetention();
       }
     })
     .catch((error) => {
@@ -442,7 +630,43 @@ function initializeTopBar() {
   setInterval(updateStatus, 5000);
 }
 
+// Show who is logged in when auth is enabled. Viewers get the inspector
+// without the controls that need the admin role.
+function setupSession() {
+  fetch("/api/session")
+    .then((response) => response.json())
+    .then((session) => {
+      if (!session.auth_enabled) return;
+      document.body.dataset.role = session.role;
+
+      const menu = document.querySelector(".sidebar-menu");
+      if (!menu) return;
+      const logout = document.createElement("a");
+      logout.href = "#";
+      logout.className = "sidebar-item sidebar-logout";
+      logout.dataset.tooltip = `Log out ${session.user}`;
+      logout.innerHTML = `
+        <span class="sidebar-icon">
+          <span class="material-icons">logout</span>
+        </span>
+        <span class="sidebar-label"></span>
+      `;
+      logout.querySelector(".sidebar-label").textContent =
+        `Log out (${session.user}, ${session.role})`;
+      logout.addEventListener("click", (e) => {
+        e.preventDefault();
+        fetch("/api/logout", { method: "POST" }).finally(() => {
+          window.location.href = "/inspector/login";
+        });
+      });
+      menu.appendChild(logout);
+    })
+    .catch((error) => console.error("Failed to load session:", error));
+}
+
 document.addEventListener("DOMContentLoaded", () => {
+  setupSession();
+
   // Add to existing initialization
   if (document.querySelector(".top-bar")) {
     console.log("Common.js: Initializing top bar");
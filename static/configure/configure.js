@@ -30,9 +30,19 @@ document.addEventListener("DOMContentLoaded", () => {
       button.textContent = "Connecting...";
       button.disabled = true;
 
-      fetch(form.action, {
-        method: form.method,
-        body: formData,
+      const payload = {
+        backend: formData.get("port"),
+        tunnel_mode: formData.get("zrok_option") || "auto",
+      };
+      if (payload.tunnel_mode === "custom") {
+        payload.zrok_token = formData.get("zrok_token") || "";
+        payload.zrok_port = formData.get("zrok_port") || "";
+      }
+
+      fetch("/api/config", {
+        method: "PUT",
+        headers: { "Content-Type": "application/json" },
+        body: JSON.stringify(payload),
       })
         .then((response) => {
           if (response.ok) {
@@ -45,8 +55,11 @@ document.addEventListener("DOMContentLoaded", () => {
                 showError("Failed to clear data: " + error.message);
               });
           } else {
-            return response.text().then((text) => {
-              throw new Error(text);
+            return response.json().then((data) => {
+              const details = (data.fields || [])
+                .map((field) => `${field.field}: ${field.message}`)
+                .join("\n");
+              throw new Error(details || data.error);
             });
           }
         })
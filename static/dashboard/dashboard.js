@@ -123,11 +123,31 @@ function addRequestToUI(log) {
     ? method.toLowerCase()
     : "unknown";
 
+  // GraphQL requests share a path, so show their operation too
+  const operation = graphqlLabel(log);
+  const graphqlErrors = (log.response.graphql_errors || []).length;
+  const operationBadge = operation
+    ? `<span class="graphql-op${
+        graphqlErrors ? " graphql-op-error" : ""
+      }" title="${graphqlErrors} GraphQL error(s)">${escapeHTML(
+        operation
+      )}</span>`
+    : "";
+
+  // Requests rejected by the access control of the public port
+  const deniedBadge = log.response.denied
+    ? `<span class="denied-flag" title="Rejected by access control">${escapeHTML(
+        deniedLabel(log.response.denied)
+      )}</span>`
+    : "";
+
   requestItem.innerHTML = `
     <div class="request-list-content">
       <div class="request-path">
         <span class="method method-${methodClass}">${method}</span>
         ${path}
+        ${operationBadge}
+        ${deniedBadge}
       </div>
       <div class="request-time" data-timestamp="${log.request.timestamp}">${formattedTime}</div>
     </div>
@@ -212,33 +232,16 @@ function displayRequestDetails(log) {
     : "unknown";
 
   // Format headers using the same structure as overview
-  let requestHeadersContent =
-    "<div class='details-row'><div class='details-value'><em>No headers</em></div></div>";
-  if (request.headers && Object.keys(request.headers).length > 0) {
-    requestHeadersContent = "";
-    for (const [key, value] of Object.entries(request.headers)) {
-      requestHeadersContent += `
-        <div class="details-row">
-          <div class="details-label">${key}</div>
-          <div class="details-value">${value}</div>
-        </div>
-      `;
-    }
-  }
-
-  let responseHeadersContent =
-    "<div class='details-row'><div class='details-value'><em>No headers</em></div></div>";
-  if (response.headers && Object.keys(response.headers).length > 0) {
-    responseHeadersContent = "";
-    for (const [key, value] of Object.entries(response.headers)) {
-      responseHeadersContent += `
-        <div class="details-row">
-          <div class="details-label">${key}</div>
-          <div class="details-value">${value}</div>
-        </div>
-      `;
-    }
-  }
+  const requestHeadersContent = formatHeaderRows(
+    request.header_list,
+    request.headers,
+    request.trailers
+  );
+  const responseHeadersContent = formatHeaderRows(
+    response.header_list,
+    response.headers,
+    response.trailers
+  );
 
   // Format bodies
   let rawRequestBody = "";
@@ -249,7 +252,11 @@ function displayRequestDetails(log) {
         ? request.body
         : JSON.stringify(request.body);
 
-    if (
+    if (request.body_parsed) {
+      requestBody = formatParsedBody(request, "request");
+    } else if (request.body_encoding === "base64") {
+      requestBody = formatBinaryBody(request);
+    } else if (
       request.headers &&
       request.headers["Content-Type"] &&
       request.headers["Content-Type"].includes("application/json")
@@ -276,7 +283,11 @@ function displayRequestDetails(log) {
         ? response.body
         : JSON.stringify(response.body);
 
-    if (
+    if (response.body_parsed) {
+      responseBody = formatParsedBody(response, "response");
+    } else if (response.body_encoding === "base64") {
+      responseBody = formatBinaryBody(response);
+    } else if (
       response.headers &&
       response.headers["Content-Type"] &&
       response.headers["Content-Type"].includes("application/json")
@@ -340,6 +351,13 @@ function displayRequestDetails(log) {
     response.status_code
   }</span>
               ${response.status_text || ""}
+              ${
+                response.denied
+                  ? `<span class="denied-flag">${escapeHTML(
+                      deniedLabel(response.denied)
+                    )}</span>`
+                  : ""
+              }
             </div>
           </div>
           <div class="details-row">
@@ -352,12 +370,16 @@ function displayRequestDetails(log) {
           </div>
           <div class="details-row">
             <div class="details-label">IP</div>
-            <div class="details-value">${request.client_ip || "N/A"}</div>
+            <div class="details-value">${formatClient(request)}</div>
           </div>
         </div>
       </div>
     </div>
 
+    ${formatGraphQLSection(log)}
+
+    ${formatAuthSection(log)}
+
       <div class="details-section collapsed">
       <div class="section-title">Request Headers</div>
       <div class="section-content">
@@ -374,6 +396,7 @@ function displayRequestDetails(log) {
           <button class="copy-btn" data-content="${encodeURIComponent(
             rawRequestBody
           )}">Copy</button>
+          ${formatTruncationNote(request, "request")}
           <pre class="body-content json-formatter">${requestBody}</pre>
         </div>
       </div>
@@ -397,6 +420,7 @@ function displayRequestDetails(log) {
           <button class="copy-btn" data-content="${encodeURIComponent(
             rawResponseBody
           )}">Copy</button>
+          ${formatTruncationNote(response, "response")}
           <pre class="body-content json-formatter">${responseBody}</pre>
         </div>
       </div>
@@ -449,11 +473,13 @@ function setupSearch() {
       const method = requestData.request.method.toLowerCase();
       const url = requestData.request.url.toLowerCase();
       const status = requestData.response.status_code.toString();
+      const operation = graphqlLabel(requestData).toLowerCase();
 
       if (
         method.includes(searchTerm) ||
         url.includes(searchTerm) ||
-        status.includes(searchTerm)
+        status.includes(searchTerm) ||
+        operation.includes(searchTerm)
       ) {
         item.style.display = "";
       } else {
@@ -520,6 +546,31 @@ function setupClearRequests() {
   });
 }
 
+// Drop the requests that fall outside the retention limits from the list
+function pruneRequests() {
+  const kept = new Set(
+    retainRequests(Object.values(requestCache)).map((log) => log.request.id)
+  );
+  Object.keys(requestCache).forEach((id) => {
+    if (kept.has(id)) return;
+    delete requestCache[id];
+    const item = document.querySelector(
+      `#requests li[data-id="${CSS.escape(id)}"]`
+    );
+    if (item) item.remove();
+    if (selectedRequestId === id) {
+      selectedRequestId = null;
+      document.getElementById("details").innerHTML = `
+        <div class="empty-state">
+          <div class="empty-icon">👈</div>
+          <div class="empty-text">Select a request to view details</div>
+        </div>
+      `;
+    }
+  });
+  toggleEmptyState();
+}
+
 // Setup section toggles
 function setupDetailToggles() {
   document.addEventListener("click", (e) => {
@@ -560,6 +611,8 @@ document.addEventListener("DOMContentLoaded", () => {
 
   // Start timer to update relative timestamps
   setInterval(updateRelativeTimestamps, 30000); // Update every 30 seconds
+
+  document.addEventListener("retention", pruneRequests);
 });
 
 // Replay a request
@@ -677,6 +730,310 @@ function showRequestEditor(log) {
   });
 }
 
+// Explain that only the head of a large body was captured and link to the
+// full body when it was spilled to disk
+function formatTruncationNote(part, direction) {
+  if (!part.body_truncated) return "";
+  const captured = part.body_encoded_size || part.body_size || 0;
+  const note = `<em>Showing the first ${captured} of ${
+    part.body_total_size
+  } bytes</em>`;
+  if (!part.body_spilled) return `<div class="body-note">${note}</div>`;
+  return `<div class="body-note">${note} &middot; <a href="/api/bodies/${encodeURIComponent(
+    part.id
+  )}/${direction}" download>Download full body</a></div>`;
+}
+
+// Format the client address with its location and the peer it came through
+// deniedLabel describes why access control rejected a request
+function deniedLabel(reason) {
+  const labels = {
+    address_not_allowed: "denied: address",
+    rate_limited: "denied: rate limit",
+    bad_credentials: "denied: credentials",
+    bad_secret: "denied: secret",
+  };
+  return labels[reason] || `denied: ${reason}`;
+}
+
+function formatClient(request) {
+  if (!request.client_ip) return "N/A";
+
+  const details = [];
+  const geo = request.geo;
+  if (geo) {
+    const place = [geo.city, geo.region, geo.country_name || geo.country]
+      .filter(Boolean)
+      .join(", ");
+    if (place) details.push(place);
+    if (geo.asn) {
+      details.push(`AS${geo.asn}${geo.organization ? " " + geo.organization : ""}`);
+    }
+  }
+  const peer = (request.remote_addr || "").replace(/^\[?(.*?)\]?:\d+$/, "$1");
+  if (peer && peer !== request.client_ip) {
+    details.push(`via ${request.remote_addr}`);
+  }
+
+  return `${escapeHTML(request.client_ip)}${
+    details.length ? ` <em>${escapeHTML(details.join(" · "))}</em>` : ""
+  }`;
+}
+
+// Format the GraphQL operations of a request and the errors of its response
+function formatGraphQLSection(log) {
+  const operations = log.request.graphql;
+  if (!operations || operations.length === 0) return "";
+
+  let rows = "";
+  operations.forEach((op) => {
+    const details = [];
+    if (op.persisted_query_hash) {
+      details.push(`persisted query ${escapeHTML(op.persisted_query_hash)}`);
+    }
+    rows += `
+      <div class="details-row">
+        <div class="details-label">${escapeHTML(op.type || "operation")}</div>
+        <div class="details-value">${escapeHTML(
+          op.name || "(anonymous)"
+        )} ${details.length ? `<em>${details.join(", ")}</em>` : ""}</div>
+      </div>
+    `;
+    if (op.variables) {
+      rows += `
+        <div class="details-row">
+          <div class="details-label">Variables</div>
+          <div class="details-value"><pre class="json-formatter">${formatJSON(
+            op.variables
+          )}</pre></div>
+        </div>
+      `;
+    }
+  });
+  (log.response.graphql_errors || []).forEach((error) => {
+    const path = error.path ? ` <em>at ${escapeHTML(error.path.join("."))}</em>` : "";
+    rows += `
+      <div class="details-row">
+        <div class="details-label">Error</div>
+        <div class="details-value">${escapeHTML(error.message)}${path}</div>
+      </div>
+    `;
+  });
+
+  return `
+    <div class="details-section">
+      <div class="section-title">GraphQL</div>
+      <div class="section-content">
+        <div class="details-table">${rows}</div>
+      </div>
+    </div>
+  `;
+}
+
+// Format the decoded Authorization header and the cookies of an exchange
+function formatAuthSection(log) {
+  const auth = log.request.auth;
+  const requestCookies = log.request.cookies || [];
+  const responseCookies = log.response.cookies || [];
+  if (!auth && requestCookies.length === 0 && responseCookies.length === 0) {
+    return "";
+  }
+
+  const row = (label, value) => `
+    <div class="details-row">
+      <div class="details-label">${label}</div>
+      <div class="details-value">${value}</div>
+    </div>
+  `;
+  const flag = (text, bad) =>
+    `<span class="auth-flag${bad ? " auth-flag-bad" : ""}">${text}</span>`;
+
+  let rows = "";
+  if (auth && auth.scheme === "basic") {
+    rows += row("Basic", `${escapeHTML(auth.username || "")} ${escapeHTML(auth.password || "")}`);
+  } else if (auth && !auth.jwt) {
+    rows += row("Bearer", "<em>opaque token</em>");
+  } else if (auth && auth.jwt) {
+    const jwt = auth.jwt;
+    const flags = [];
+    if (jwt.expired) flags.push(flag("expired", true));
+    if (jwt.not_yet_valid) flags.push(flag("not yet valid", true));
+    if (jwt.signature === "valid") flags.push(flag("signature valid", false));
+    if (jwt.signature === "invalid") flags.push(flag("signature invalid", true));
+    rows += row("JWT", `${escapeHTML(jwt.algorithm || "")} ${flags.join(" ")}`);
+    if (jwt.error) rows += row("Error", escapeHTML(jwt.error));
+    if (jwt.signature_error) rows += row("Signature", escapeHTML(jwt.signature_error));
+    if (jwt.issuer) rows += row("Issuer", escapeHTML(jwt.issuer));
+    if (jwt.subject) rows += row("Subject", escapeHTML(jwt.subject));
+    if (jwt.audience) rows += row("Audience", escapeHTML(jwt.audience.join(", ")));
+    if (jwt.issued_at) rows += row("Issued", escapeHTML(jwt.issued_at));
+    if (jwt.not_before) rows += row("Not Before", escapeHTML(jwt.not_before));
+    if (jwt.expires_at) rows += row("Expires", escapeHTML(jwt.expires_at));
+    if (jwt.header) {
+      rows += row("Header", `<pre class="json-formatter">${formatJSON(jwt.header)}</pre>`);
+    }
+    if (jwt.claims) {
+      rows += row("Claims", `<pre class="json-formatter">${formatJSON(jwt.claims)}</pre>`);
+    }
+  }
+
+  requestCookies.forEach((cookie) => {
+    rows += row(
+      "Cookie",
+      cookie.error
+        ? `<em>${escapeHTML(cookie.error)}</em>`
+        : `${escapeHTML(cookie.name)} = ${escapeHTML(cookie.value)}`
+    );
+  });
+  responseCookies.forEach((cookie) => {
+    if (cookie.error) {
+      rows += row("Set-Cookie", `<em>${escapeHTML(cookie.error)}</em>`);
+      return;
+    }
+    const attributes = [];
+    if (cookie.domain) attributes.push(`Domain=${cookie.domain}`);
+    if (cookie.path) attributes.push(`Path=${cookie.path}`);
+    if (cookie.expires) attributes.push(`Expires=${cookie.expires}`);
+    if (cookie.max_age) attributes.push(`Max-Age=${cookie.max_age}`);
+    if (cookie.same_site) attributes.push(`SameSite=${cookie.same_site}`);
+    if (cookie.secure) attributes.push("Secure");
+    if (cookie.http_only) attributes.push("HttpOnly");
+    if (cookie.partitioned) attributes.push("Partitioned");
+    rows += row(
+      "Set-Cookie",
+      `${escapeHTML(cookie.name)} = ${escapeHTML(cookie.value)} <em>${escapeHTML(
+        attributes.join("; ")
+      )}</em>`
+    );
+  });
+
+  return `
+    <div class="details-section">
+      <div class="section-title">Auth &amp; Cookies</div>
+      <div class="section-content">
+        <div class="details-table">${rows}</div>
+      </div>
+    </div>
+  `;
+}
+
+// Format the structure the server extracted from form, multipart, XML and
+// NDJSON bodies
+function formatParsedBody(part, direction) {
+  const parsed = part.body_parsed;
+  let content = "";
+
+  switch (parsed.kind) {
+    case "form":
+    case "multipart":
+      (parsed.fields || []).forEach((field) => {
+        content += `<span class="json-key">${escapeHTML(
+          field.name
+        )}</span> = <span class="json-string">${escapeHTML(
+          field.value
+        )}</span>\n`;
+      });
+      (parsed.files || []).forEach((file, index) => {
+        const name = escapeHTML(file.filename || "(no filename)");
+        const link = file.downloadable
+          ? `<a href="/api/bodies/${encodeURIComponent(
+              part.id
+            )}/${direction}/files/${index}" download>${name}</a>`
+          : name;
+        content += `<span class="json-key">${escapeHTML(
+          file.field
+        )}</span> = ${link} <em>(${escapeHTML(
+          file.content_type || "unknown type"
+        )}, ${file.size} bytes)</em>\n`;
+      });
+      break;
+    case "xml":
+      content = escapeHTML(parsed.xml || "");
+      break;
+    case "ndjson":
+      content = (parsed.records || [])
+        .map((record) => formatJSON(record))
+        .join("\n");
+      break;
+    case "grpc":
+    case "protobuf":
+      content = (parsed.messages || [])
+        .map((message) => {
+          const label = escapeHTML(
+            message.type || (message.raw ? "raw fields" : "message")
+          );
+          const error = message.error
+            ? ` <em>${escapeHTML(message.error)}</em>`
+            : "";
+          return `<em>${label} (${message.size} bytes)</em>${error}\n${formatJSON(
+            message.message
+          )}`;
+        })
+        .join("\n\n");
+      if (part.grpc_status) {
+        const status = part.grpc_status;
+        content += `\n\n<em>grpc-status: ${status.code} ${escapeHTML(
+          status.status
+        )}${status.message ? " - " + escapeHTML(status.message) : ""}</em>`;
+      }
+      break;
+  }
+
+  if (parsed.error) {
+    content += `\n<em>${escapeHTML(parsed.error)}</em>`;
+  }
+  return content || "<em>No body</em>";
+}
+
+// Describe a binary body, which is captured as base64
+function formatBinaryBody(part) {
+  const type = part.body_content_type || "unknown type";
+  const size = part.body_size || 0;
+  const hash = part.body_sha256
+    ? `<br>sha256: ${escapeHTML(part.body_sha256)}`
+    : "";
+  return `<em>Binary body (${escapeHTML(type)}, ${escapeHTML(
+    size
+  )} bytes)</em>${hash}`;
+}
+
+// Format header rows, preferring the full list that keeps repeated headers
+// and wire order, and falling back to the single-value map of older captures
+function formatHeaderRows(headerList, headers, trailers) {
+  let fields = headerList;
+  if (!fields || fields.length === 0) {
+    fields = Object.entries(headers || {}).map(([name, value]) => ({
+      name,
+      value,
+    }));
+  }
+
+  if (fields.length === 0 && (!trailers || trailers.length === 0)) {
+    return "<div class='details-row'><div class='details-value'><em>No headers</em></div></div>";
+  }
+
+  let content = "";
+  fields.forEach((field) => {
+    content += `
+      <div class="details-row">
+        <div class="details-label">${escapeHTML(field.name)}</div>
+        <div class="details-value">${escapeHTML(field.value)}</div>
+      </div>
+    `;
+  });
+  (trailers || []).forEach((field) => {
+    content += `
+      <div class="details-row">
+        <div class="details-label">${escapeHTML(
+          field.name
+        )} <em>(trailer)</em></div>
+        <div class="details-value">${escapeHTML(field.value)}</div>
+      </div>
+    `;
+  });
+  return content;
+}
+
 // Format headers for editing
 function formatHeadersForEdit(headers) {
   if (!headers) return "";
@@ -711,7 +1068,23 @@ function executeRequest(request) {
 
   // Add body for non-GET requests
   if (request.method !== "GET" && request.body) {
-    options.body = request.body;
+    if (request.body_encoding === "base64") {
+      options.body = Uint8Array.from(atob(request.body), (c) =>
+        c.charCodeAt(0)
+      );
+    } else {
+      options.body = request.body;
+    }
+
+    // Captured bodies are stored decompressed
+    if (request.body_content_encoding) {
+      options.headers = { ...options.headers };
+      Object.keys(options.headers).forEach((key) => {
+        if (key.toLowerCase() === "content-encoding") {
+          delete options.headers[key];
+        }
+      });
+    }
   }
 
   // Show loading indicator
@@ -728,7 +1101,7 @@ function executeRequest(request) {
 
   // Create a proxy URL that keeps the path and query parameters but uses the current host
   // This ensures the request goes through our proxy
-  const currentHost = window.location.host; // e.g., "localhost:4040"
+  const currentHost = window.location.host; // e.g., "localhost:4041"
   const currentProtocol = window.location.protocol; // e.g., "http:"
 
   // Keep the original path and query string
@@ -0,0 +1,62 @@
+// Only redirect to paths of the inspector itself after logging in
+function nextPage() {
+  const next = new URLSearchParams(window.location.search).get("next");
+  if (next && next.startsWith("/") && !next.startsWith("//")) {
+    return next;
+  }
+  return "/inspector/dashboard";
+}
+
+document.addEventListener("DOMContentLoaded", () => {
+  const form = document.getElementById("login-form");
+  const tokenFields = document.querySelector(".token-fields");
+  const passwordFields = document.querySelector(".password-fields");
+  const errorEl = document.getElementById("login-error");
+
+  document.querySelectorAll('input[name="login_mode"]').forEach((radio) => {
+    radio.addEventListener("change", () => {
+      const usePassword = radio.value === "password";
+      tokenFields.style.display = usePassword ? "none" : "flex";
+      passwordFields.style.display = usePassword ? "flex" : "none";
+      (usePassword
+        ? document.getElementById("username")
+        : document.getElementById("token")
+      ).focus();
+    });
+  });
+
+  form.addEventListener("submit", (e) => {
+    e.preventDefault();
+    const formData = new FormData(form);
+    const payload =
+      formData.get("login_mode") === "password"
+        ? {
+            username: formData.get("username"),
+            password: formData.get("password"),
+          }
+        : { token: formData.get("token") };
+
+    const button = form.querySelector("button");
+    button.disabled = true;
+    errorEl.textContent = "";
+
+    fetch("/api/login", {
+      method: "POST",
+      headers: { "Content-Type": "application/json" },
+      body: JSON.stringify(payload),
+    })
+      .then((response) => {
+        if (response.ok) {
+          window.location.href = nextPage();
+          return;
+        }
+        return response.json().then((data) => {
+          throw new Error(data.error);
+        });
+      })
+      .catch((error) => {
+        errorEl.textContent = `Login failed: ${error.message}`;
+        button.disabled = false;
+      });
+  });
+});